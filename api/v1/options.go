@@ -17,6 +17,7 @@ type Options struct {
 	binlogOps                   []func(options *query.RedisOptions)
 	dbOps                       []func(options *query.MysqlOptions)
 	exportOps                   []func(options *delay.ExportOptions)
+	delayQueue                  *delay.Queue
 	redis                       redis.UniversalClient
 	cachePrefix                 string
 	operationAllowedToDelete    bool
@@ -61,6 +62,15 @@ func WithExportOps(ops ...func(options *delay.ExportOptions)) func(*Options) {
 	}
 }
 
+// WithDelayQueue delay queue for task/cron inspector
+func WithDelayQueue(qu *delay.Queue) func(*Options) {
+	return func(options *Options) {
+		if qu != nil {
+			getOptionsOrSetDefault(options).delayQueue = qu
+		}
+	}
+}
+
 func WithRedis(rd redis.UniversalClient) func(*Options) {
 	return func(options *Options) {
 		if rd != nil {
@@ -234,3 +244,11 @@ func (ops *Options) addCtx(ctx context.Context) {
 	ops.dbOps = append(ops.dbOps, query.WithMysqlCtx(ctx))
 	ops.exportOps = append(ops.exportOps, delay.WithExportCtx(ctx))
 }
+
+// get delay queue, fail the request if it is not configured
+func (ops *Options) getDelayQueue() *delay.Queue {
+	if ops.delayQueue == nil {
+		resp.CheckErr(delay.ErrQueueNil)
+	}
+	return ops.delayQueue
+}
@@ -57,3 +57,268 @@ func BatchDeleteDelayExportByIds(options ...func(*Options)) gin.HandlerFunc {
 		resp.Success()
 	}
 }
+
+// CancelDelayExport
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Success 201 {object} resp.Resp "success"
+// @Tags *Delay
+// @Description CancelDelayExport
+// @Param ids body req.Ids true "ids"
+// @Router /delay/export/cancel [PATCH]
+func CancelDelayExport(options ...func(*Options)) gin.HandlerFunc {
+	ops := ParseOptions(options...)
+	return func(c *gin.Context) {
+		ctx := tracing.RealCtx(c)
+		_, span := tracer.Start(ctx, tracing.Name(tracing.Rest, "CancelDelayExport"))
+		defer span.End()
+		var r req.Ids
+		req.ShouldBind(c, &r)
+		ops.addCtx(c)
+		ex := delay.NewExport(ops.exportOps...)
+		err := ex.CancelByIds(r.Uints())
+		resp.CheckErr(err)
+		resp.Success()
+	}
+}
+
+// FindDelayTask
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Success 201 {object} resp.Resp "success"
+// @Tags *Delay
+// @Description FindDelayTask
+// @Param params query req.DelayTask true "params"
+// @Router /delay/task/list [GET]
+func FindDelayTask(options ...func(*Options)) gin.HandlerFunc {
+	ops := ParseOptions(options...)
+	return func(c *gin.Context) {
+		ctx := tracing.RealCtx(c)
+		_, span := tracer.Start(ctx, tracing.Name(tracing.Rest, "FindDelayTask"))
+		defer span.End()
+		var r req.DelayTask
+		req.ShouldBind(c, &r)
+		qu := ops.getDelayQueue()
+		list, err := qu.FindTask(&r)
+		resp.CheckErr(err)
+		resp.SuccessWithPageData(list, &[]resp.DelayTask{}, r.Page)
+	}
+}
+
+// RunDelayTask
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Success 201 {object} resp.Resp "success"
+// @Tags *Delay
+// @Description RunDelayTask
+// @Param params body req.DelayTaskUids true "params"
+// @Router /delay/task/run [PATCH]
+func RunDelayTask(options ...func(*Options)) gin.HandlerFunc {
+	ops := ParseOptions(options...)
+	return func(c *gin.Context) {
+		ctx := tracing.RealCtx(c)
+		_, span := tracer.Start(ctx, tracing.Name(tracing.Rest, "RunDelayTask"))
+		defer span.End()
+		var r req.DelayTaskUids
+		req.ShouldBind(c, &r)
+		qu := ops.getDelayQueue()
+		err := qu.RunTask(r.Uids...)
+		resp.CheckErr(err)
+		resp.Success()
+	}
+}
+
+// RequeueDelayTask
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Success 201 {object} resp.Resp "success"
+// @Tags *Delay
+// @Description RequeueDelayTask(requeue all archived tasks if uids is empty)
+// @Param params body req.DelayTaskUids true "params"
+// @Router /delay/task/requeue [PATCH]
+func RequeueDelayTask(options ...func(*Options)) gin.HandlerFunc {
+	ops := ParseOptions(options...)
+	return func(c *gin.Context) {
+		ctx := tracing.RealCtx(c)
+		_, span := tracer.Start(ctx, tracing.Name(tracing.Rest, "RequeueDelayTask"))
+		defer span.End()
+		var r req.DelayTaskUids
+		req.ShouldBind(c, &r)
+		qu := ops.getDelayQueue()
+		count, err := qu.RequeueArchived(r.Uids...)
+		resp.CheckErr(err)
+		resp.SuccessWithData(count)
+	}
+}
+
+// FindDelayCron
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Success 201 {object} resp.Resp "success"
+// @Tags *Delay
+// @Description FindDelayCron
+// @Param params query req.DelayCron true "params"
+// @Router /delay/cron/list [GET]
+func FindDelayCron(options ...func(*Options)) gin.HandlerFunc {
+	ops := ParseOptions(options...)
+	return func(c *gin.Context) {
+		ctx := tracing.RealCtx(c)
+		_, span := tracer.Start(ctx, tracing.Name(tracing.Rest, "FindDelayCron"))
+		defer span.End()
+		var r req.DelayCron
+		req.ShouldBind(c, &r)
+		qu := ops.getDelayQueue()
+		list, err := qu.FindCron(r)
+		resp.CheckErr(err)
+		resp.SuccessWithData(list)
+	}
+}
+
+// PauseDelayCron
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Success 201 {object} resp.Resp "success"
+// @Tags *Delay
+// @Description PauseDelayCron
+// @Param params body req.DelayTaskUids true "params"
+// @Router /delay/cron/pause [PATCH]
+func PauseDelayCron(options ...func(*Options)) gin.HandlerFunc {
+	ops := ParseOptions(options...)
+	return func(c *gin.Context) {
+		ctx := tracing.RealCtx(c)
+		_, span := tracer.Start(ctx, tracing.Name(tracing.Rest, "PauseDelayCron"))
+		defer span.End()
+		var r req.DelayTaskUids
+		req.ShouldBind(c, &r)
+		qu := ops.getDelayQueue()
+		err := qu.PauseCron(r.Uids...)
+		resp.CheckErr(err)
+		resp.Success()
+	}
+}
+
+// ResumeDelayCron
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Success 201 {object} resp.Resp "success"
+// @Tags *Delay
+// @Description ResumeDelayCron
+// @Param params body req.DelayTaskUids true "params"
+// @Router /delay/cron/resume [PATCH]
+func ResumeDelayCron(options ...func(*Options)) gin.HandlerFunc {
+	ops := ParseOptions(options...)
+	return func(c *gin.Context) {
+		ctx := tracing.RealCtx(c)
+		_, span := tracer.Start(ctx, tracing.Name(tracing.Rest, "ResumeDelayCron"))
+		defer span.End()
+		var r req.DelayTaskUids
+		req.ShouldBind(c, &r)
+		qu := ops.getDelayQueue()
+		err := qu.ResumeCron(r.Uids...)
+		resp.CheckErr(err)
+		resp.Success()
+	}
+}
+
+// UpdateDelayCron
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Success 201 {object} resp.Resp "success"
+// @Tags *Delay
+// @Description UpdateDelayCron
+// @Param params body req.DelayUpdateCron true "params"
+// @Router /delay/cron/update [PATCH]
+func UpdateDelayCron(options ...func(*Options)) gin.HandlerFunc {
+	ops := ParseOptions(options...)
+	return func(c *gin.Context) {
+		ctx := tracing.RealCtx(c)
+		_, span := tracer.Start(ctx, tracing.Name(tracing.Rest, "UpdateDelayCron"))
+		defer span.End()
+		var r req.DelayUpdateCron
+		req.ShouldBind(c, &r)
+		qu := ops.getDelayQueue()
+		err := qu.UpdateCronExpr(r.Uid, r.Expr)
+		resp.CheckErr(err)
+		resp.Success()
+	}
+}
+
+// FindDelayRun
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Success 201 {object} resp.Resp "success"
+// @Tags *Delay
+// @Description FindDelayRun
+// @Param params query req.DelayTaskRun true "params"
+// @Router /delay/run/list [GET]
+func FindDelayRun(options ...func(*Options)) gin.HandlerFunc {
+	ops := ParseOptions(options...)
+	return func(c *gin.Context) {
+		ctx := tracing.RealCtx(c)
+		_, span := tracer.Start(ctx, tracing.Name(tracing.Rest, "FindDelayRun"))
+		defer span.End()
+		var r req.DelayTaskRun
+		req.ShouldBind(c, &r)
+		qu := ops.getDelayQueue()
+		list, err := qu.FindRun(&r)
+		resp.CheckErr(err)
+		resp.SuccessWithPageData(list, &[]resp.DelayTaskRun{}, r.Page)
+	}
+}
+
+// FindDelayFlow
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Success 201 {object} resp.Resp "success"
+// @Tags *Delay
+// @Description FindDelayFlow
+// @Param params query req.DelayFlow true "params"
+// @Router /delay/flow/list [GET]
+func FindDelayFlow(options ...func(*Options)) gin.HandlerFunc {
+	ops := ParseOptions(options...)
+	return func(c *gin.Context) {
+		ctx := tracing.RealCtx(c)
+		_, span := tracer.Start(ctx, tracing.Name(tracing.Rest, "FindDelayFlow"))
+		defer span.End()
+		var r req.DelayFlow
+		req.ShouldBind(c, &r)
+		qu := ops.getDelayQueue()
+		list, err := qu.FindFlow(&r)
+		resp.CheckErr(err)
+		resp.SuccessWithPageData(list, &[]resp.DelayFlow{}, r.Page)
+	}
+}
+
+// CancelDelayFlow
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Success 201 {object} resp.Resp "success"
+// @Tags *Delay
+// @Description CancelDelayFlow
+// @Param params body req.DelayTaskUids true "params"
+// @Router /delay/flow/cancel [PATCH]
+func CancelDelayFlow(options ...func(*Options)) gin.HandlerFunc {
+	ops := ParseOptions(options...)
+	return func(c *gin.Context) {
+		ctx := tracing.RealCtx(c)
+		_, span := tracer.Start(ctx, tracing.Name(tracing.Rest, "CancelDelayFlow"))
+		defer span.End()
+		var r req.DelayTaskUids
+		req.ShouldBind(c, &r)
+		qu := ops.getDelayQueue()
+		err := qu.CancelFlow(r.Uids...)
+		resp.CheckErr(err)
+		resp.Success()
+	}
+}
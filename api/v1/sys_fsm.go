@@ -1,12 +1,14 @@
 package v1
 
 import (
+	"github.com/ennismar/go-helper/pkg/constant"
 	"github.com/ennismar/go-helper/pkg/query"
 	"github.com/ennismar/go-helper/pkg/req"
 	"github.com/ennismar/go-helper/pkg/resp"
 	"github.com/ennismar/go-helper/pkg/tracing"
 	"github.com/ennismar/go-helper/pkg/utils"
 	"github.com/gin-gonic/gin"
+	"strings"
 )
 
 // FindFsm
@@ -33,6 +35,202 @@ func FindFsm(options ...func(*Options)) gin.HandlerFunc {
 	}
 }
 
+// FindFsmVersion
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Success 201 {object} resp.Resp "success"
+// @Tags *Fsm
+// @Description FindFsmVersion
+// @Param params query req.FsmMachineVersion true "params"
+// @Router /fsm/version/list [GET]
+func FindFsmVersion(options ...func(*Options)) gin.HandlerFunc {
+	ops := ParseOptions(options...)
+	return func(c *gin.Context) {
+		ctx := tracing.RealCtx(c)
+		_, span := tracer.Start(ctx, tracing.Name(tracing.Rest, "FindFsmVersion"))
+		defer span.End()
+		var r req.FsmMachineVersion
+		req.ShouldBind(c, &r)
+		ops.addCtx(c)
+		q := query.NewMySql(ops.dbOps...)
+		list := q.FindFsmVersion(&r)
+		resp.SuccessWithPageData(list, &[]resp.FsmMachineVersion{}, r.Page)
+	}
+}
+
+// DiffFsmVersion
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Success 201 {object} resp.Resp "success"
+// @Tags *Fsm
+// @Description DiffFsmVersion
+// @Param params query req.FsmDiffMachineVersion true "params"
+// @Router /fsm/version/diff [GET]
+func DiffFsmVersion(options ...func(*Options)) gin.HandlerFunc {
+	ops := ParseOptions(options...)
+	return func(c *gin.Context) {
+		ctx := tracing.RealCtx(c)
+		_, span := tracer.Start(ctx, tracing.Name(tracing.Rest, "DiffFsmVersion"))
+		defer span.End()
+		var r req.FsmDiffMachineVersion
+		req.ShouldBind(c, &r)
+		ops.addCtx(c)
+		q := query.NewMySql(ops.dbOps...)
+		rp, err := q.DiffFsmVersion(r)
+		resp.CheckErr(err)
+		resp.SuccessWithData(rp)
+	}
+}
+
+// ExportFsm
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Success 201 {object} resp.Resp "success"
+// @Tags *Fsm
+// @Description ExportFsm
+// @Param params query req.FsmExportMachine true "params"
+// @Router /fsm/export [GET]
+func ExportFsm(options ...func(*Options)) gin.HandlerFunc {
+	ops := ParseOptions(options...)
+	return func(c *gin.Context) {
+		ctx := tracing.RealCtx(c)
+		_, span := tracer.Start(ctx, tracing.Name(tracing.Rest, "ExportFsm"))
+		defer span.End()
+		var r req.FsmExportMachine
+		req.ShouldBind(c, &r)
+		ops.addCtx(c)
+		q := query.NewMySql(ops.dbOps...)
+		content, err := q.ExportFsm(r)
+		resp.CheckErr(err)
+		format := constant.FsmDefinitionFormatYaml
+		if strings.EqualFold(strings.TrimSpace(r.Format), constant.FsmDefinitionFormatJson) {
+			format = constant.FsmDefinitionFormatJson
+		}
+		resp.SuccessWithData(resp.FsmMachineExport{
+			Format:  format,
+			Content: string(content),
+		})
+	}
+}
+
+// ImportFsm
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Success 201 {object} resp.Resp "success"
+// @Tags *Fsm
+// @Description ImportFsm
+// @Param params body req.FsmImportMachine true "params"
+// @Router /fsm/import [POST]
+func ImportFsm(options ...func(*Options)) gin.HandlerFunc {
+	ops := ParseOptions(options...)
+	return func(c *gin.Context) {
+		ctx := tracing.RealCtx(c)
+		_, span := tracer.Start(ctx, tracing.Name(tracing.Rest, "ImportFsm"))
+		defer span.End()
+		var r req.FsmImportMachine
+		req.ShouldBind(c, &r)
+		ops.addCtx(c)
+		q := query.NewMySql(ops.dbOps...)
+		rp, err := q.DryRunFsm(r)
+		resp.CheckErr(err)
+		// check roles/users exist
+		if ops.findRoleByIds != nil && len(rp.RoleIds) > 0 {
+			roleIds := make([]uint, 0)
+			for _, item := range ops.findRoleByIds(c, rp.RoleIds) {
+				roleIds = append(roleIds, item.Id)
+			}
+			for _, id := range rp.RoleIds {
+				if !utils.ContainsUint(roleIds, id) {
+					rp.MissingRoles = append(rp.MissingRoles, id)
+				}
+			}
+		}
+		if ops.findUserByIds != nil && len(rp.UserIds) > 0 {
+			userIds := make([]uint, 0)
+			for _, item := range ops.findUserByIds(c, rp.UserIds) {
+				userIds = append(userIds, item.Id)
+			}
+			for _, id := range rp.UserIds {
+				if !utils.ContainsUint(userIds, id) {
+					rp.MissingUsers = append(rp.MissingUsers, id)
+				}
+			}
+		}
+		if !r.DryRun {
+			if len(rp.MissingRoles) > 0 {
+				resp.CheckErr("roles %v do not exist", rp.MissingRoles)
+			}
+			if len(rp.MissingUsers) > 0 {
+				resp.CheckErr("users %v do not exist", rp.MissingUsers)
+			}
+			err = q.ImportFsm(r)
+			resp.CheckErr(err)
+		}
+		resp.SuccessWithData(rp)
+	}
+}
+
+// RenderFsm
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Success 201 {object} resp.Resp "success"
+// @Tags *Fsm
+// @Description RenderFsm
+// @Param params query req.FsmRender true "params"
+// @Router /fsm/render [GET]
+func RenderFsm(options ...func(*Options)) gin.HandlerFunc {
+	ops := ParseOptions(options...)
+	return func(c *gin.Context) {
+		ctx := tracing.RealCtx(c)
+		_, span := tracer.Start(ctx, tracing.Name(tracing.Rest, "RenderFsm"))
+		defer span.End()
+		var r req.FsmRender
+		req.ShouldBind(c, &r)
+		ops.addCtx(c)
+		q := query.NewMySql(ops.dbOps...)
+		content, err := q.RenderFsm(r)
+		resp.CheckErr(err)
+		format := constant.FsmRenderFormatDot
+		if strings.EqualFold(strings.TrimSpace(r.Format), constant.FsmRenderFormatMermaid) {
+			format = constant.FsmRenderFormatMermaid
+		}
+		resp.SuccessWithData(resp.FsmMachineRender{
+			Format:  format,
+			Content: content,
+		})
+	}
+}
+
+// FsmReport
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Success 201 {object} resp.Resp "success"
+// @Tags *Fsm
+// @Description FsmReport
+// @Param params query req.FsmReport true "params"
+// @Router /fsm/report [GET]
+func FsmReport(options ...func(*Options)) gin.HandlerFunc {
+	ops := ParseOptions(options...)
+	return func(c *gin.Context) {
+		ctx := tracing.RealCtx(c)
+		_, span := tracer.Start(ctx, tracing.Name(tracing.Rest, "FsmReport"))
+		defer span.End()
+		var r req.FsmReport
+		req.ShouldBind(c, &r)
+		ops.addCtx(c)
+		q := query.NewMySql(ops.dbOps...)
+		rp, err := q.FsmReport(r)
+		resp.CheckErr(err)
+		resp.SuccessWithData(rp)
+	}
+}
+
 // FindFsmApprovingLog
 // @Security Bearer
 // @Accept json
@@ -71,6 +269,9 @@ func FindFsmApprovingLog(options ...func(*Options)) gin.HandlerFunc {
 			for _, uu := range item.CanApprovalRoles {
 				roleIds = append(roleIds, uu.Id)
 			}
+			for _, uu := range item.PendingRoles {
+				roleIds = append(roleIds, uu.Id)
+			}
 		}
 		roles := ops.findRoleByIds(c, roleIds)
 		newRoles := make([]resp.Role, len(roles))
@@ -84,6 +285,9 @@ func FindFsmApprovingLog(options ...func(*Options)) gin.HandlerFunc {
 			for j, uu := range item.CanApprovalRoles {
 				list[i].CanApprovalRoles[j] = m1[uu.Id]
 			}
+			for j, uu := range item.PendingRoles {
+				list[i].PendingRoles[j] = m1[uu.Id]
+			}
 		}
 		userIds := make([]uint, 0)
 		for _, item := range list {
@@ -91,6 +295,9 @@ func FindFsmApprovingLog(options ...func(*Options)) gin.HandlerFunc {
 			for _, uu := range item.CanApprovalUsers {
 				userIds = append(userIds, uu.Id)
 			}
+			for _, uu := range item.PendingUsers {
+				userIds = append(userIds, uu.Id)
+			}
 		}
 		users := ops.findUserByIds(c, userIds)
 		newUsers := make([]resp.User, len(users))
@@ -104,6 +311,9 @@ func FindFsmApprovingLog(options ...func(*Options)) gin.HandlerFunc {
 			for j, uu := range item.CanApprovalUsers {
 				list[i].CanApprovalUsers[j] = m2[uu.Id]
 			}
+			for j, uu := range item.PendingUsers {
+				list[i].PendingUsers[j] = m2[uu.Id]
+			}
 		}
 		resp.SuccessWithPageData(list, &[]resp.FsmApprovingLog{}, r.Page)
 	}
@@ -5,4 +5,45 @@ const (
 	DelayExportObjPrefix      = "delay/export"
 	DelayExportEndPointSuffix = ".aliyuncs.com"
 	DelayExportObjExpire      = 3
+	DelayExportFormatCsv      = "csv"
+	DelayExportFormatXlsx     = "xlsx"
+)
+
+const (
+	DelayExportEndPending   uint = iota // exporting
+	DelayExportEndSuccess               // file is uploaded
+	DelayExportEndFailed                // export failed or stale
+	DelayExportEndCancelled             // cancelled by user
+)
+
+const (
+	DelayTaskStateScheduled = "scheduled"
+	DelayTaskStatePending   = "pending"
+	DelayTaskStateActive    = "active"
+	DelayTaskStateRetry     = "retry"
+	DelayTaskStateArchived  = "archived"
+)
+
+const (
+	DelayRunStatusSuccess uint = iota // task handler returned nil
+	DelayRunStatusFailed              // task handler returned error(asynq will retry it)
+)
+
+const (
+	DelayMisfireOnce uint = iota // run once to catch up all missed occurrences
+	DelayMisfireSkip             // skip missed occurrences, wait for the next one
+	DelayMisfireAll              // run every missed occurrence(up to misfire limit)
+)
+
+const (
+	DelayMisfireThreshold = 5  // a schedule late more than threshold seconds is missed
+	DelayMisfireLimit     = 10 // default max catch-up runs of DelayMisfireAll
+)
+
+const (
+	DelayFlowStatusPending   uint = iota // step is waiting for depends
+	DelayFlowStatusRunning               // run is running or step is enqueued
+	DelayFlowStatusSuccess               // run: all steps succeed
+	DelayFlowStatusFailed                // step failed after max retry
+	DelayFlowStatusCancelled             // cancelled by CancelFlow
 )
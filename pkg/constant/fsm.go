@@ -1,7 +1,9 @@
 package constant
 
 const (
-	FsmPrefix = "tb_fsm_"
+	FsmPrefix      = "tb_fsm_"
+	FsmSystemActor = "system"
+	FsmSlaTaskName = "fsm.sla"
 )
 
 const (
@@ -11,11 +13,59 @@ const (
 	FsmLogStatusCancelled             // approval cancelled
 )
 
+const (
+	FsmApprovalPolicyAny    uint = iota // any approver can pass the level
+	FsmApprovalPolicyAll                // all approvers must approve(countersign)
+	FsmApprovalPolicyQuorum             // at least quorum approvers must approve
+)
+
+const (
+	FsmSlaActionRemind   uint = iota // remind pending approvers
+	FsmSlaActionEscalate             // escalate to fallback approver
+	FsmSlaActionTimeout              // auto approve/refuse
+)
+
+const (
+	FsmDefinitionFormatYaml = "yaml"
+	FsmDefinitionFormatJson = "json"
+)
+
+const (
+	FsmRenderFormatDot     = "dot"
+	FsmRenderFormatMermaid = "mermaid"
+)
+
+const (
+	FsmVersionDiffAdded   = "added"
+	FsmVersionDiffRemoved = "removed"
+	FsmVersionDiffChanged = "changed"
+)
+
+const (
+	FsmOutboxEventSubmit   = "submit"
+	FsmOutboxEventApprove  = "approve"
+	FsmOutboxEventReject   = "reject"
+	FsmOutboxEventCancel   = "cancel"
+	FsmOutboxEventResubmit = "resubmit"
+	FsmOutboxEventConfirm  = "confirm"
+	FsmOutboxDedupHeader   = "x-dedup-key"
+	FsmOutboxEventHeader   = "x-fsm-event"
+)
+
+const (
+	FsmOutboxStatusPending   uint = iota // waiting relay publish
+	FsmOutboxStatusPublished             // publish confirmed by broker
+)
+
 const (
 	FsmMsgSubmitterCancel = "go-helper.fsm.msg.submitter-cancel"
 	FsmMsgEnded           = "go-helper.fsm.msg.ended"
 	FsmMsgConfigChanged   = "go-helper.fsm.msg.config-changed"
 	FsmMsgManualCancel    = "go-helper.fsm.msg.manual-cancel"
+	FsmMsgAutoRemind      = "go-helper.fsm.msg.auto-remind"
+	FsmMsgAutoEscalate    = "go-helper.fsm.msg.auto-escalate"
+	FsmMsgAutoApproved    = "go-helper.fsm.msg.auto-approved"
+	FsmMsgAutoRefused     = "go-helper.fsm.msg.auto-refused"
 )
 
 const (
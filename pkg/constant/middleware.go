@@ -14,6 +14,7 @@ const (
 	MiddlewareSpanIdCtxKey                   = "SpanId"
 	MiddlewareTransactionTxCtxKey            = "tx"
 	MiddlewareTransactionForceCommitCtxKey   = "ForceCommitTx"
+	MiddlewareTransactionHooksCtxKey         = "TxHooks"
 	MiddlewareJwtUserCtxKey                  = "user"
 	MiddlewareSignSeparator                  = "|"
 	MiddlewareSignTokenHeaderKey             = "X-Sign-Token"
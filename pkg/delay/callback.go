@@ -0,0 +1,153 @@
+package delay
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/ennismar/go-helper/pkg/constant"
+	"github.com/ennismar/go-helper/pkg/log"
+	"github.com/ennismar/go-helper/pkg/middleware"
+	"github.com/ennismar/go-helper/pkg/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/hibiken/asynq"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// callbackBodyLimit max bytes of callback response body saved in task result
+const callbackBodyLimit = 64 * 1024
+
+// Backoff exponential retry delay, the nth retry waits Base*2^n seconds(up to Max seconds, 0 means no limit)
+type Backoff struct {
+	Base int
+	Max  int
+}
+
+func (b Backoff) delay(n int) time.Duration {
+	d := float64(b.Base) * math.Pow(2, float64(n))
+	if b.Max > 0 && d > float64(b.Max) {
+		d = float64(b.Max)
+	}
+	// avoid overflow of large retry count
+	if d > math.MaxInt32 {
+		d = math.MaxInt32
+	}
+	return time.Duration(d) * time.Second
+}
+
+// retryDelay asynq RetryDelayFunc, task name override > queue backoff > asynq default
+func (ops QueueOptions) retryDelay(n int, e error, t *asynq.Task) time.Duration {
+	for _, name := range taskNames(t.Type()) {
+		if b, ok := ops.backoffs[name]; ok {
+			return b.delay(n)
+		}
+	}
+	if ops.backoff != nil {
+		return ops.backoff.delay(n)
+	}
+	return asynq.DefaultRetryDelayFunc(n, e, t)
+}
+
+// getCallback callback url by task name, default is WithQueueCallback
+func (ops QueueOptions) getCallback(name string) string {
+	for _, item := range taskNames(name) {
+		if url, ok := ops.callbacks[item]; ok {
+			return url
+		}
+	}
+	return ops.callback
+}
+
+// task name with suffix and without suffix
+func taskNames(name string) []string {
+	names := []string{name}
+	for _, suffix := range []string{".once", ".cron"} {
+		if strings.HasSuffix(name, suffix) {
+			names = append(names, strings.TrimSuffix(name, suffix))
+			break
+		}
+	}
+	return names
+}
+
+func (p periodTaskHandler) httpCallback(ctx context.Context, t *asynq.Task, task Task, url string) (err error) {
+	client := &http.Client{
+		Timeout: time.Duration(p.qu.ops.callbackTimeout) * time.Second,
+	}
+	body := utils.Struct2Json(task)
+	var r *http.Request
+	r, err = http.NewRequest(http.MethodPost, url, bytes.NewReader([]byte(body)))
+	if err != nil {
+		err = errors.Wrap(ErrHttpCallback, err.Error())
+		return
+	}
+	r.Header.Add("Content-Type", gin.MIMEJSON)
+	if p.qu.ops.signAppId != "" {
+		timestamp := fmt.Sprintf("%d", time.Now().Unix())
+		r.Header.Add(
+			constant.MiddlewareSignTokenHeaderKey,
+			middleware.GetSignToken(p.qu.ops.signAppId, p.qu.ops.signSecret, r.Method, r.URL.RequestURI(), timestamp, body),
+		)
+	}
+	var res *http.Response
+	res, err = client.Do(r)
+	if e, ok := err.(net.Error); ok && e.Timeout() {
+		log.
+			WithContext(ctx).
+			WithFields(map[string]interface{}{
+				"Task": body,
+				"Url":  url,
+			}).
+			WithError(err).
+			Error(ErrHttpCallbackTimeout)
+		err = ErrHttpCallbackTimeout
+		return
+	}
+	if err != nil {
+		log.
+			WithContext(ctx).
+			WithFields(map[string]interface{}{
+				"Task": body,
+				"Url":  url,
+			}).
+			WithError(err).
+			Error(ErrHttpCallback)
+		err = ErrHttpCallback
+		return
+	}
+	defer res.Body.Close()
+	data, _ := ioutil.ReadAll(io.LimitReader(res.Body, callbackBodyLimit))
+	// save response body as task result(inspector shows it), flow step uses it as output
+	if w := t.ResultWriter(); w != nil && len(data) > 0 {
+		w.Write(data)
+	}
+	SetFlowOutput(ctx, string(data))
+	if !p.qu.ops.acceptStatus(res.StatusCode) {
+		log.
+			WithContext(ctx).
+			WithFields(map[string]interface{}{
+				"Task":       body,
+				"Url":        url,
+				"StatusCode": res.StatusCode,
+				"Body":       string(data),
+			}).
+			Error(ErrHttpCallbackInvalidStatusCode)
+		err = errors.Wrap(ErrHttpCallbackInvalidStatusCode, fmt.Sprintf("%d", res.StatusCode))
+	}
+	return
+}
+
+func (ops QueueOptions) acceptStatus(code int) bool {
+	for _, item := range ops.callbackStatus {
+		if item == code {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,152 @@
+package delay
+
+import (
+	"fmt"
+	"github.com/ennismar/go-helper/pkg/constant"
+	"github.com/pkg/errors"
+	"github.com/robfig/cron/v3"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronParser standard cron expr with optional seconds field and descriptors(@every 30s/@daily...),
+// CRON_TZ=Asia/Shanghai or TZ=Asia/Shanghai prefix is supported
+var cronParser = cron.NewParser(cron.SecondOptional | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+// misfireSep separates uid and the missed schedule timestamp of catch-up task id
+const misfireSep = ".misfire."
+
+// parse and normalize cron expr, the timezone is saved in expr as CRON_TZ= prefix
+func parseExpr(expr, timezone string) (rp string, err error) {
+	rp = strings.TrimSpace(expr)
+	if rp == "" {
+		err = errors.Wrap(ErrExprInvalid, "expr is empty")
+		return
+	}
+	timezone = strings.TrimSpace(timezone)
+	if timezone != "" {
+		if tz, _ := splitTimezone(rp); tz != "" && tz != timezone {
+			err = errors.Wrap(ErrExprInvalid, fmt.Sprintf("expr timezone %s conflicts with %s", tz, timezone))
+			return
+		}
+		if _, e := time.LoadLocation(timezone); e != nil {
+			err = errors.Wrap(ErrTimezoneInvalid, timezone)
+			return
+		}
+		_, rp = splitTimezone(rp)
+		rp = fmt.Sprintf("CRON_TZ=%s %s", timezone, rp)
+	}
+	var schedule cron.Schedule
+	schedule, err = cronParser.Parse(rp)
+	if err != nil {
+		err = errors.Wrap(ErrExprInvalid, fmt.Sprintf("%s(%v)", rp, err))
+		return
+	}
+	if schedule.Next(time.Now()).IsZero() {
+		err = errors.Wrap(ErrExprInvalid, fmt.Sprintf("%s never fires", rp))
+	}
+	return
+}
+
+// split CRON_TZ=/TZ= prefix
+func splitTimezone(expr string) (timezone, rest string) {
+	rest = strings.TrimSpace(expr)
+	for _, prefix := range []string{"CRON_TZ=", "TZ="} {
+		if strings.HasPrefix(rest, prefix) {
+			i := strings.Index(rest, " ")
+			if i == -1 {
+				timezone = strings.TrimPrefix(rest, prefix)
+				rest = ""
+				return
+			}
+			timezone = strings.TrimPrefix(rest[:i], prefix)
+			rest = strings.TrimSpace(rest[i:])
+			return
+		}
+	}
+	return
+}
+
+func getNext(expr string, timestamp int64) (next int64, err error) {
+	var schedule cron.Schedule
+	schedule, err = cronParser.Parse(expr)
+	if err != nil {
+		return
+	}
+	t := time.Now()
+	if timestamp > 0 {
+		t = time.Unix(timestamp, 0)
+	}
+	n := schedule.Next(t)
+	if n.IsZero() {
+		err = errors.WithStack(ErrExprInvalid)
+		return
+	}
+	next = n.Unix()
+	return
+}
+
+// random delay in [0, jitter) seconds, it is less than the interval so that the next schedule is not covered
+func getJitter(jitter int, interval int64) time.Duration {
+	if jitter <= 0 {
+		return 0
+	}
+	max := int64(jitter)
+	if interval > 0 && max >= interval {
+		max = interval - 1
+	}
+	if max <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(max)) * time.Second
+}
+
+// missed occurrences from timestamp(include) to now(exclude), at most limit items are returned
+func getMissed(expr string, timestamp, now int64, limit int) (rp []int64, err error) {
+	rp = make([]int64, 0)
+	var schedule cron.Schedule
+	schedule, err = cronParser.Parse(expr)
+	if err != nil {
+		return
+	}
+	for t := timestamp; t > 0 && t < now && len(rp) < limit; {
+		rp = append(rp, t)
+		n := schedule.Next(time.Unix(t, 0))
+		if n.IsZero() {
+			break
+		}
+		t = n.Unix()
+	}
+	return
+}
+
+func checkMisfire(policy uint) (err error) {
+	switch policy {
+	case constant.DelayMisfireOnce, constant.DelayMisfireSkip, constant.DelayMisfireAll:
+	default:
+		err = errors.Wrap(ErrMisfireInvalid, fmt.Sprintf("%d", policy))
+	}
+	return
+}
+
+// catch-up instance has its own task id, the handler gets the original uid
+func getMisfireUid(uid string, timestamp int64) string {
+	return fmt.Sprintf("%s%s%d", uid, misfireSep, timestamp)
+}
+
+func splitMisfireUid(id string) (uid string, timestamp int64) {
+	uid = id
+	i := strings.LastIndex(id, misfireSep)
+	if i == -1 {
+		return
+	}
+	ts, err := strconv.ParseInt(id[i+len(misfireSep):], 10, 64)
+	if err != nil {
+		return
+	}
+	uid = id[:i]
+	timestamp = ts
+	return
+}
@@ -16,4 +16,22 @@ var (
 	ErrHttpCallbackTimeout           = fmt.Errorf("http callback timeout")
 	ErrHttpCallback                  = fmt.Errorf("http callback err")
 	ErrHttpCallbackInvalidStatusCode = fmt.Errorf("http callback invalid status code")
+	ErrQueueNil                      = fmt.Errorf("delay queue is empty")
+	ErrTaskStateInvalid              = fmt.Errorf("task state is invalid")
+	ErrCronNotFound                  = fmt.Errorf("cron not found")
+	ErrRunStoreNil                   = fmt.Errorf("run store is empty")
+	ErrTimezoneInvalid               = fmt.Errorf("timezone is invalid")
+	ErrJitterInvalid                 = fmt.Errorf("jitter is invalid")
+	ErrMisfireInvalid                = fmt.Errorf("misfire policy is invalid")
+	ErrFlowInvalid                   = fmt.Errorf("workflow is invalid")
+	ErrFlowExists                    = fmt.Errorf("workflow run already exists")
+	ErrFlowNotFound                  = fmt.Errorf("workflow run not found")
+	ErrFlowFinished                  = fmt.Errorf("workflow run is finished")
+	ErrStorageKeyInvalid             = fmt.Errorf("storage object key is invalid")
+	ErrSignUrlInvalid                = fmt.Errorf("signed url is invalid or expired")
+	ErrExportFileInvalid             = fmt.Errorf("export file option is invalid")
+	ErrExportCancelled               = fmt.Errorf("export is cancelled")
+	ErrExportNotPending              = fmt.Errorf("export is not pending")
+	ErrExportStale                   = fmt.Errorf("export is stale, no progress for a long time")
+	ErrMisfireSkipped                = fmt.Errorf("task time has passed, skipped by misfire policy")
 )
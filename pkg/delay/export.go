@@ -1,10 +1,9 @@
 package delay
 
 import (
+	"context"
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/ennismar/go-helper/pkg/constant"
 	"github.com/ennismar/go-helper/pkg/log"
@@ -14,8 +13,8 @@ import (
 	"github.com/golang-module/carbon/v2"
 	"github.com/pkg/errors"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"gorm.io/gorm/schema"
-	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
@@ -73,6 +72,7 @@ func (ex Export) Start(uid, name, category, progress string) (err error) {
 	h.Category = category
 	h.Name = name
 	h.Progress = progress
+	h.UserId = ex.ops.userId
 	err = session.
 		Model(&ExportHistory{}).
 		Create(&h).Error
@@ -82,6 +82,7 @@ func (ex Export) Start(uid, name, category, progress string) (err error) {
 		return
 	}
 	session.Commit()
+	ex.push(ex.initSession().Where("uuid = ?", id))
 	return
 }
 
@@ -98,15 +99,28 @@ func (ex Export) Pending(uid, progress string) (err error) {
 	}
 	session := ex.initSession().Begin()
 	var h ExportHistory
+	// lock the row, Cancel/Fail can not change it before commit
 	session.
+		Clauses(clause.Locking{Strength: "UPDATE"}).
 		Model(&ExportHistory{}).
 		Where("uuid = ?", id).
 		First(&h)
 	if h.Id == constant.Zero {
+		session.Rollback()
 		log.WithContext(ex.ops.ctx).Error(errors.Wrap(ErrUuidInvalid, id))
 		err = errors.WithStack(ErrUuidInvalid)
 		return
 	}
+	if h.End == constant.DelayExportEndCancelled {
+		session.Rollback()
+		err = errors.WithStack(ErrExportCancelled)
+		return
+	}
+	if h.End != constant.DelayExportEndPending {
+		session.Rollback()
+		err = errors.Wrap(ErrExportNotPending, id)
+		return
+	}
 	err = session.
 		Model(&ExportHistory{}).
 		Where("uuid = ?", uid).
@@ -117,6 +131,7 @@ func (ex Export) Pending(uid, progress string) (err error) {
 		return
 	}
 	session.Commit()
+	ex.push(ex.initSession().Where("uuid = ?", id))
 	return
 }
 
@@ -133,15 +148,28 @@ func (ex Export) End(uid string, args ...string) (err error) {
 	}
 	session := ex.initSession().Begin()
 	var h ExportHistory
+	// lock the row, Cancel/Fail can not change it before commit
 	session.
+		Clauses(clause.Locking{Strength: "UPDATE"}).
 		Model(&ExportHistory{}).
 		Where("uuid = ?", id).
 		First(&h)
 	if h.Id == constant.Zero {
+		session.Rollback()
 		log.WithContext(ex.ops.ctx).Error(errors.Wrap(ErrUuidInvalid, id))
 		err = errors.WithStack(ErrUuidInvalid)
 		return
 	}
+	if h.End == constant.DelayExportEndCancelled {
+		session.Rollback()
+		err = errors.WithStack(ErrExportCancelled)
+		return
+	}
+	if h.End != constant.DelayExportEndPending {
+		session.Rollback()
+		err = errors.Wrap(ErrExportNotPending, id)
+		return
+	}
 
 	m := make(map[string]interface{})
 	var progress, filename string
@@ -160,12 +188,11 @@ func (ex Export) End(uid string, args ...string) (err error) {
 		//	err = errors.WithStack(err)
 		//	return
 		//}
-		s3Api := ex.getS3Client()
-
 		objName := fmt.Sprintf("%s/%s/%s/%s", ex.ops.objPrefix, carbon.Now().ToDateString(), ex.ops.machineId, filepath.Base(filename))
 		//err = bucket.PutObjectFromFile(objName, filename)
-		err = ex.PutObject(s3Api, objName, filename)
+		err = ex.getStorage().Put(ex.ops.ctx, objName, filename)
 		if err != nil {
+			session.Rollback()
 			log.WithContext(ex.ops.ctx).Error(errors.Wrap(ErrOssPutObjectFailed, err.Error()))
 			err = errors.WithStack(ErrOssPutObjectFailed)
 			return
@@ -173,7 +200,7 @@ func (ex Export) End(uid string, args ...string) (err error) {
 		m["url"] = objName
 	}
 	m["progress"] = progress
-	m["end"] = constant.One
+	m["end"] = constant.DelayExportEndSuccess
 	err = session.
 		Model(&ExportHistory{}).
 		Where("uuid = ?", uid).
@@ -184,6 +211,182 @@ func (ex Export) End(uid string, args ...string) (err error) {
 		return
 	}
 	session.Commit()
+	ex.push(ex.initSession().Where("uuid = ?", id))
+	return
+}
+
+// Fail mark pending export as failed with error message
+func (ex Export) Fail(uid, msg string) (err error) {
+	if ex.Error != nil {
+		err = ex.Error
+		return
+	}
+	id := strings.TrimSpace(uid)
+	if id == "" {
+		err = errors.WithStack(ErrUuidNil)
+		return
+	}
+	err = ex.updatePending(
+		ex.initSession().Where("uuid = ?", id),
+		map[string]interface{}{
+			"end":   constant.DelayExportEndFailed,
+			"error": msg,
+		},
+	)
+	if err == nil {
+		ex.push(ex.initSession().Where("uuid = ?", id))
+	}
+	return
+}
+
+// Cancel cancel pending exports by uuid, worker stops by CancelCtx or Pending/End error
+func (ex Export) Cancel(uids ...string) (err error) {
+	if ex.Error != nil {
+		err = ex.Error
+		return
+	}
+	err = ex.updatePending(
+		ex.initSession().Where("uuid IN (?)", uids),
+		map[string]interface{}{
+			"end": constant.DelayExportEndCancelled,
+		},
+	)
+	if err == nil {
+		ex.push(ex.initSession().Where("uuid IN (?)", uids))
+	}
+	return
+}
+
+// CancelByIds cancel pending exports by id
+func (ex Export) CancelByIds(ids []uint) (err error) {
+	if ex.Error != nil {
+		err = ex.Error
+		return
+	}
+	err = ex.updatePending(
+		ex.initSession().Where("id IN (?)", ids),
+		map[string]interface{}{
+			"end": constant.DelayExportEndCancelled,
+		},
+	)
+	if err == nil {
+		ex.push(ex.initSession().Where("id IN (?)", ids))
+	}
+	return
+}
+
+// CancelCtx ctx is done when the export is cancelled(or not pending), worker polls it between batches
+func (ex Export) CancelCtx(ctx context.Context, uid string) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	if ex.Error != nil {
+		return ctx, cancel
+	}
+	go func() {
+		ticker := time.NewTicker(time.Duration(ex.ops.poll) * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				var h ExportHistory
+				ex.initSession().
+					Model(&ExportHistory{}).
+					Where("uuid = ?", uid).
+					First(&h)
+				if h.Id != constant.Zero && h.End != constant.DelayExportEndPending {
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+	return ctx, cancel
+}
+
+// Clear mark stale pending exports as failed, remove expired history and objects
+func (ex Export) Clear() (err error) {
+	if ex.Error != nil {
+		err = ex.Error
+		return
+	}
+	now := carbon.Now()
+	err = ex.updatePending(
+		ex.initSession().Where("updated_at < ?", now.SubSeconds(ex.ops.stale).ToDateTimeString()),
+		map[string]interface{}{
+			"end":   constant.DelayExportEndFailed,
+			"error": ErrExportStale.Error(),
+		},
+	)
+	// no stale export is fine
+	if err != nil && !errors.Is(err, ErrExportNotPending) {
+		return
+	}
+	err = nil
+	ids := make([]uint, 0)
+	ex.initSession().
+		Model(&ExportHistory{}).
+		Where("created_at < ?", now.SubDays(ex.ops.retention).ToDateTimeString()).
+		Where("end <> ?", constant.DelayExportEndPending).
+		Pluck("id", &ids)
+	if len(ids) > 0 {
+		err = ex.DeleteHistoryByIds(ids)
+	}
+	return
+}
+
+// Janitor run Clear every interval until ctx is done
+func (ex Export) Janitor(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := ex.Clear(); err != nil {
+				log.WithContext(ex.ops.ctx).WithError(err).Warn("clear export history failed")
+			}
+		}
+	}
+}
+
+// push progress of exports to owners by WithExportPush
+func (ex Export) push(q *gorm.DB) {
+	if ex.ops.push == nil {
+		return
+	}
+	list := make([]ExportHistory, 0)
+	q.
+		Model(&ExportHistory{}).
+		Where("user_id > ?", constant.Zero).
+		Find(&list)
+	for _, item := range list {
+		rp := resp.DelayExportProgress{
+			Uuid:     item.Uuid,
+			Category: item.Category,
+			Name:     item.Name,
+			Progress: item.Progress,
+			End:      item.End,
+			Error:    item.Error,
+		}
+		if item.End == constant.DelayExportEndSuccess && item.Url != "" {
+			rp.Url, _ = ex.getStorage().SignUrl(ex.ops.ctx, item.Url, time.Duration(ex.ops.expire)*time.Minute)
+		}
+		ex.ops.push([]uint{item.UserId}, rp)
+	}
+}
+
+// only pending export can be changed, ErrExportNotPending if nothing is changed
+func (ex Export) updatePending(q *gorm.DB, m map[string]interface{}) (err error) {
+	q = q.
+		Model(&ExportHistory{}).
+		Where("end = ?", constant.DelayExportEndPending).
+		Updates(m)
+	err = q.Error
+	if err == nil && q.RowsAffected == 0 {
+		err = errors.WithStack(ErrExportNotPending)
+	}
 	return
 }
 
@@ -235,16 +438,11 @@ func (ex Export) FindHistory(r *req.DelayExportHistory) (rp []resp.DelayExportHi
 	//	err = errors.WithStack(err)
 	//	return
 	//}
-	s3cli := ex.getS3Client()
+	storage := ex.getStorage()
 	for i, item := range list {
-		if item.End == constant.One && item.Url != "" {
+		if item.End == constant.DelayExportEndSuccess && item.Url != "" {
 			// get signature url
-			var url string
-			s3rsp, _ := s3cli.GetObjectRequest(&s3.GetObjectInput{
-				Bucket: aws.String(ex.ops.bucket),
-				Key:    aws.String(item.Url),
-			})
-			url, err := s3rsp.Presign(time.Duration(ex.ops.expire) * time.Minute)
+			url, err := storage.SignUrl(ex.ops.ctx, item.Url, time.Duration(ex.ops.expire)*time.Minute)
 			//url, err = bucket.SignURL(item.Url, http.MethodGet, ex.ops.expire*60)
 			if err != nil {
 				continue
@@ -290,24 +488,14 @@ func (ex Export) DeleteHistoryByIds(ids []uint) (err error) {
 	//		return
 	//	}
 	//}
-	objs := make([]*s3.ObjectIdentifier, 0)
+	objs := make([]string, 0)
 	for _, v := range list {
-		if v.End == constant.One {
-			objs = append(objs, &s3.ObjectIdentifier{
-				Key: aws.String(v.Url),
-			})
+		if v.End == constant.DelayExportEndSuccess && v.Url != "" {
+			objs = append(objs, v.Url)
 		}
 	}
 	if len(objs) > 0 {
-		s3cli := ex.getS3Client()
-		deleteObjectsInput := &s3.DeleteObjectsInput{
-			Bucket: aws.String(ex.ops.bucket),
-			Delete: &s3.Delete{
-				Objects: objs,
-				Quiet:   aws.Bool(false),
-			},
-		}
-		_, err = s3cli.DeleteObjects(deleteObjectsInput)
+		err = ex.getStorage().Delete(ex.ops.ctx, objs...)
 		if err != nil {
 			session.Rollback()
 			err = errors.WithStack(err)
@@ -325,25 +513,14 @@ func (ex Export) DeleteHistoryByIds(ids []uint) (err error) {
 	return
 }
 
-func (ex Export) getS3Client() *s3.S3 {
-	region := "cn"
-	config := &aws.Config{
-		Credentials:      credentials.NewStaticCredentials(ex.ops.key, ex.ops.secret, ""),
-		Endpoint:         aws.String(ex.ops.endpoint),
-		S3ForcePathStyle: aws.Bool(true),
-		DisableSSL:       aws.Bool(true),
-		LogLevel:         aws.LogLevel(aws.LogDebug),
-		Region:           aws.String(region),
-		HTTPClient: &http.Client{
-			Transport: &http.Transport{
-				MaxConnsPerHost: 1000,
-			},
-		},
+// getStorage storage of WithExportStorage, default is s3 by endpoint/key/secret/bucket
+func (ex Export) getStorage() Storage {
+	if ex.ops.storage != nil {
+		return ex.ops.storage
 	}
-	sess := session.Must(session.NewSession(config))
-
-	return s3.New(sess)
+	return NewS3Storage(ex.ops.endpoint, ex.ops.key, ex.ops.secret, ex.ops.bucket)
 }
+
 func (ex Export) PutObject(s3cli *s3.S3, key string, fn string) error {
 
 	file, err := os.Open(fn)
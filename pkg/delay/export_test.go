@@ -1,23 +1,40 @@
 package delay
 
 import (
+	"context"
 	"fmt"
+	"github.com/ennismar/go-helper/pkg/constant"
 	"github.com/ennismar/go-helper/pkg/req"
+	"github.com/ennismar/go-helper/pkg/resp"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 	"gorm.io/gorm/schema"
+	"os"
 	"testing"
 	"time"
 )
 
-func TestNewExport(t *testing.T) {
-	db, _ := gorm.Open(mysql.Open("root:rO0tSDfjkuisdfDFuio@tcp(39.106.224.169:3306)/gsgc_web_prod?charset=utf8mb4&collation=utf8mb4_general_ci&parseTime=True&loc=Local&timeout=10000ms"), &gorm.Config{
+// getExportDb test db from env TEST_MYSQL_DSN, do not point it to shared data
+func getExportDb(t *testing.T) *gorm.DB {
+	dsn := os.Getenv("TEST_MYSQL_DSN")
+	if dsn == "" {
+		t.Skip("TEST_MYSQL_DSN is not set")
+	}
+	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{
 		DisableForeignKeyConstraintWhenMigrating: true,
 		NamingStrategy: schema.NamingStrategy{
 			TablePrefix:   "tb_",
 			SingularTable: true,
 		},
 	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestNewExport(t *testing.T) {
+	db := getExportDb(t)
 	MigrateExport(
 		WithExportDbNoTx(db),
 	)
@@ -38,3 +55,99 @@ func TestNewExport(t *testing.T) {
 
 	fmt.Println(ex.FindHistory(&req.DelayExportHistory{}))
 }
+
+func TestNewExport_LocalStorage(t *testing.T) {
+	db := getExportDb(t)
+	ex := NewExport(
+		WithExportDbNoTx(db),
+		WithExportStorage(NewLocalStorage("/tmp/export", "http://127.0.0.1:8080/export", "secret")),
+		WithExportExpire(10),
+	)
+	ex.Start("uuid4", "export 2", "category 1", "start")
+	ex.End("uuid4", "100%", "/tmp/test.txt")
+
+	r := req.DelayExportHistory{}
+	list, err := ex.FindHistory(&r)
+	fmt.Println(list, err)
+	// only delete the history created by this test
+	ids := make([]uint, 0)
+	ex.initSession().
+		Model(&ExportHistory{}).
+		Where("uuid = ?", "uuid4").
+		Pluck("id", &ids)
+	fmt.Println(ex.DeleteHistoryByIds(ids))
+}
+
+func TestExport_ExportFile(t *testing.T) {
+	db := getExportDb(t)
+	ex := NewExport(
+		WithExportDbNoTx(db),
+		WithExportStorage(NewLocalStorage("/tmp/export", "http://127.0.0.1:8080/export", "secret")),
+	)
+	columns := []ExportColumn{
+		{Header: "ID", Field: "id"},
+		{Header: "Name", Field: "name"},
+		{Header: "Progress", Field: "progress"},
+		{Header: "CreatedAt", Field: "createdAt"},
+	}
+	list := make([]ExportHistory, 0)
+	fmt.Println(ex.ExportFile(
+		"uuid5", "export csv", "category 1", columns,
+		WithExportFileQuery(db.Table("tb_delay_export_history"), &list),
+		WithExportFileBatch(2),
+	))
+	fmt.Println(ex.ExportFile(
+		"uuid6", "export xlsx", "category 1", columns,
+		WithExportFileFindWithPage(func(page *resp.Page, model interface{}) {
+			q := db.Table("tb_delay_export_history")
+			q.Count(&page.Total)
+			limit, offset := page.GetLimit()
+			q.Limit(limit).Offset(offset).Find(model)
+		}, &list),
+		WithExportFileFormat(constant.DelayExportFormatXlsx),
+		WithExportFileBatch(2),
+		WithExportFileZipSize(1),
+	))
+}
+
+func TestExport_Cancel(t *testing.T) {
+	db := getExportDb(t)
+	MigrateExport(
+		WithExportDbNoTx(db),
+	)
+	ex := NewExport(
+		WithExportDbNoTx(db),
+		WithExportCancelPoll(1),
+	)
+	ex.Start("uuid7", "export 3", "category 1", "start")
+	ctx, cancel := ex.CancelCtx(context.Background(), "uuid7")
+	defer cancel()
+	fmt.Println(ex.Cancel("uuid7"))
+	<-ctx.Done()
+	// cancelled export can not be changed
+	fmt.Println(ex.Pending("uuid7", "50%"))
+	fmt.Println(ex.End("uuid7", "100%"))
+
+	ex.Start("uuid8", "export 4", "category 1", "start")
+	fmt.Println(ex.Fail("uuid8", "query failed"))
+	// failed export is not pending
+	fmt.Println(ex.Cancel("uuid8"))
+	fmt.Println(ex.End("uuid8", "100%"))
+}
+
+func TestExport_Push(t *testing.T) {
+	db := getExportDb(t)
+	MigrateExport(
+		WithExportDbNoTx(db),
+	)
+	ex := NewExport(
+		WithExportDbNoTx(db),
+		WithExportUserId(1),
+		WithExportPush(func(userIds []uint, rp resp.DelayExportProgress) {
+			fmt.Println(userIds, rp)
+		}),
+	)
+	ex.Start("uuid9", "export 5", "category 1", "start")
+	fmt.Println(ex.Pending("uuid9", "50%"))
+	fmt.Println(ex.End("uuid9", "100%"))
+}
@@ -0,0 +1,430 @@
+package delay
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"github.com/ennismar/go-helper/pkg/constant"
+	"github.com/ennismar/go-helper/pkg/log"
+	"github.com/ennismar/go-helper/pkg/resp"
+	"github.com/ennismar/go-helper/pkg/utils"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+)
+
+// xlsxMaxRows max rows of one sheet, the next sheet is created when it is full
+const xlsxMaxRows = 1048576
+
+// ExportColumn column mapping of exported file
+type ExportColumn struct {
+	Header string                     // title row
+	Field  string                     // json field of row, nested field is split by dot(user.name)
+	Format func(v interface{}) string // custom value format, default is plain text
+}
+
+// ExportFile stream rows of WithExportFileQuery/WithExportFileFindWithPage to csv/xlsx file,
+// progress is updated by Pending after each batch and the file is uploaded by End(zip it if the file is large),
+// it stops when the export is cancelled and the error message is saved when it fails
+func (ex Export) ExportFile(uid, name, category string, columns []ExportColumn, options ...func(*ExportFileOptions)) (err error) {
+	if ex.Error != nil {
+		err = ex.Error
+		return
+	}
+	ops := getExportFileOptionsOrSetDefault(nil)
+	for _, f := range options {
+		f(ops)
+	}
+	if len(columns) == 0 {
+		err = errors.Wrap(ErrExportFileInvalid, "columns are empty")
+		return
+	}
+	if ops.model == nil || (ops.query == nil && ops.findWithPage == nil) {
+		err = errors.Wrap(ErrExportFileInvalid, "source is empty")
+		return
+	}
+	rv := reflect.ValueOf(ops.model)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Slice {
+		err = errors.Wrap(ErrExportFileInvalid, "model must be a pointer of slice")
+		return
+	}
+	err = ex.Start(uid, name, category, "0%")
+	if err != nil {
+		return
+	}
+	filename := ops.filename
+	if filename == "" {
+		filename = uid
+	}
+	filename = filepath.Join(ops.dir, fmt.Sprintf("%s.%s", filename, ops.format))
+	files := []string{filename}
+	defer func() {
+		for _, item := range files {
+			os.Remove(item)
+		}
+	}()
+	// failed export keeps the error message, cancelled export is unchanged
+	defer func() {
+		if err != nil && !errors.Is(err, ErrExportCancelled) {
+			if e := ex.Fail(uid, err.Error()); e != nil {
+				log.WithContext(ex.ops.ctx).WithError(e).Warn("save export error failed")
+			}
+		}
+	}()
+	ctx, cancel := ex.CancelCtx(ex.ops.ctx, uid)
+	defer cancel()
+	err = ex.writeFile(ctx, uid, filename, columns, ops)
+	if err != nil {
+		log.WithContext(ex.ops.ctx).WithError(err).Error("write export file failed")
+		return
+	}
+	if info, e := os.Stat(filename); e == nil && ops.zipSize > 0 && info.Size() > ops.zipSize {
+		zipName := filename + ".zip"
+		err = utils.Zip(filename, zipName)
+		if err != nil {
+			log.WithContext(ex.ops.ctx).WithError(err).Error("zip export file failed")
+			return
+		}
+		files = append(files, zipName)
+		filename = zipName
+	}
+	err = ex.End(uid, "100%", filename)
+	return
+}
+
+func (ex Export) writeFile(ctx context.Context, uid, filename string, columns []ExportColumn, ops *ExportFileOptions) (err error) {
+	err = os.MkdirAll(filepath.Dir(filename), os.ModePerm)
+	if err != nil {
+		return
+	}
+	var w exportWriter
+	switch ops.format {
+	case constant.DelayExportFormatXlsx:
+		w, err = newXlsxWriter(filename)
+	default:
+		w, err = newCsvWriter(filename)
+	}
+	if err != nil {
+		return
+	}
+	defer func() {
+		if e := w.Close(); e != nil && err == nil {
+			err = e
+		}
+	}()
+	headers := make([]string, len(columns))
+	for i, column := range columns {
+		headers[i] = column.Header
+	}
+	err = w.Write(headers)
+	if err != nil {
+		return
+	}
+	var total, done int64
+	percent := int64(-1)
+	batch := func() (e error) {
+		if ctx.Err() != nil {
+			e = errors.WithStack(ErrExportCancelled)
+			return
+		}
+		var rows []map[string]interface{}
+		rows, e = toRows(ops.model)
+		if e != nil {
+			return
+		}
+		for _, row := range rows {
+			e = w.Write(formatRow(row, columns))
+			if e != nil {
+				return
+			}
+		}
+		done += int64(len(rows))
+		// End sets 100%
+		p := int64(99)
+		if total > 0 && done < total {
+			p = done * 100 / total
+		}
+		if p != percent {
+			percent = p
+			e = ex.Pending(uid, fmt.Sprintf("%d%%", p))
+		}
+		return
+	}
+	if ops.query != nil {
+		ops.query.Session(&gorm.Session{}).Count(&total)
+		err = ops.query.Session(&gorm.Session{}).FindInBatches(ops.model, ops.batch, func(tx *gorm.DB, _ int) error {
+			return batch()
+		}).Error
+		return
+	}
+	for i := 1; ; i++ {
+		page := resp.Page{
+			PageNum:   uint(i),
+			PageSize:  uint(ops.batch),
+			Total:     total,
+			SkipCount: i > 1,
+		}
+		reflect.ValueOf(ops.model).Elem().SetLen(0)
+		ops.findWithPage(&page, ops.model)
+		if i == 1 {
+			total = page.Total
+		}
+		count := reflect.ValueOf(ops.model).Elem().Len()
+		if count == 0 {
+			break
+		}
+		err = batch()
+		if err != nil {
+			return
+		}
+		if count < ops.batch || (total > 0 && done >= total) {
+			break
+		}
+	}
+	return
+}
+
+// toRows convert model list to rows by json field, json.Number keeps int64 precision
+func toRows(model interface{}) (rows []map[string]interface{}, err error) {
+	var b []byte
+	b, err = json.Marshal(model)
+	if err != nil {
+		return
+	}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	err = d.Decode(&rows)
+	return
+}
+
+func formatRow(row map[string]interface{}, columns []ExportColumn) []string {
+	values := make([]string, len(columns))
+	for i, column := range columns {
+		var v interface{} = row
+		for _, key := range strings.Split(column.Field, ".") {
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				v = nil
+				break
+			}
+			v = m[key]
+		}
+		if column.Format != nil {
+			values[i] = column.Format(v)
+			continue
+		}
+		switch val := v.(type) {
+		case nil:
+		case string:
+			values[i] = val
+		case json.Number:
+			values[i] = val.String()
+		case bool:
+			values[i] = fmt.Sprintf("%t", val)
+		default:
+			values[i] = utils.Struct2Json(val)
+		}
+	}
+	return values
+}
+
+type exportWriter interface {
+	Write(row []string) error
+	Close() error
+}
+
+type csvWriter struct {
+	file *os.File
+	w    *csv.Writer
+}
+
+func newCsvWriter(filename string) (rp *csvWriter, err error) {
+	var f *os.File
+	f, err = os.Create(filename)
+	if err != nil {
+		return
+	}
+	// utf-8 bom, excel shows chinese correctly
+	f.WriteString("\xEF\xBB\xBF")
+	rp = &csvWriter{
+		file: f,
+		w:    csv.NewWriter(f),
+	}
+	return
+}
+
+func (cw *csvWriter) Write(row []string) error {
+	return cw.w.Write(row)
+}
+
+func (cw *csvWriter) Close() (err error) {
+	cw.w.Flush()
+	err = cw.w.Error()
+	if e := cw.file.Close(); err == nil {
+		err = e
+	}
+	return
+}
+
+// xlsxWriter write rows to sheet xml as inline strings, only the current row is in memory
+type xlsxWriter struct {
+	file   *os.File
+	zw     *zip.Writer
+	sheet  *bufio.Writer
+	sheets int
+	rows   int
+	header []string
+}
+
+func newXlsxWriter(filename string) (rp *xlsxWriter, err error) {
+	var f *os.File
+	f, err = os.Create(filename)
+	if err != nil {
+		return
+	}
+	rp = &xlsxWriter{
+		file: f,
+		zw:   zip.NewWriter(f),
+	}
+	return
+}
+
+func (xw *xlsxWriter) Write(row []string) (err error) {
+	if xw.header == nil {
+		xw.header = row
+	}
+	if xw.sheet == nil || xw.rows >= xlsxMaxRows {
+		err = xw.nextSheet()
+		if err != nil {
+			return
+		}
+		if xw.sheets > 1 {
+			// repeat header in new sheet
+			err = xw.writeRow(xw.header)
+			if err != nil {
+				return
+			}
+		}
+	}
+	return xw.writeRow(row)
+}
+
+func (xw *xlsxWriter) writeRow(row []string) (err error) {
+	xw.rows++
+	fmt.Fprintf(xw.sheet, `<row r="%d">`, xw.rows)
+	for _, v := range row {
+		xw.sheet.WriteString(`<c t="inlineStr"><is><t xml:space="preserve">`)
+		err = xml.EscapeText(xw.sheet, []byte(v))
+		if err != nil {
+			return
+		}
+		xw.sheet.WriteString(`</t></is></c>`)
+	}
+	_, err = xw.sheet.WriteString(`</row>`)
+	return
+}
+
+func (xw *xlsxWriter) nextSheet() (err error) {
+	err = xw.endSheet()
+	if err != nil {
+		return
+	}
+	xw.sheets++
+	xw.rows = 0
+	var w io.Writer
+	w, err = xw.zw.Create(fmt.Sprintf("xl/worksheets/sheet%d.xml", xw.sheets))
+	if err != nil {
+		return
+	}
+	xw.sheet = bufio.NewWriter(w)
+	_, err = xw.sheet.WriteString(xml.Header + `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	return
+}
+
+func (xw *xlsxWriter) endSheet() (err error) {
+	if xw.sheet == nil {
+		return
+	}
+	xw.sheet.WriteString(`</sheetData></worksheet>`)
+	err = xw.sheet.Flush()
+	xw.sheet = nil
+	return
+}
+
+func (xw *xlsxWriter) Close() (err error) {
+	if xw.sheets == 0 {
+		err = xw.nextSheet()
+	}
+	if err == nil {
+		err = xw.endSheet()
+	}
+	if err == nil {
+		err = xw.writeWorkbook()
+	}
+	if e := xw.zw.Close(); err == nil {
+		err = e
+	}
+	if e := xw.file.Close(); err == nil {
+		err = e
+	}
+	return
+}
+
+func (xw *xlsxWriter) writeWorkbook() (err error) {
+	var types, sheets, rels strings.Builder
+	for i := 1; i <= xw.sheets; i++ {
+		fmt.Fprintf(&types, `<Override PartName="/xl/worksheets/sheet%d.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`, i)
+		fmt.Fprintf(&sheets, `<sheet name="Sheet%d" sheetId="%d" r:id="rId%d"/>`, i, i, i)
+		fmt.Fprintf(&rels, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet%d.xml"/>`, i, i)
+	}
+	parts := [][2]string{
+		{
+			"[Content_Types].xml",
+			`<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+				`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+				`<Default Extension="xml" ContentType="application/xml"/>` +
+				`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+				types.String() +
+				`</Types>`,
+		},
+		{
+			"_rels/.rels",
+			`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+				`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+				`</Relationships>`,
+		},
+		{
+			"xl/workbook.xml",
+			`<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+				`<sheets>` + sheets.String() + `</sheets>` +
+				`</workbook>`,
+		},
+		{
+			"xl/_rels/workbook.xml.rels",
+			`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+				rels.String() +
+				`</Relationships>`,
+		},
+	}
+	for _, part := range parts {
+		var w io.Writer
+		w, err = xw.zw.Create(part[0])
+		if err != nil {
+			return
+		}
+		_, err = w.Write([]byte(xml.Header + part[1]))
+		if err != nil {
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,350 @@
+package delay
+
+import (
+	"context"
+	"github.com/ennismar/go-helper/pkg/constant"
+	"github.com/ennismar/go-helper/pkg/req"
+	"github.com/ennismar/go-helper/pkg/resp"
+	"github.com/ennismar/go-helper/pkg/utils"
+	"github.com/go-redis/redis/v8"
+	"github.com/golang-module/carbon/v2"
+	"github.com/hibiken/asynq"
+	"github.com/pkg/errors"
+	"sort"
+	"strings"
+	"time"
+)
+
+// inspectPageSize page size of scanning asynq tasks when filter by name
+const inspectPageSize = 100
+
+// FindTask find asynq tasks by state, filter by name(contains) or uid
+func (qu Queue) FindTask(r *req.DelayTask) (rp []resp.DelayTask, err error) {
+	rp = make([]resp.DelayTask, 0)
+	if qu.Error != nil {
+		err = qu.Error
+		return
+	}
+	state := strings.ToLower(strings.TrimSpace(r.State))
+	if state == "" {
+		state = constant.DelayTaskStateScheduled
+	}
+	list, ok := qu.listTaskFunc(state)
+	if !ok {
+		err = errors.WithStack(ErrTaskStateInvalid)
+		return
+	}
+	page := &r.Page
+	uid := strings.TrimSpace(r.Uid)
+	name := strings.TrimSpace(r.Name)
+	infos := make([]*asynq.TaskInfo, 0)
+	switch {
+	case uid != "":
+		var info *asynq.TaskInfo
+		info, err = qu.inspector.GetTaskInfo(qu.ops.name, uid)
+		if errors.Is(err, asynq.ErrTaskNotFound) || errors.Is(err, asynq.ErrQueueNotFound) {
+			err = nil
+		}
+		if err != nil {
+			return
+		}
+		if info != nil && info.State.String() == state && strings.Contains(info.Type, name) {
+			infos = append(infos, info)
+		}
+		page.Total = int64(len(infos))
+		page.GetLimit()
+	case name != "" || page.NoPagination:
+		// asynq can not filter by type, scan all pages
+		for i := 1; ; i++ {
+			var items []*asynq.TaskInfo
+			items, err = list(qu.ops.name, asynq.Page(i), asynq.PageSize(inspectPageSize))
+			if errors.Is(err, asynq.ErrQueueNotFound) {
+				err = nil
+			}
+			if err != nil {
+				return
+			}
+			for _, item := range items {
+				if strings.Contains(item.Type, name) {
+					infos = append(infos, item)
+				}
+			}
+			if len(items) < inspectPageSize {
+				break
+			}
+		}
+		page.Total = int64(len(infos))
+		if !page.NoPagination {
+			limit, offset := page.GetLimit()
+			if offset >= len(infos) {
+				infos = infos[:0]
+			} else {
+				if offset+limit < len(infos) {
+					infos = infos[:offset+limit]
+				}
+				infos = infos[offset:]
+			}
+		} else {
+			page.GetLimit()
+		}
+	default:
+		var info *asynq.QueueInfo
+		info, err = qu.inspector.GetQueueInfo(qu.ops.name)
+		if errors.Is(err, asynq.ErrQueueNotFound) {
+			err = nil
+			return
+		}
+		if err != nil {
+			return
+		}
+		page.Total = int64(countTask(info, state))
+		if page.Total > 0 {
+			limit, offset := page.GetLimit()
+			infos, err = list(qu.ops.name, asynq.Page(offset/limit+1), asynq.PageSize(limit))
+			if err != nil {
+				return
+			}
+		}
+	}
+	for _, item := range infos {
+		rp = append(rp, newTaskResp(item))
+	}
+	return
+}
+
+// RunTask run scheduled/retry/archived tasks immediately,
+// the next cron instance is scheduled ahead, run it means the cron runs now(scanner schedules the next one)
+func (qu Queue) RunTask(uids ...string) (err error) {
+	if qu.Error != nil {
+		err = qu.Error
+		return
+	}
+	for _, uid := range uids {
+		err = qu.inspector.RunTask(qu.ops.name, uid)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// RequeueArchived requeue archived tasks, requeue all archived tasks if uids is empty
+func (qu Queue) RequeueArchived(uids ...string) (count int, err error) {
+	if qu.Error != nil {
+		err = qu.Error
+		return
+	}
+	if len(uids) == 0 {
+		count, err = qu.inspector.RunAllArchivedTasks(qu.ops.name)
+		if errors.Is(err, asynq.ErrQueueNotFound) {
+			err = nil
+		}
+		return
+	}
+	for _, uid := range uids {
+		var info *asynq.TaskInfo
+		info, err = qu.inspector.GetTaskInfo(qu.ops.name, uid)
+		if err != nil {
+			return
+		}
+		if info.State != asynq.TaskStateArchived {
+			err = errors.Wrap(ErrTaskStateInvalid, uid)
+			return
+		}
+		err = qu.inspector.RunTask(qu.ops.name, uid)
+		if err != nil {
+			return
+		}
+		count++
+	}
+	return
+}
+
+// FindCron find cron definitions saved in redisPeriodKey
+func (qu Queue) FindCron(r req.DelayCron) (rp []resp.DelayCron, err error) {
+	rp = make([]resp.DelayCron, 0)
+	if qu.Error != nil {
+		err = qu.Error
+		return
+	}
+	var m map[string]string
+	m, err = qu.redis.HGetAll(context.Background(), qu.ops.redisPeriodKey).Result()
+	if err != nil {
+		return
+	}
+	name := strings.TrimSpace(r.Name)
+	uid := strings.TrimSpace(r.Uid)
+	for _, v := range m {
+		var item periodTask
+		utils.Json2Struct(v, &item)
+		if !strings.Contains(item.Name, name) || (uid != "" && item.Uid != uid) {
+			continue
+		}
+		if r.Paused != nil && item.Paused != *r.Paused {
+			continue
+		}
+		rp = append(rp, resp.DelayCron{
+			Uid:          item.Uid,
+			Name:         item.Name,
+			Expr:         item.Expr,
+			Payload:      item.Payload,
+			Next:         carbon.DateTime{Carbon: carbon.CreateFromTimestamp(item.Next)},
+			Processed:    item.Processed,
+			MaxRetry:     item.MaxRetry,
+			Timeout:      item.Timeout,
+			Paused:       item.Paused,
+			Jitter:       item.Jitter,
+			Misfire:      item.Misfire,
+			MisfireLimit: item.MisLimit,
+		})
+	}
+	sort.Slice(rp, func(i, j int) bool { return rp[i].Uid < rp[j].Uid })
+	return
+}
+
+// PauseCron stop scheduling crons, the instance already scheduled is removed
+func (qu Queue) PauseCron(uids ...string) (err error) {
+	for _, uid := range uids {
+		err = qu.updateCron(uid, func(t *periodTask) error {
+			t.Paused = true
+			return nil
+		})
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// ResumeCron resume paused crons, missed schedules are skipped
+func (qu Queue) ResumeCron(uids ...string) (err error) {
+	for _, uid := range uids {
+		err = qu.updateCron(uid, func(t *periodTask) (e error) {
+			t.Paused = false
+			t.Next, e = getNext(t.Expr, 0)
+			return
+		})
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// UpdateCronExpr change cron expr, the next instance is rescheduled by new expr(keep the old timezone if expr has no CRON_TZ=)
+func (qu Queue) UpdateCronExpr(uid, expr string) (err error) {
+	err = qu.updateCron(uid, func(t *periodTask) (e error) {
+		timezone, _ := splitTimezone(expr)
+		if timezone == "" {
+			timezone, _ = splitTimezone(t.Expr)
+		}
+		var newExpr string
+		newExpr, e = parseExpr(expr, timezone)
+		if e != nil {
+			return
+		}
+		t.Expr = newExpr
+		t.Next, e = getNext(newExpr, 0)
+		return
+	})
+	return
+}
+
+// update cron definition with lock, the scheduled instance is removed so that scanner uses the new definition
+func (qu Queue) updateCron(uid string, fun func(t *periodTask) error) (err error) {
+	if qu.Error != nil {
+		err = qu.Error
+		return
+	}
+	var ok bool
+	for {
+		ok = qu.nxLock.Lock()
+		if ok {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	defer qu.nxLock.Unlock()
+	ctx := context.Background()
+	var v string
+	v, err = qu.redis.HGet(ctx, qu.ops.redisPeriodKey, uid).Result()
+	if err == redis.Nil {
+		err = errors.Wrap(ErrCronNotFound, uid)
+		return
+	}
+	if err != nil {
+		return
+	}
+	var item periodTask
+	utils.Json2Struct(v, &item)
+	err = fun(&item)
+	if err != nil {
+		return
+	}
+	// only the instance waiting to run can be removed
+	info, e := qu.inspector.GetTaskInfo(qu.ops.name, uid)
+	if e == nil && info.State == asynq.TaskStateScheduled {
+		qu.inspector.DeleteTask(qu.ops.name, uid)
+	}
+	_, err = qu.redis.HSet(ctx, qu.ops.redisPeriodKey, uid, utils.Struct2Json(item)).Result()
+	if err != nil {
+		err = errors.WithStack(ErrSaveCron)
+	}
+	return
+}
+
+func (qu Queue) listTaskFunc(state string) (fun func(queue string, opts ...asynq.ListOption) ([]*asynq.TaskInfo, error), ok bool) {
+	ok = true
+	switch state {
+	case constant.DelayTaskStateScheduled:
+		fun = qu.inspector.ListScheduledTasks
+	case constant.DelayTaskStatePending:
+		fun = qu.inspector.ListPendingTasks
+	case constant.DelayTaskStateActive:
+		fun = qu.inspector.ListActiveTasks
+	case constant.DelayTaskStateRetry:
+		fun = qu.inspector.ListRetryTasks
+	case constant.DelayTaskStateArchived:
+		fun = qu.inspector.ListArchivedTasks
+	default:
+		ok = false
+	}
+	return
+}
+
+func countTask(info *asynq.QueueInfo, state string) (count int) {
+	switch state {
+	case constant.DelayTaskStateScheduled:
+		count = info.Scheduled
+	case constant.DelayTaskStatePending:
+		count = info.Pending
+	case constant.DelayTaskStateActive:
+		count = info.Active
+	case constant.DelayTaskStateRetry:
+		count = info.Retry
+	case constant.DelayTaskStateArchived:
+		count = info.Archived
+	}
+	return
+}
+
+func newTaskResp(info *asynq.TaskInfo) (rp resp.DelayTask) {
+	rp = resp.DelayTask{
+		Uid:      info.ID,
+		Queue:    info.Queue,
+		Name:     info.Type,
+		Payload:  string(info.Payload),
+		State:    info.State.String(),
+		MaxRetry: info.MaxRetry,
+		Retried:  info.Retried,
+		LastErr:  info.LastErr,
+		Result:   string(info.Result),
+	}
+	if !info.LastFailedAt.IsZero() {
+		rp.LastFailedAt = carbon.DateTime{Carbon: carbon.Time2Carbon(info.LastFailedAt)}
+	}
+	if !info.NextProcessAt.IsZero() {
+		rp.NextProcessAt = carbon.DateTime{Carbon: carbon.Time2Carbon(info.NextProcessAt)}
+	}
+	return
+}
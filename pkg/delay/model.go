@@ -1,14 +1,33 @@
 package delay
 
-import "github.com/ennismar/go-helper/ms"
+import (
+	"github.com/ennismar/go-helper/ms"
+	"github.com/golang-module/carbon/v2"
+)
 
 // ExportHistory save export file history
 type ExportHistory struct {
 	ms.M
 	Uuid     string `gorm:"index:idx_uuid,unique;:comment:uuid" json:"uuid"`
+	UserId   uint   `gorm:"index:idx_user_id;comment:owner user id(progress is pushed to owner)" json:"userId"`
 	Category string `gorm:"comment:custom category" json:"category"`
 	Name     string `gorm:"comment:display name" json:"name"`
 	Progress string `gorm:"comment:process progress" json:"progress"`
-	End      uint   `gorm:"type:tinyint(1);default:0;comment:0: pending, 1: end)" json:"end"`
+	End      uint   `gorm:"type:tinyint(1);default:0;comment:0: pending, 1: end, 2: failed, 3: cancelled)" json:"end"`
 	Url      string `gorm:"comment:cloud file url" json:"url"`
+	Error    string `gorm:"type:text;comment:error message of failed export" json:"error"`
+}
+
+// TaskRun save once/cron task run history
+type TaskRun struct {
+	ms.M
+	Uid       string          `gorm:"index:idx_uid;size:100;comment:task uid" json:"uid"`
+	Name      string          `gorm:"index:idx_name;size:100;comment:task name(once task ends with .once, cron task ends with .cron)" json:"name"`
+	StartAt   carbon.DateTime `gorm:"index:idx_start_at;comment:start time" json:"startAt"`
+	EndAt     carbon.DateTime `gorm:"comment:end time" json:"endAt"`
+	Duration  int64           `gorm:"comment:duration(unit: millisecond)" json:"duration"`
+	Status    uint            `gorm:"type:tinyint(1);default:0;comment:0: success, 1: failed" json:"status"`
+	Error     string          `gorm:"type:text;comment:handler error" json:"error"`
+	Retry     int             `gorm:"default:0;comment:retry count(0: first run)" json:"retry"`
+	RequestId string          `gorm:"size:100;comment:request id of the run" json:"requestId"`
 }
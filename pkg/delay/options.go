@@ -4,8 +4,11 @@ import (
 	"context"
 	"fmt"
 	"github.com/ennismar/go-helper/pkg/constant"
+	"github.com/ennismar/go-helper/pkg/resp"
 	"github.com/ennismar/go-helper/pkg/utils"
 	"gorm.io/gorm"
+	"net/http"
+	"os"
 	"time"
 )
 
@@ -20,6 +23,12 @@ type ExportOptions struct {
 	endpoint  string
 	bucket    string
 	expire    int64
+	storage   Storage
+	poll      int // cancel poll interval seconds
+	stale     int // pending export without progress for stale seconds is failed
+	retention int // history is removed after retention days
+	userId    uint
+	push      func(userIds []uint, rp resp.DelayExportProgress)
 }
 
 func WithExportCtx(ctx context.Context) func(*ExportOptions) {
@@ -89,6 +98,58 @@ func WithExportExpire(min int64) func(*ExportOptions) {
 	}
 }
 
+// WithExportStorage storage of export files(s3: NewS3Storage, minio: NewMinioStorage, local: NewLocalStorage), key/secret/endpoint/bucket are ignored
+func WithExportStorage(storage Storage) func(*ExportOptions) {
+	return func(options *ExportOptions) {
+		if storage != nil {
+			getExportOptionsOrSetDefault(options).storage = storage
+		}
+	}
+}
+
+// WithExportUserId owner of the export which is saved by Start
+func WithExportUserId(id uint) func(*ExportOptions) {
+	return func(options *ExportOptions) {
+		getExportOptionsOrSetDefault(options).userId = id
+	}
+}
+
+// WithExportPush push progress to owner when it changes, query.MessageHub SendExportProgress can be used
+func WithExportPush(fun func(userIds []uint, rp resp.DelayExportProgress)) func(*ExportOptions) {
+	return func(options *ExportOptions) {
+		if fun != nil {
+			getExportOptionsOrSetDefault(options).push = fun
+		}
+	}
+}
+
+// WithExportCancelPoll CancelCtx checks cancelled state every second
+func WithExportCancelPoll(second int) func(*ExportOptions) {
+	return func(options *ExportOptions) {
+		if second > 0 {
+			getExportOptionsOrSetDefault(options).poll = second
+		}
+	}
+}
+
+// WithExportStale janitor marks pending export as failed when progress is not updated for second
+func WithExportStale(second int) func(*ExportOptions) {
+	return func(options *ExportOptions) {
+		if second > 0 {
+			getExportOptionsOrSetDefault(options).stale = second
+		}
+	}
+}
+
+// WithExportRetention janitor removes history and objects older than day
+func WithExportRetention(day int) func(*ExportOptions) {
+	return func(options *ExportOptions) {
+		if day > 0 {
+			getExportOptionsOrSetDefault(options).retention = day
+		}
+	}
+}
+
 func getExportOptionsOrSetDefault(options *ExportOptions) *ExportOptions {
 	if options == nil {
 		return &ExportOptions{
@@ -101,6 +162,91 @@ func getExportOptionsOrSetDefault(options *ExportOptions) *ExportOptions {
 			secret:    "test",
 			bucket:    "test",
 			expire:    constant.DelayExportObjExpire,
+			poll:      3,
+			stale:     3600,
+			retention: 7,
+		}
+	}
+	return options
+}
+
+type ExportFileOptions struct {
+	query        *gorm.DB
+	findWithPage func(page *resp.Page, model interface{})
+	model        interface{}
+	format       string
+	batch        int
+	dir          string
+	filename     string
+	zipSize      int64
+}
+
+// WithExportFileQuery rows source, model is a pointer of slice(&[]User{}) which receives each batch
+func WithExportFileQuery(q *gorm.DB, model interface{}) func(*ExportFileOptions) {
+	return func(options *ExportFileOptions) {
+		getExportFileOptionsOrSetDefault(options).query = q
+		getExportFileOptionsOrSetDefault(options).model = model
+	}
+}
+
+// WithExportFileFindWithPage rows source by page like query.MySql FindWithPage, model is a pointer of slice
+func WithExportFileFindWithPage(fun func(page *resp.Page, model interface{}), model interface{}) func(*ExportFileOptions) {
+	return func(options *ExportFileOptions) {
+		getExportFileOptionsOrSetDefault(options).findWithPage = fun
+		getExportFileOptionsOrSetDefault(options).model = model
+	}
+}
+
+// WithExportFileFormat csv/xlsx, default csv
+func WithExportFileFormat(format string) func(*ExportFileOptions) {
+	return func(options *ExportFileOptions) {
+		if format == constant.DelayExportFormatCsv || format == constant.DelayExportFormatXlsx {
+			getExportFileOptionsOrSetDefault(options).format = format
+		}
+	}
+}
+
+// WithExportFileBatch rows of each batch, only one batch is in memory
+func WithExportFileBatch(count int) func(*ExportFileOptions) {
+	return func(options *ExportFileOptions) {
+		if count > 0 && count <= constant.MaxPageSize {
+			getExportFileOptionsOrSetDefault(options).batch = count
+		}
+	}
+}
+
+// WithExportFileDir temp dir of export file, file is removed after upload
+func WithExportFileDir(dir string) func(*ExportFileOptions) {
+	return func(options *ExportFileOptions) {
+		if dir != "" {
+			getExportFileOptionsOrSetDefault(options).dir = dir
+		}
+	}
+}
+
+// WithExportFileName file name without extension, default is uid
+func WithExportFileName(name string) func(*ExportFileOptions) {
+	return func(options *ExportFileOptions) {
+		getExportFileOptionsOrSetDefault(options).filename = name
+	}
+}
+
+// WithExportFileZipSize file is zipped when it is larger than size bytes, 0 means never zip
+func WithExportFileZipSize(size int64) func(*ExportFileOptions) {
+	return func(options *ExportFileOptions) {
+		if size >= 0 {
+			getExportFileOptionsOrSetDefault(options).zipSize = size
+		}
+	}
+}
+
+func getExportFileOptionsOrSetDefault(options *ExportFileOptions) *ExportFileOptions {
+	if options == nil {
+		return &ExportFileOptions{
+			format:  constant.DelayExportFormatCsv,
+			batch:   1000,
+			dir:     os.TempDir(),
+			zipSize: 10 << 20,
 		}
 	}
 	return options
@@ -117,12 +263,21 @@ type QueueOptions struct {
 	name            string
 	redisUri        string
 	redisPeriodKey  string
+	redisFlowKey    string
 	retention       int
 	maxRetry        int
 	handler         func(ctx context.Context, t Task) error
 	callback        string
+	callbacks       map[string]string // callback url by task name
 	callbackTimeout int
+	callbackStatus  []int // accepted status codes
+	signAppId       string
+	signSecret      string
+	backoff         *Backoff
+	backoffs        map[string]Backoff // backoff by task name
 	clearArchived   int
+	runStore        RunStore
+	runRetention    int
 }
 
 func WithQueueName(s string) func(*QueueOptions) {
@@ -143,6 +298,13 @@ func WithQueueRedisPeriodKey(s string) func(*QueueOptions) {
 	}
 }
 
+// WithQueueRedisFlowKey redis hash key of workflow runs
+func WithQueueRedisFlowKey(s string) func(*QueueOptions) {
+	return func(options *QueueOptions) {
+		getQueueOptionsOrSetDefault(options).redisFlowKey = s
+	}
+}
+
 func WithQueueRetention(second int) func(*QueueOptions) {
 	return func(options *QueueOptions) {
 		if second > 0 {
@@ -171,6 +333,63 @@ func WithQueueCallback(s string) func(*QueueOptions) {
 	}
 }
 
+// WithQueueCallbackByName callback url of the task name(with or without .once/.cron suffix), default url is WithQueueCallback
+func WithQueueCallbackByName(name, url string) func(*QueueOptions) {
+	return func(options *QueueOptions) {
+		ops := getQueueOptionsOrSetDefault(options)
+		if ops.callbacks == nil {
+			ops.callbacks = make(map[string]string)
+		}
+		ops.callbacks[name] = url
+	}
+}
+
+// WithQueueCallbackStatus accepted status codes of callback response, default 200
+func WithQueueCallbackStatus(codes ...int) func(*QueueOptions) {
+	return func(options *QueueOptions) {
+		if len(codes) > 0 {
+			getQueueOptionsOrSetDefault(options).callbackStatus = codes
+		}
+	}
+}
+
+// WithQueueCallbackSign sign callback request by app id/secret, it can be verified by middleware.Sign
+func WithQueueCallbackSign(appId, secret string) func(*QueueOptions) {
+	return func(options *QueueOptions) {
+		getQueueOptionsOrSetDefault(options).signAppId = appId
+		getQueueOptionsOrSetDefault(options).signSecret = secret
+	}
+}
+
+// WithQueueBackoff exponential retry delay of failed tasks(base*2^n seconds, up to max seconds), default is asynq.DefaultRetryDelayFunc
+func WithQueueBackoff(base, max int) func(*QueueOptions) {
+	return func(options *QueueOptions) {
+		if base > 0 {
+			getQueueOptionsOrSetDefault(options).backoff = &Backoff{
+				Base: base,
+				Max:  max,
+			}
+		}
+	}
+}
+
+// WithQueueBackoffByName override retry delay of the task name(with or without .once/.cron suffix)
+func WithQueueBackoffByName(name string, base, max int) func(*QueueOptions) {
+	return func(options *QueueOptions) {
+		if base <= 0 {
+			return
+		}
+		ops := getQueueOptionsOrSetDefault(options)
+		if ops.backoffs == nil {
+			ops.backoffs = make(map[string]Backoff)
+		}
+		ops.backoffs[name] = Backoff{
+			Base: base,
+			Max:  max,
+		}
+	}
+}
+
 func WithQueueCallbackTimeout(second int) func(*QueueOptions) {
 	return func(options *QueueOptions) {
 		if second > 0 {
@@ -187,16 +406,37 @@ func WithQueueClearArchived(second int) func(*QueueOptions) {
 	}
 }
 
+// WithQueueRunStore save once/cron run history to store(redis: NewRedisRunStore, mysql: NewGormRunStore)
+func WithQueueRunStore(store RunStore) func(*QueueOptions) {
+	return func(options *QueueOptions) {
+		if store != nil {
+			getQueueOptionsOrSetDefault(options).runStore = store
+		}
+	}
+}
+
+// WithQueueRunRetention keep run history for x seconds
+func WithQueueRunRetention(second int) func(*QueueOptions) {
+	return func(options *QueueOptions) {
+		if second > 0 {
+			getQueueOptionsOrSetDefault(options).runRetention = second
+		}
+	}
+}
+
 func getQueueOptionsOrSetDefault(options *QueueOptions) *QueueOptions {
 	if options == nil {
 		return &QueueOptions{
 			name:            "delay",
 			redisUri:        "redis://127.0.0.1:6379/0",
 			redisPeriodKey:  "delay.queue.period",
+			redisFlowKey:    "delay.queue.flow",
 			retention:       60,
 			maxRetry:        3,
 			callbackTimeout: 0,
+			callbackStatus:  []int{http.StatusOK},
 			clearArchived:   300,
+			runRetention:    7 * 24 * 3600,
 		}
 	}
 	return options
@@ -207,6 +447,10 @@ type QueueTaskOptions struct {
 	name      string
 	payload   string
 	expr      string         // only period task
+	timezone  string         // only period task
+	jitter    int            // only period task
+	misfire   uint           // skip/once/all when schedule time has passed
+	misLimit  int            // only period task
 	in        *time.Duration // only once task
 	at        *time.Time     // only once task
 	now       bool           // only once task
@@ -239,6 +483,36 @@ func WithQueueTaskExpr(s string) func(*QueueTaskOptions) {
 	}
 }
 
+// WithQueueTaskTimezone cron timezone like Asia/Shanghai, same as CRON_TZ= prefix of expr
+func WithQueueTaskTimezone(s string) func(*QueueTaskOptions) {
+	return func(options *QueueTaskOptions) {
+		getQueueTaskOptionsOrSetDefault(options).timezone = s
+	}
+}
+
+// WithQueueTaskJitter cron runs after a random delay in [0, second), tasks with the same expr do not fire at the same instant
+func WithQueueTaskJitter(second int) func(*QueueTaskOptions) {
+	return func(options *QueueTaskOptions) {
+		getQueueTaskOptionsOrSetDefault(options).jitter = second
+	}
+}
+
+// WithQueueTaskMisfire policy of missed schedules(service is down or task time has passed), default constant.DelayMisfireOnce
+func WithQueueTaskMisfire(policy uint) func(*QueueTaskOptions) {
+	return func(options *QueueTaskOptions) {
+		getQueueTaskOptionsOrSetDefault(options).misfire = policy
+	}
+}
+
+// WithQueueTaskMisfireLimit max catch-up runs of constant.DelayMisfireAll
+func WithQueueTaskMisfireLimit(count int) func(*QueueTaskOptions) {
+	return func(options *QueueTaskOptions) {
+		if count > 0 {
+			getQueueTaskOptionsOrSetDefault(options).misLimit = count
+		}
+	}
+}
+
 func WithQueueTaskIn(in time.Duration) func(*QueueTaskOptions) {
 	return func(options *QueueTaskOptions) {
 		getQueueTaskOptionsOrSetDefault(options).in = &in
@@ -1,20 +1,18 @@
 package delay
 
 import (
-	"bytes"
 	"context"
+	"fmt"
+	"github.com/ennismar/go-helper/pkg/constant"
 	"github.com/ennismar/go-helper/pkg/lock"
 	"github.com/ennismar/go-helper/pkg/log"
 	"github.com/ennismar/go-helper/pkg/tracing"
 	"github.com/ennismar/go-helper/pkg/utils"
-	"github.com/gin-gonic/gin"
 	"github.com/go-redis/redis/v8"
 	"github.com/golang-module/carbon/v2"
 	"github.com/hibiken/asynq"
 	"github.com/pkg/errors"
-	"github.com/robfig/cron/v3"
-	"net"
-	"net/http"
+	"math"
 	"strings"
 	"time"
 )
@@ -38,6 +36,10 @@ type periodTask struct {
 	Processed int64  `json:"processed"` // run times
 	MaxRetry  int    `json:"maxRetry"`
 	Timeout   int    `json:"timeout"`
+	Paused    bool   `json:"paused"`       // paused cron is not scheduled
+	Jitter    int    `json:"jitter"`       // random delay seconds of each run
+	Misfire   uint   `json:"misfire"`      // policy of missed schedules
+	MisLimit  int    `json:"misfireLimit"` // max catch-up runs of constant.DelayMisfireAll
 }
 
 type periodTaskHandler struct {
@@ -48,19 +50,36 @@ type Task struct {
 	Name    string `json:"name"`
 	Uid     string `json:"uid"`
 	Payload string `json:"payload"`
+	Misfire int64  `json:"misfire,omitempty"` // missed schedule unix timestamp of catch-up run
 }
 
 func (p periodTaskHandler) ProcessTask(ctx context.Context, t *asynq.Task) (err error) {
+	start := time.Now()
+	retry, _ := asynq.GetRetryCount(ctx)
 	ctx = tracing.NewId(ctx)
 	task := Task{
 		Name:    t.Type(),
-		Uid:     t.ResultWriter().TaskID(),
 		Payload: string(t.Payload()),
 	}
+	task.Uid, task.Misfire = splitMisfireUid(t.ResultWriter().TaskID())
+	defer func() {
+		p.qu.saveRun(ctx, task, start, retry, err)
+	}()
+	flowUid, step, isFlow := splitFlowUid(task.Uid)
+	if isFlow {
+		if p.qu.flowCancelled(flowUid) {
+			return
+		}
+		var output string
+		ctx = context.WithValue(ctx, flowOutputCtxKey{}, &output)
+		defer func() {
+			p.qu.flowStepDone(ctx, flowUid, step, output, retry, err)
+		}()
+	}
 	if p.qu.ops.handler != nil {
 		err = p.qu.ops.handler(ctx, task)
-	} else if p.qu.ops.callback != "" {
-		err = p.httpCallback(ctx, task)
+	} else if url := p.qu.ops.getCallback(task.Name); url != "" {
+		err = p.httpCallback(ctx, t, task, url)
 	} else {
 		log.
 			WithContext(ctx).
@@ -74,52 +93,6 @@ func (p periodTaskHandler) ProcessTask(ctx context.Context, t *asynq.Task) (err
 	return
 }
 
-func (p periodTaskHandler) httpCallback(ctx context.Context, task Task) (err error) {
-	client := &http.Client{
-		Timeout: time.Duration(p.qu.ops.callbackTimeout) * time.Second,
-	}
-	body := utils.Struct2Json(task)
-	var r *http.Request
-	r, _ = http.NewRequest(http.MethodPost, p.qu.ops.callback, bytes.NewReader([]byte(body)))
-	r.Header.Add("Content-Type", gin.MIMEJSON)
-	var res *http.Response
-	res, err = client.Do(r)
-	if e, ok := err.(net.Error); ok && e.Timeout() {
-		log.
-			WithContext(ctx).
-			WithFields(map[string]interface{}{
-				"Task": body,
-			}).
-			WithError(err).
-			Error(ErrHttpCallbackTimeout)
-		err = ErrHttpCallbackTimeout
-		return
-	}
-	if err != nil {
-		log.
-			WithContext(ctx).
-			WithFields(map[string]interface{}{
-				"Task": body,
-			}).
-			WithError(err).
-			Error(ErrHttpCallback)
-		err = ErrHttpCallback
-		return
-	}
-	defer res.Body.Close()
-	if res.StatusCode != http.StatusOK {
-		log.
-			WithContext(ctx).
-			WithFields(map[string]interface{}{
-				"Task":       body,
-				"StatusCode": res.StatusCode,
-			}).
-			Error(ErrHttpCallbackInvalidStatusCode)
-		err = ErrHttpCallbackInvalidStatusCode
-	}
-	return
-}
-
 // NewQueue delay queue implemented by asynq: https://github.com/hibiken/asynq
 func NewQueue(options ...func(*QueueOptions)) (qu *Queue) {
 	ops := getQueueOptionsOrSetDefault(nil)
@@ -149,25 +122,28 @@ func NewQueue(options ...func(*QueueOptions)) (qu *Queue) {
 	srv := asynq.NewServer(
 		rs,
 		asynq.Config{
-			Concurrency: 10,
+			RetryDelayFunc: ops.retryDelay,
+			Concurrency:    10,
 			Queues: map[string]int{
 				ops.name: 10,
 			},
 		},
 	)
-	go func() {
-		var h periodTaskHandler
-		h.qu = *qu
-		if e := srv.Run(h); e != nil {
-			log.WithError(err).Error("run task handler failed")
-		}
-	}()
 	qu.ops = *ops
 	qu.redis = rd
 	qu.redisOpt = rs
 	qu.nxLock = nxLock
 	qu.client = client
 	qu.inspector = inspector
+	// handler copies qu, start it after qu is initialized(run store/handler/callback are used)
+	h := periodTaskHandler{
+		qu: *qu,
+	}
+	go func() {
+		if e := srv.Run(h); e != nil {
+			log.WithError(err).Error("run task handler failed")
+		}
+	}()
 	// initialize scanner
 	go func() {
 		for {
@@ -175,6 +151,22 @@ func NewQueue(options ...func(*QueueOptions)) (qu *Queue) {
 			qu.scan()
 		}
 	}()
+	if qu.ops.runStore != nil {
+		// initialize clear expired run history
+		go func() {
+			for {
+				time.Sleep(10 * time.Minute)
+				qu.clearRun()
+			}
+		}()
+	}
+	// initialize clear expired workflow runs
+	go func() {
+		for {
+			time.Sleep(10 * time.Minute)
+			qu.clearFlow()
+		}
+	}()
 	if qu.ops.clearArchived > 0 {
 		// initialize clear archived
 		go func() {
@@ -196,6 +188,14 @@ func (qu Queue) Once(options ...func(*QueueTaskOptions)) (err error) {
 		err = errors.WithStack(ErrUuidNil)
 		return
 	}
+	err = checkMisfire(ops.misfire)
+	if err != nil {
+		return
+	}
+	if ops.at != nil && ops.at.Unix()+constant.DelayMisfireThreshold < time.Now().Unix() && ops.misfire == constant.DelayMisfireSkip {
+		err = errors.Wrap(ErrMisfireSkipped, ops.uid)
+		return
+	}
 	t := asynq.NewTask(ops.name+".once", []byte(ops.payload), asynq.TaskID(ops.uid))
 	taskOpts := []asynq.Option{
 		asynq.Queue(qu.ops.name),
@@ -230,20 +230,45 @@ func (qu Queue) Cron(options ...func(*QueueTaskOptions)) (err error) {
 		err = errors.WithStack(ErrUuidNil)
 		return
 	}
+	if ops.jitter < 0 {
+		err = errors.Wrap(ErrJitterInvalid, fmt.Sprintf("%d", ops.jitter))
+		return
+	}
+	err = checkMisfire(ops.misfire)
+	if err != nil {
+		return
+	}
+	var expr string
+	expr, err = parseExpr(ops.expr, ops.timezone)
+	if err != nil {
+		return
+	}
 	var next int64
-	next, err = getNext(ops.expr, 0)
+	next, err = getNext(expr, 0)
 	if err != nil {
-		err = errors.WithStack(ErrExprInvalid)
 		return
 	}
 	t := periodTask{
-		Expr:     ops.expr,
+		Expr:     expr,
 		Name:     ops.name + ".cron",
 		Uid:      ops.uid,
 		Payload:  ops.payload,
 		Next:     next,
 		MaxRetry: ops.maxRetry,
 		Timeout:  ops.timeout,
+		Jitter:   ops.jitter,
+		Misfire:  ops.misfire,
+		MisLimit: ops.misLimit,
+	}
+	// keep paused by inspector when cron is registered again
+	if old, e := qu.redis.HGet(context.Background(), qu.ops.redisPeriodKey, ops.uid).Result(); e == nil {
+		var item periodTask
+		utils.Json2Struct(old, &item)
+		t.Paused = item.Paused
+		// keep next schedule of the same expr, scanner finds the missed schedules when service restarts
+		if !item.Paused && item.Expr == expr && item.Next > 0 {
+			t.Next = item.Next
+		}
 	}
 	_, err = qu.redis.HSet(context.Background(), qu.ops.redisPeriodKey, ops.uid, utils.Struct2Json(t)).Result()
 	if err != nil {
@@ -297,22 +322,27 @@ func (qu Queue) scan() {
 		return
 	}
 	defer qu.nxLock.Unlock()
+	now := time.Now().Unix()
+	// scanners of all instances were down since last scan, schedules in the gap are missed
+	// (a lagged next caused by a long-running instance is not a misfire)
+	last, _ := qu.redis.Get(ctx, qu.ops.redisPeriodKey+".scan").Int64()
+	down := last+constant.DelayMisfireThreshold < now
 	m, _ := qu.redis.HGetAll(ctx, qu.ops.redisPeriodKey).Result()
 	p := qu.redis.Pipeline()
-	ops := qu.ops
 	for _, v := range m {
 		var item periodTask
 		utils.Json2Struct(v, &item)
-		next, _ := getNext(item.Expr, item.Next)
-		t := asynq.NewTask(item.Name, []byte(item.Payload), asynq.TaskID(item.Uid))
-		taskOpts := []asynq.Option{
-			asynq.Queue(ops.name),
-			asynq.MaxRetry(ops.maxRetry),
-			asynq.Timeout(time.Duration(item.Timeout) * time.Second),
+		if item.Paused {
+			continue
 		}
-		if item.MaxRetry > 0 {
-			taskOpts = append(taskOpts, asynq.MaxRetry(item.MaxRetry))
+		misfired := false
+		if down && item.Next+constant.DelayMisfireThreshold < now && !qu.running(item.Uid) {
+			qu.misfire(&item)
+			misfired = true
 		}
+		next, _ := getNext(item.Expr, item.Next)
+		t := asynq.NewTask(item.Name, []byte(item.Payload), asynq.TaskID(item.Uid))
+		taskOpts := qu.periodTaskOptions(item)
 		diff := next - item.Next
 		if diff > 10 {
 			retention := diff / 3
@@ -323,19 +353,100 @@ func (qu Queue) scan() {
 			// set retention avoid repeat in short time
 			taskOpts = append(taskOpts, asynq.Retention(time.Duration(retention)*time.Second))
 		}
-		taskOpts = append(taskOpts, asynq.ProcessAt(time.Unix(item.Next, 0)))
+		taskOpts = append(taskOpts, asynq.ProcessAt(time.Unix(item.Next, 0).Add(getJitter(item.Jitter, diff))))
 		_, err := qu.client.Enqueue(t, taskOpts...)
 		// enqueue success, update next
 		if err == nil {
 			item.Next = next
+		}
+		if err == nil || misfired {
 			p.HSet(ctx, qu.ops.redisPeriodKey, item.Uid, utils.Struct2Json(item))
 		}
 	}
+	p.Set(ctx, qu.ops.redisPeriodKey+".scan", now, 0)
 	// batch save to cache
 	p.Exec(ctx)
 	return
 }
 
+// running check whether an instance of the cron is waiting or running, catch-up runs are not needed
+func (qu Queue) running(uid string) bool {
+	info, err := qu.inspector.GetTaskInfo(qu.ops.name, uid)
+	if err != nil {
+		return false
+	}
+	switch info.State {
+	case asynq.TaskStatePending, asynq.TaskStateActive, asynq.TaskStateRetry:
+		return true
+	}
+	return false
+}
+
+// misfire enqueue catch-up runs of missed schedules by policy, item.Next is moved to the upcoming schedule
+func (qu Queue) misfire(item *periodTask) {
+	now := time.Now().Unix()
+	since := item.Next
+	// the instance scheduled before service down is also missed, it is replaced by catch-up runs
+	info, err := qu.inspector.GetTaskInfo(qu.ops.name, item.Uid)
+	if err == nil && info.State == asynq.TaskStateScheduled && info.NextProcessAt.Unix()+constant.DelayMisfireThreshold < now {
+		if qu.inspector.DeleteTask(qu.ops.name, item.Uid) == nil && info.NextProcessAt.Unix() < since {
+			since = info.NextProcessAt.Unix()
+		}
+	}
+	limit := 1
+	if item.Misfire == constant.DelayMisfireAll {
+		limit = item.MisLimit
+		if limit <= 0 {
+			limit = constant.DelayMisfireLimit
+		}
+	}
+	runs := make([]int64, 0)
+	switch item.Misfire {
+	case constant.DelayMisfireSkip:
+	case constant.DelayMisfireAll:
+		runs, _ = getMissed(item.Expr, since, now, limit)
+	default:
+		// the latest missed schedule
+		missed, _ := getMissed(item.Expr, since, now, math.MaxInt32)
+		if len(missed) > 0 {
+			runs = missed[len(missed)-1:]
+		}
+	}
+	for _, timestamp := range runs {
+		t := asynq.NewTask(item.Name, []byte(item.Payload), asynq.TaskID(getMisfireUid(item.Uid, timestamp)))
+		_, err = qu.client.Enqueue(t, qu.periodTaskOptions(*item)...)
+		if err != nil && !errors.Is(err, asynq.ErrTaskIDConflict) {
+			log.
+				WithFields(map[string]interface{}{
+					"Uid":  item.Uid,
+					"Time": timestamp,
+				}).
+				WithError(err).
+				Warn("enqueue misfire task failed")
+		}
+	}
+	log.
+		WithFields(map[string]interface{}{
+			"Uid":     item.Uid,
+			"Since":   time.Unix(since, 0).Format(time.RFC3339),
+			"Catchup": len(runs),
+		}).
+		Info("cron missed schedule")
+	item.Next, _ = getNext(item.Expr, now)
+}
+
+func (qu Queue) periodTaskOptions(item periodTask) []asynq.Option {
+	taskOpts := []asynq.Option{
+		asynq.Queue(qu.ops.name),
+		asynq.MaxRetry(qu.ops.maxRetry),
+		asynq.Timeout(time.Duration(item.Timeout) * time.Second),
+	}
+	if item.MaxRetry > 0 {
+		taskOpts = append(taskOpts, asynq.MaxRetry(item.MaxRetry))
+	}
+	return taskOpts
+}
+
 func (qu Queue) clearArchived() {
 	list, err := qu.inspector.ListArchivedTasks(qu.ops.name, asynq.Page(1), asynq.PageSize(100))
 	if err != nil {
@@ -386,17 +497,3 @@ func (qu Queue) clearArchived() {
 		}
 	}
 }
-
-func getNext(expr string, timestamp int64) (next int64, err error) {
-	var schedule cron.Schedule
-	schedule, err = cron.ParseStandard(expr)
-	if err != nil {
-		return
-	}
-	t := time.Now()
-	if timestamp > 0 {
-		t = time.Unix(timestamp, 0)
-	}
-	next = schedule.Next(t).Unix()
-	return
-}
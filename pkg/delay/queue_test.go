@@ -1,7 +1,12 @@
 package delay
 
 import (
+	"context"
 	"fmt"
+	"github.com/ennismar/go-helper/pkg/constant"
+	"github.com/ennismar/go-helper/pkg/req"
+	"github.com/go-redis/redis/v8"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -74,3 +79,179 @@ func TestNewQueue(t *testing.T) {
 	ch := make(chan int)
 	<-ch
 }
+
+func TestQueue_Inspector(t *testing.T) {
+	qu := NewQueue()
+	qu.Cron(
+		WithQueueTaskUuid("order9"),
+		WithQueueTaskName("task9"),
+		WithQueueTaskExpr("@every 10s"),
+	)
+	time.Sleep(2 * time.Second)
+	fmt.Println(qu.FindTask(&req.DelayTask{
+		State: "scheduled",
+		Name:  "task9",
+	}))
+	fmt.Println(qu.PauseCron("order9"))
+	fmt.Println(qu.UpdateCronExpr("order9", "@every 20s"))
+	fmt.Println(qu.ResumeCron("order9"))
+	fmt.Println(qu.FindCron(req.DelayCron{
+		Uid: "order9",
+	}))
+	fmt.Println(qu.RequeueArchived())
+}
+
+func TestQueue_FindRun(t *testing.T) {
+	qu := NewQueue(
+		WithQueueRunStore(NewRedisRunStore(redis.NewClient(&redis.Options{}), "")),
+		WithQueueRunRetention(3600),
+	)
+	qu.Once(
+		WithQueueTaskUuid("once.order2"),
+		WithQueueTaskName("once.task2"),
+		WithQueueTaskNow(true),
+	)
+	time.Sleep(5 * time.Second)
+	fmt.Println(qu.FindRun(&req.DelayTaskRun{
+		Uid: "once.order2",
+	}))
+}
+
+func TestQueue_CronTimezone(t *testing.T) {
+	qu := NewQueue()
+	fmt.Println(qu.Cron(
+		WithQueueTaskUuid("order10"),
+		WithQueueTaskName("task10"),
+		WithQueueTaskExpr("*/30 * * * * *"),
+		WithQueueTaskJitter(5),
+	))
+	fmt.Println(qu.Cron(
+		WithQueueTaskUuid("order11"),
+		WithQueueTaskName("task11"),
+		WithQueueTaskExpr("0 9 * * *"),
+		WithQueueTaskTimezone("Asia/Shanghai"),
+	))
+	// invalid expr/timezone
+	fmt.Println(qu.Cron(
+		WithQueueTaskUuid("order12"),
+		WithQueueTaskName("task12"),
+		WithQueueTaskExpr("0 0 30 2 *"),
+	))
+	fmt.Println(qu.Cron(
+		WithQueueTaskUuid("order12"),
+		WithQueueTaskName("task12"),
+		WithQueueTaskExpr("0 9 * * *"),
+		WithQueueTaskTimezone("Mars/Base"),
+	))
+}
+
+func TestQueue_Misfire(t *testing.T) {
+	qu := NewQueue()
+	fmt.Println(qu.Cron(
+		WithQueueTaskUuid("order13"),
+		WithQueueTaskName("task13"),
+		WithQueueTaskExpr("@every 10s"),
+		WithQueueTaskMisfire(constant.DelayMisfireAll),
+		WithQueueTaskMisfireLimit(3),
+	))
+	// passed once task is skipped
+	fmt.Println(qu.Once(
+		WithQueueTaskUuid("order14"),
+		WithQueueTaskName("task14"),
+		WithQueueTaskAt(time.Now().Add(-time.Minute)),
+		WithQueueTaskMisfire(constant.DelayMisfireSkip),
+	))
+	// invalid policy
+	fmt.Println(qu.Cron(
+		WithQueueTaskUuid("order15"),
+		WithQueueTaskName("task15"),
+		WithQueueTaskExpr("@every 10s"),
+		WithQueueTaskMisfire(10),
+	))
+	ch := make(chan struct{})
+	<-ch
+}
+
+func TestQueue_MisfireLongHandler(t *testing.T) {
+	var running, max int32
+	qu := NewQueue(
+		WithQueueHandler(func(ctx context.Context, task Task) error {
+			if task.Uid != "order16" {
+				return nil
+			}
+			n := atomic.AddInt32(&running, 1)
+			defer atomic.AddInt32(&running, -1)
+			if n > atomic.LoadInt32(&max) {
+				atomic.StoreInt32(&max, n)
+			}
+			fmt.Println(task.Uid, task.Misfire, n)
+			// longer than interval, next lags but it is not a misfire
+			time.Sleep(15 * time.Second)
+			return nil
+		}),
+	)
+	fmt.Println(qu.Cron(
+		WithQueueTaskUuid("order16"),
+		WithQueueTaskName("task16"),
+		WithQueueTaskExpr("@every 5s"),
+		WithQueueTaskMisfire(constant.DelayMisfireAll),
+		WithQueueTaskTimeout(60),
+	))
+	time.Sleep(time.Minute)
+	// no concurrent duplicate runs
+	fmt.Println(atomic.LoadInt32(&max))
+}
+
+func TestQueue_Flow(t *testing.T) {
+	qu := NewQueue(
+		WithQueueHandler(func(ctx context.Context, task Task) error {
+			payload := ParseFlowPayload(task.Payload)
+			fmt.Println(task.Name, payload.Step, payload.Outputs)
+			SetFlowOutput(ctx, payload.Step+" done")
+			return nil
+		}),
+	)
+	flow := Workflow{
+		Name: "nightly",
+		Steps: []WorkflowStep{
+			{Name: "a"},
+			{Name: "b", Depends: []string{"a"}},
+			{Name: "c", Depends: []string{"a"}, MaxRetry: 1},
+			{Name: "d", Depends: []string{"b", "c"}},
+		},
+	}
+	uid := fmt.Sprintf("flow%d", time.Now().Unix())
+	fmt.Println(qu.StartFlow(
+		flow,
+		WithQueueTaskUuid(uid),
+		WithQueueTaskPayload("2024-01-01"),
+	))
+	time.Sleep(15 * time.Second)
+	r := req.DelayFlow{
+		Uid: uid,
+	}
+	fmt.Println(qu.FindFlow(&r))
+	fmt.Println(qu.CancelFlow(uid))
+}
+
+func TestQueue_Callback(t *testing.T) {
+	qu := NewQueue(
+		WithQueueCallback("http://127.0.0.1:8080/api/delay/callback"),
+		WithQueueCallbackByName("task16", "http://127.0.0.1:8081/api/delay/callback"),
+		WithQueueCallbackStatus(200, 201, 202),
+		WithQueueCallbackSign("app1", "secret"),
+		WithQueueBackoff(2, 60),
+		WithQueueBackoffByName("task16", 1, 10),
+	)
+	fmt.Println(qu.Once(
+		WithQueueTaskUuid("order16"),
+		WithQueueTaskName("task16"),
+		WithQueueTaskNow(true),
+	))
+	time.Sleep(30 * time.Second)
+	r := req.DelayTask{
+		State: constant.DelayTaskStateRetry,
+		Uid:   "order16",
+	}
+	fmt.Println(qu.FindTask(&r))
+}
@@ -0,0 +1,285 @@
+package delay
+
+import (
+	"context"
+	"fmt"
+	"github.com/ennismar/go-helper/pkg/constant"
+	"github.com/ennismar/go-helper/pkg/log"
+	"github.com/ennismar/go-helper/pkg/req"
+	"github.com/ennismar/go-helper/pkg/resp"
+	"github.com/ennismar/go-helper/pkg/tracing"
+	"github.com/ennismar/go-helper/pkg/utils"
+	"github.com/go-redis/redis/v8"
+	"github.com/golang-module/carbon/v2"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+	"strings"
+	"time"
+)
+
+// RunStore save once/cron task run history, queue uses it by WithQueueRunStore
+type RunStore interface {
+	// Save save one run
+	Save(ctx context.Context, run *TaskRun) error
+	// Find find runs by uid/name/status, latest first
+	Find(ctx context.Context, r *req.DelayTaskRun) ([]TaskRun, error)
+	// Clear remove runs started before the time
+	Clear(ctx context.Context, before time.Time) error
+}
+
+// FindRun query task run history
+func (qu Queue) FindRun(r *req.DelayTaskRun) (rp []resp.DelayTaskRun, err error) {
+	rp = make([]resp.DelayTaskRun, 0)
+	if qu.Error != nil {
+		err = qu.Error
+		return
+	}
+	if qu.ops.runStore == nil {
+		err = errors.WithStack(ErrRunStoreNil)
+		return
+	}
+	var list []TaskRun
+	list, err = qu.ops.runStore.Find(context.Background(), r)
+	if err != nil {
+		return
+	}
+	utils.Struct2StructByJson(list, &rp)
+	return
+}
+
+// save run history, it never breaks the task
+func (qu Queue) saveRun(ctx context.Context, task Task, start time.Time, retry int, err error) {
+	if qu.ops.runStore == nil {
+		return
+	}
+	end := time.Now()
+	requestId, _, _ := tracing.GetId(ctx)
+	run := TaskRun{
+		Uid:       task.Uid,
+		Name:      task.Name,
+		StartAt:   carbon.DateTime{Carbon: carbon.Time2Carbon(start)},
+		EndAt:     carbon.DateTime{Carbon: carbon.Time2Carbon(end)},
+		Duration:  end.Sub(start).Milliseconds(),
+		Status:    constant.DelayRunStatusSuccess,
+		Retry:     retry,
+		RequestId: requestId,
+	}
+	if err != nil {
+		run.Status = constant.DelayRunStatusFailed
+		run.Error = err.Error()
+	}
+	if e := qu.ops.runStore.Save(ctx, &run); e != nil {
+		log.WithContext(ctx).WithError(e).Warn("save task run failed")
+	}
+}
+
+func (qu Queue) clearRun() {
+	if qu.ops.runStore == nil || qu.ops.runRetention <= 0 {
+		return
+	}
+	before := time.Now().Add(-time.Duration(qu.ops.runRetention) * time.Second)
+	if err := qu.ops.runStore.Clear(context.Background(), before); err != nil {
+		log.WithError(err).Warn("clear task run failed")
+	}
+}
+
+// RedisRunStore save runs in a redis sorted set(score: start unix millisecond)
+type RedisRunStore struct {
+	redis redis.UniversalClient
+	key   string
+}
+
+func NewRedisRunStore(rd redis.UniversalClient, key string) *RedisRunStore {
+	if key == "" {
+		key = "delay.queue.run"
+	}
+	return &RedisRunStore{
+		redis: rd,
+		key:   key,
+	}
+}
+
+func (rs RedisRunStore) Save(ctx context.Context, run *TaskRun) (err error) {
+	if rs.redis == nil {
+		err = errors.WithStack(ErrRedisNil)
+		return
+	}
+	var id int64
+	id, err = rs.redis.Incr(ctx, rs.key+".id").Result()
+	if err != nil {
+		return
+	}
+	run.Id = uint(id)
+	run.CreatedAt = run.EndAt
+	run.UpdatedAt = run.EndAt
+	_, err = rs.redis.ZAdd(ctx, rs.key, &redis.Z{
+		Score:  float64(run.StartAt.Carbon.TimestampMilli()),
+		Member: utils.Struct2Json(run),
+	}).Result()
+	return
+}
+
+func (rs RedisRunStore) Find(ctx context.Context, r *req.DelayTaskRun) (rp []TaskRun, err error) {
+	rp = make([]TaskRun, 0)
+	if rs.redis == nil {
+		err = errors.WithStack(ErrRedisNil)
+		return
+	}
+	var members []string
+	members, err = rs.redis.ZRevRange(ctx, rs.key, 0, -1).Result()
+	if err != nil {
+		return
+	}
+	// sorted set can not filter fields, filter in memory(retention keeps it small)
+	list := make([]TaskRun, 0)
+	for _, member := range members {
+		var item TaskRun
+		utils.Json2Struct(member, &item)
+		if matchRun(item, r) {
+			list = append(list, item)
+		}
+	}
+	page := &r.Page
+	page.Total = int64(len(list))
+	if page.NoPagination {
+		page.GetLimit()
+		rp = list
+		return
+	}
+	limit, offset := page.GetLimit()
+	if offset < len(list) {
+		if offset+limit < len(list) {
+			list = list[:offset+limit]
+		}
+		rp = list[offset:]
+	}
+	return
+}
+
+func (rs RedisRunStore) Clear(ctx context.Context, before time.Time) (err error) {
+	if rs.redis == nil {
+		err = errors.WithStack(ErrRedisNil)
+		return
+	}
+	_, err = rs.redis.ZRemRangeByScore(ctx, rs.key, "-inf", fmt.Sprintf("(%d", before.UnixNano()/int64(time.Millisecond))).Result()
+	return
+}
+
+// GormRunStore save runs in mysql table, Migrate before use
+type GormRunStore struct {
+	db       *gorm.DB
+	tbPrefix string
+}
+
+func NewGormRunStore(db *gorm.DB, tbPrefix string) *GormRunStore {
+	if tbPrefix == "" {
+		tbPrefix = constant.DelayExportTbPrefix
+	}
+	return &GormRunStore{
+		db:       db,
+		tbPrefix: tbPrefix,
+	}
+}
+
+// Migrate mysql DDL migrate rollback is not supported
+func (gs GormRunStore) Migrate() (err error) {
+	if gs.db == nil {
+		err = errors.WithStack(ErrDbNil)
+		return
+	}
+	err = gs.initSession(context.Background()).AutoMigrate(
+		new(TaskRun),
+	)
+	return
+}
+
+func (gs GormRunStore) Save(ctx context.Context, run *TaskRun) (err error) {
+	if gs.db == nil {
+		err = errors.WithStack(ErrDbNil)
+		return
+	}
+	err = gs.initSession(ctx).Create(run).Error
+	return
+}
+
+func (gs GormRunStore) Find(ctx context.Context, r *req.DelayTaskRun) (rp []TaskRun, err error) {
+	rp = make([]TaskRun, 0)
+	if gs.db == nil {
+		err = errors.WithStack(ErrDbNil)
+		return
+	}
+	q := gs.initSession(ctx).
+		Model(&TaskRun{}).
+		Order("start_at DESC").
+		Order("id DESC")
+	uid := strings.TrimSpace(r.Uid)
+	if uid != "" {
+		q.Where("uid = ?", uid)
+	}
+	name := strings.TrimSpace(r.Name)
+	if name != "" {
+		q.Where("name LIKE ?", fmt.Sprintf("%%%s%%", name))
+	}
+	if r.Status != nil {
+		q.Where("status = ?", *r.Status)
+	}
+	page := &r.Page
+	countCache := false
+	if page.CountCache != nil {
+		countCache = *page.CountCache
+	}
+	if !page.NoPagination {
+		if !page.SkipCount {
+			q.Count(&page.Total)
+		}
+		if page.Total > 0 || page.SkipCount {
+			limit, offset := page.GetLimit()
+			err = q.Limit(limit).Offset(offset).Find(&rp).Error
+		}
+	} else {
+		// no pagination
+		err = q.Find(&rp).Error
+		page.Total = int64(len(rp))
+		page.GetLimit()
+	}
+	page.CountCache = &countCache
+	return
+}
+
+func (gs GormRunStore) Clear(ctx context.Context, before time.Time) (err error) {
+	if gs.db == nil {
+		err = errors.WithStack(ErrDbNil)
+		return
+	}
+	// run history is not soft deleted
+	err = gs.initSession(ctx).
+		Unscoped().
+		Where("start_at < ?", carbon.Time2Carbon(before).ToDateTimeString()).
+		Delete(&TaskRun{}).Error
+	return
+}
+
+func (gs GormRunStore) initSession(ctx context.Context) *gorm.DB {
+	namingStrategy := schema.NamingStrategy{
+		TablePrefix:   gs.tbPrefix,
+		SingularTable: true,
+	}
+	session := gs.db.WithContext(ctx).Session(&gorm.Session{})
+	session.NamingStrategy = namingStrategy
+	return session
+}
+
+func matchRun(item TaskRun, r *req.DelayTaskRun) bool {
+	uid := strings.TrimSpace(r.Uid)
+	if uid != "" && item.Uid != uid {
+		return false
+	}
+	if !strings.Contains(item.Name, strings.TrimSpace(r.Name)) {
+		return false
+	}
+	if r.Status != nil && item.Status != uint(*r.Status) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,240 @@
+package delay
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/ennismar/go-helper/pkg/oss"
+	"github.com/ennismar/go-helper/pkg/utils"
+	"github.com/pkg/errors"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Storage save export files, Export uses it by WithExportStorage
+type Storage interface {
+	// Put upload local file as object key
+	Put(ctx context.Context, key, filename string) error
+	// SignUrl presigned download url of the object
+	SignUrl(ctx context.Context, key string, expire time.Duration) (string, error)
+	// Delete remove objects
+	Delete(ctx context.Context, keys ...string) error
+}
+
+// S3Storage aws s3 compatible storage
+type S3Storage struct {
+	client *s3.S3
+	bucket string
+}
+
+func NewS3Storage(endpoint, key, secret, bucket string) *S3Storage {
+	config := &aws.Config{
+		Credentials:      credentials.NewStaticCredentials(key, secret, ""),
+		Endpoint:         aws.String(endpoint),
+		S3ForcePathStyle: aws.Bool(true),
+		DisableSSL:       aws.Bool(true),
+		Region:           aws.String("cn"),
+		HTTPClient: &http.Client{
+			Transport: &http.Transport{
+				MaxConnsPerHost: 1000,
+			},
+		},
+	}
+	sess := session.Must(session.NewSession(config))
+	return &S3Storage{
+		client: s3.New(sess),
+		bucket: bucket,
+	}
+}
+
+func (ss S3Storage) Put(ctx context.Context, key, filename string) (err error) {
+	var file *os.File
+	file, err = os.Open(filename)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	_, err = ss.client.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(ss.bucket),
+		Key:    aws.String(key),
+		Body:   file,
+	})
+	return
+}
+
+func (ss S3Storage) SignUrl(_ context.Context, key string, expire time.Duration) (rp string, err error) {
+	r, _ := ss.client.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(ss.bucket),
+		Key:    aws.String(key),
+	})
+	rp, err = r.Presign(expire)
+	return
+}
+
+func (ss S3Storage) Delete(ctx context.Context, keys ...string) (err error) {
+	if len(keys) == 0 {
+		return
+	}
+	objs := make([]*s3.ObjectIdentifier, 0, len(keys))
+	for _, key := range keys {
+		objs = append(objs, &s3.ObjectIdentifier{
+			Key: aws.String(key),
+		})
+	}
+	_, err = ss.client.DeleteObjectsWithContext(ctx, &s3.DeleteObjectsInput{
+		Bucket: aws.String(ss.bucket),
+		Delete: &s3.Delete{
+			Objects: objs,
+			Quiet:   aws.Bool(false),
+		},
+	})
+	return
+}
+
+// MinioStorage minio storage by oss.MinioOss
+type MinioStorage struct {
+	client *oss.MinioOss
+	bucket string
+}
+
+func NewMinioStorage(client *oss.MinioOss, bucket string) *MinioStorage {
+	return &MinioStorage{
+		client: client,
+		bucket: bucket,
+	}
+}
+
+func (mo MinioStorage) Put(ctx context.Context, key, filename string) error {
+	return mo.client.PutLocal(ctx, mo.bucket, key, filename)
+}
+
+func (mo MinioStorage) SignUrl(ctx context.Context, key string, expire time.Duration) (string, error) {
+	return mo.client.GetPresignedUrl(ctx, mo.bucket, key, expire)
+}
+
+func (mo MinioStorage) Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	return mo.client.BatchRemove(ctx, mo.bucket, keys)
+}
+
+// LocalStorage save objects in local dir, it serves signed urls as http.Handler(mount it at baseUrl path)
+type LocalStorage struct {
+	dir     string
+	baseUrl string
+	secret  string
+}
+
+func NewLocalStorage(dir, baseUrl, secret string) *LocalStorage {
+	return &LocalStorage{
+		dir:     dir,
+		baseUrl: strings.TrimSuffix(baseUrl, "/"),
+		secret:  secret,
+	}
+}
+
+func (ls LocalStorage) Put(_ context.Context, key, filename string) (err error) {
+	var name string
+	name, err = ls.path(key)
+	if err != nil {
+		return
+	}
+	err = os.MkdirAll(filepath.Dir(name), os.ModePerm)
+	if err != nil {
+		return
+	}
+	var src, dst *os.File
+	src, err = os.Open(filename)
+	if err != nil {
+		return
+	}
+	defer src.Close()
+	dst, err = os.Create(name)
+	if err != nil {
+		return
+	}
+	defer dst.Close()
+	_, err = io.Copy(dst, src)
+	return
+}
+
+func (ls LocalStorage) SignUrl(_ context.Context, key string, expire time.Duration) (rp string, err error) {
+	_, err = ls.path(key)
+	if err != nil {
+		return
+	}
+	expires := fmt.Sprintf("%d", time.Now().Add(expire).Unix())
+	v := url.Values{}
+	v.Set("expires", expires)
+	v.Set("signature", ls.sign(key, expires))
+	rp = fmt.Sprintf("%s/%s?%s", ls.baseUrl, strings.TrimPrefix(key, "/"), v.Encode())
+	return
+}
+
+func (ls LocalStorage) Delete(_ context.Context, keys ...string) (err error) {
+	for _, key := range keys {
+		var name string
+		name, err = ls.path(key)
+		if err != nil {
+			return
+		}
+		err = os.Remove(name)
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// ServeHTTP download object by signed url, path is the object key after baseUrl path
+func (ls LocalStorage) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	prefix := "/"
+	if u, err := url.Parse(ls.baseUrl); err == nil && u.Path != "" {
+		prefix = u.Path + "/"
+	}
+	key := strings.TrimPrefix(r.URL.Path, prefix)
+	expires := r.URL.Query().Get("expires")
+	signature := r.URL.Query().Get("signature")
+	if utils.Str2Int64(expires) < time.Now().Unix() || !hmac.Equal([]byte(signature), []byte(ls.sign(key, expires))) {
+		http.Error(w, ErrSignUrlInvalid.Error(), http.StatusForbidden)
+		return
+	}
+	name, err := ls.path(key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filepath.Base(name)))
+	http.ServeFile(w, r, name)
+}
+
+// path local file path of key, key can not be outside dir
+func (ls LocalStorage) path(key string) (rp string, err error) {
+	rp = filepath.Join(ls.dir, filepath.FromSlash(strings.TrimPrefix(key, "/")))
+	rel, e := filepath.Rel(ls.dir, rp)
+	if e != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		err = errors.Wrap(ErrStorageKeyInvalid, key)
+	}
+	return
+}
+
+func (ls LocalStorage) sign(key, expires string) string {
+	hash := hmac.New(sha256.New, []byte(ls.secret))
+	hash.Write([]byte(strings.TrimPrefix(key, "/") + "|" + expires))
+	return hex.EncodeToString(hash.Sum(nil))
+}
@@ -0,0 +1,507 @@
+package delay
+
+import (
+	"context"
+	"fmt"
+	"github.com/ennismar/go-helper/pkg/constant"
+	"github.com/ennismar/go-helper/pkg/lock"
+	"github.com/ennismar/go-helper/pkg/log"
+	"github.com/ennismar/go-helper/pkg/req"
+	"github.com/ennismar/go-helper/pkg/resp"
+	"github.com/ennismar/go-helper/pkg/utils"
+	"github.com/go-redis/redis/v8"
+	"github.com/golang-module/carbon/v2"
+	"github.com/hibiken/asynq"
+	"github.com/pkg/errors"
+	"sort"
+	"strings"
+	"time"
+)
+
+// flowSep separates flow run uid and step name of step task id
+const flowSep = ".flow."
+
+// Workflow DAG of steps, a step is enqueued by Queue.Once when all depends succeed
+type Workflow struct {
+	Name  string
+	Steps []WorkflowStep
+}
+
+type WorkflowStep struct {
+	Name     string   // unique step name in workflow
+	Task     string   // task name received by handler(default: step name), it ends with .once
+	Depends  []string // upstream step names, empty means the step starts with workflow
+	MaxRetry int      // step retry count(0: queue max retry)
+	Timeout  int      // step timeout seconds
+}
+
+// FlowPayload payload of step task, handler reads upstream outputs from it
+type FlowPayload struct {
+	Uid     string            `json:"uid"`     // flow run uid
+	Step    string            `json:"step"`    // current step name
+	Input   string            `json:"input"`   // flow input
+	Outputs map[string]string `json:"outputs"` // outputs of depends(key: step name)
+}
+
+type flowRun struct {
+	Uid       string               `json:"uid"`
+	Name      string               `json:"name"`
+	Input     string               `json:"input"`
+	Status    uint                 `json:"status"`
+	Steps     map[string]*flowStep `json:"steps"`
+	CreatedAt int64                `json:"createdAt"`
+	UpdatedAt int64                `json:"updatedAt"`
+}
+
+type flowStep struct {
+	WorkflowStep
+	Status  uint   `json:"status"`
+	Output  string `json:"output"`
+	Error   string `json:"error"`
+	Retry   int    `json:"retry"`
+	StartAt int64  `json:"startAt"`
+	EndAt   int64  `json:"endAt"`
+}
+
+type flowOutputCtxKey struct{}
+
+// SetFlowOutput save step output in handler, downstream steps get it by FlowPayload.Outputs
+func SetFlowOutput(ctx context.Context, output string) {
+	if p, ok := ctx.Value(flowOutputCtxKey{}).(*string); ok {
+		*p = output
+	}
+}
+
+// ParseFlowPayload parse step task payload
+func ParseFlowPayload(payload string) (rp FlowPayload) {
+	utils.Json2Struct(payload, &rp)
+	return
+}
+
+// StartFlow start a workflow run, uid(WithQueueTaskUuid) is required and input is passed by WithQueueTaskPayload
+func (qu Queue) StartFlow(flow Workflow, options ...func(*QueueTaskOptions)) (err error) {
+	if qu.Error != nil {
+		err = qu.Error
+		return
+	}
+	ops := getQueueTaskOptionsOrSetDefault(nil)
+	for _, f := range options {
+		f(ops)
+	}
+	if ops.uid == "" {
+		err = errors.WithStack(ErrUuidNil)
+		return
+	}
+	if strings.Contains(ops.uid, flowSep) {
+		err = errors.Wrap(ErrUuidInvalid, ops.uid)
+		return
+	}
+	err = checkFlow(flow)
+	if err != nil {
+		return
+	}
+	now := time.Now().Unix()
+	run := flowRun{
+		Uid:       ops.uid,
+		Name:      flow.Name,
+		Input:     ops.payload,
+		Status:    constant.DelayFlowStatusRunning,
+		Steps:     make(map[string]*flowStep, len(flow.Steps)),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	for _, item := range flow.Steps {
+		step := item
+		if step.Task == "" {
+			step.Task = step.Name
+		}
+		run.Steps[step.Name] = &flowStep{
+			WorkflowStep: step,
+		}
+	}
+	ctx := context.Background()
+	var ok bool
+	ok, err = qu.redis.HSetNX(ctx, qu.ops.redisFlowKey, run.Uid, utils.Struct2Json(run)).Result()
+	if err != nil {
+		return
+	}
+	if !ok {
+		err = errors.Wrap(ErrFlowExists, run.Uid)
+		return
+	}
+	// enqueue failed: the run is saved as failed and error is returned
+	var e error
+	err = qu.updateFlow(run.Uid, func(r *flowRun) error {
+		e = qu.enqueueFlowSteps(r)
+		return nil
+	})
+	if err == nil {
+		err = e
+	}
+	return
+}
+
+// FindFlow find workflow runs by name(contains)/uid/status, latest first
+func (qu Queue) FindFlow(r *req.DelayFlow) (rp []resp.DelayFlow, err error) {
+	rp = make([]resp.DelayFlow, 0)
+	if qu.Error != nil {
+		err = qu.Error
+		return
+	}
+	var m map[string]string
+	m, err = qu.redis.HGetAll(context.Background(), qu.ops.redisFlowKey).Result()
+	if err != nil {
+		return
+	}
+	name := strings.TrimSpace(r.Name)
+	uid := strings.TrimSpace(r.Uid)
+	list := make([]flowRun, 0)
+	for _, v := range m {
+		var item flowRun
+		utils.Json2Struct(v, &item)
+		if !strings.Contains(item.Name, name) || (uid != "" && item.Uid != uid) {
+			continue
+		}
+		if r.Status != nil && item.Status != uint(*r.Status) {
+			continue
+		}
+		list = append(list, item)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].CreatedAt == list[j].CreatedAt {
+			return list[i].Uid < list[j].Uid
+		}
+		return list[i].CreatedAt > list[j].CreatedAt
+	})
+	page := &r.Page
+	page.Total = int64(len(list))
+	if !page.NoPagination {
+		limit, offset := page.GetLimit()
+		if offset >= len(list) {
+			list = list[:0]
+		} else {
+			if offset+limit < len(list) {
+				list = list[:offset+limit]
+			}
+			list = list[offset:]
+		}
+	} else {
+		page.GetLimit()
+	}
+	for _, item := range list {
+		rp = append(rp, newFlowResp(item))
+	}
+	return
+}
+
+// CancelFlow cancel running workflows, waiting steps are removed and downstream steps are not enqueued
+func (qu Queue) CancelFlow(uids ...string) (err error) {
+	for _, uid := range uids {
+		err = qu.updateFlow(uid, func(r *flowRun) error {
+			if r.Status != constant.DelayFlowStatusRunning {
+				return errors.Wrap(ErrFlowFinished, uid)
+			}
+			r.Status = constant.DelayFlowStatusCancelled
+			for _, step := range r.Steps {
+				if step.Status != constant.DelayFlowStatusPending && step.Status != constant.DelayFlowStatusRunning {
+					continue
+				}
+				// active task can not be removed, its result is ignored
+				qu.inspector.DeleteTask(qu.ops.name, getFlowUid(r.Uid, step.Name))
+				step.Status = constant.DelayFlowStatusCancelled
+				step.EndAt = time.Now().Unix()
+			}
+			return nil
+		})
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// flowCancelled check run status before step handler
+func (qu Queue) flowCancelled(uid string) bool {
+	v, err := qu.redis.HGet(context.Background(), qu.ops.redisFlowKey, uid).Result()
+	if err != nil {
+		return false
+	}
+	var item flowRun
+	utils.Json2Struct(v, &item)
+	return item.Status == constant.DelayFlowStatusCancelled
+}
+
+// flowStepDone save step result, enqueue downstream steps on success
+func (qu Queue) flowStepDone(ctx context.Context, uid, name, output string, retry int, err error) {
+	maxRetry, _ := asynq.GetMaxRetry(ctx)
+	e := qu.updateFlow(uid, func(r *flowRun) error {
+		step, ok := r.Steps[name]
+		if !ok {
+			return errors.Wrap(ErrFlowNotFound, getFlowUid(uid, name))
+		}
+		if step.Status == constant.DelayFlowStatusCancelled {
+			return nil
+		}
+		step.Retry = retry
+		if err != nil {
+			step.Error = err.Error()
+			// asynq retries the step until max retry
+			if retry < maxRetry {
+				return nil
+			}
+			step.Status = constant.DelayFlowStatusFailed
+			step.EndAt = time.Now().Unix()
+			if r.Status == constant.DelayFlowStatusRunning {
+				r.Status = constant.DelayFlowStatusFailed
+			}
+			return nil
+		}
+		step.Status = constant.DelayFlowStatusSuccess
+		step.Error = ""
+		step.Output = output
+		step.EndAt = time.Now().Unix()
+		if r.Status != constant.DelayFlowStatusRunning {
+			return nil
+		}
+		if e := qu.enqueueFlowSteps(r); e != nil {
+			log.
+				WithContext(ctx).
+				WithFields(map[string]interface{}{
+					"Uid":  uid,
+					"Step": name,
+				}).
+				WithError(e).
+				Warn("enqueue flow step failed")
+		}
+		return nil
+	})
+	if e != nil {
+		log.
+			WithContext(ctx).
+			WithFields(map[string]interface{}{
+				"Uid":  uid,
+				"Step": name,
+			}).
+			WithError(e).
+			Warn("save flow step failed")
+	}
+}
+
+// enqueue pending steps whose depends are all succeeded, run succeeds when all steps succeed(run fails if enqueue failed)
+func (qu Queue) enqueueFlowSteps(r *flowRun) (err error) {
+	finished := true
+	names := make([]string, 0, len(r.Steps))
+	for name := range r.Steps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		step := r.Steps[name]
+		if step.Status != constant.DelayFlowStatusSuccess {
+			finished = false
+		}
+		if step.Status != constant.DelayFlowStatusPending {
+			continue
+		}
+		outputs := make(map[string]string, len(step.Depends))
+		ready := true
+		for _, depend := range step.Depends {
+			if r.Steps[depend].Status != constant.DelayFlowStatusSuccess {
+				ready = false
+				break
+			}
+			outputs[depend] = r.Steps[depend].Output
+		}
+		if !ready {
+			continue
+		}
+		err = qu.Once(
+			WithQueueTaskUuid(getFlowUid(r.Uid, step.Name)),
+			WithQueueTaskName(step.Task),
+			WithQueueTaskPayload(utils.Struct2Json(FlowPayload{
+				Uid:     r.Uid,
+				Step:    step.Name,
+				Input:   r.Input,
+				Outputs: outputs,
+			})),
+			WithQueueTaskMaxRetry(step.MaxRetry),
+			WithQueueTaskTimeout(step.Timeout),
+			WithQueueTaskNow(true),
+		)
+		if err != nil {
+			step.Status = constant.DelayFlowStatusFailed
+			step.Error = err.Error()
+			step.EndAt = time.Now().Unix()
+			r.Status = constant.DelayFlowStatusFailed
+			return
+		}
+		step.Status = constant.DelayFlowStatusRunning
+		step.StartAt = time.Now().Unix()
+	}
+	if finished {
+		r.Status = constant.DelayFlowStatusSuccess
+	}
+	return
+}
+
+// update flow run with lock of the run
+func (qu Queue) updateFlow(uid string, fun func(r *flowRun) error) (err error) {
+	if qu.Error != nil {
+		err = qu.Error
+		return
+	}
+	nxLock := lock.NxLock{
+		Key:        fmt.Sprintf("%s.%s.lock", qu.ops.redisFlowKey, uid),
+		Redis:      qu.redis,
+		Expiration: 10 * time.Second,
+	}
+	for {
+		if nxLock.Lock() {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	defer nxLock.Unlock()
+	ctx := context.Background()
+	var v string
+	v, err = qu.redis.HGet(ctx, qu.ops.redisFlowKey, uid).Result()
+	if err == redis.Nil {
+		err = errors.Wrap(ErrFlowNotFound, uid)
+		return
+	}
+	if err != nil {
+		return
+	}
+	var item flowRun
+	utils.Json2Struct(v, &item)
+	err = fun(&item)
+	if err != nil {
+		return
+	}
+	item.UpdatedAt = time.Now().Unix()
+	_, err = qu.redis.HSet(ctx, qu.ops.redisFlowKey, uid, utils.Struct2Json(item)).Result()
+	return
+}
+
+// remove finished runs which are not updated in run retention
+func (qu Queue) clearFlow() {
+	if qu.ops.runRetention <= 0 {
+		return
+	}
+	ctx := context.Background()
+	m, err := qu.redis.HGetAll(ctx, qu.ops.redisFlowKey).Result()
+	if err != nil {
+		return
+	}
+	before := time.Now().Unix() - int64(qu.ops.runRetention)
+	for uid, v := range m {
+		var item flowRun
+		utils.Json2Struct(v, &item)
+		if item.Status != constant.DelayFlowStatusRunning && item.UpdatedAt < before {
+			qu.redis.HDel(ctx, qu.ops.redisFlowKey, uid)
+		}
+	}
+}
+
+// check step names and depends, the graph must be acyclic
+func checkFlow(flow Workflow) (err error) {
+	if len(flow.Steps) == 0 {
+		err = errors.Wrap(ErrFlowInvalid, "steps are empty")
+		return
+	}
+	indegree := make(map[string]int, len(flow.Steps))
+	for _, step := range flow.Steps {
+		if step.Name == "" {
+			err = errors.Wrap(ErrFlowInvalid, "step name is empty")
+			return
+		}
+		if _, ok := indegree[step.Name]; ok {
+			err = errors.Wrap(ErrFlowInvalid, fmt.Sprintf("step %s is duplicate", step.Name))
+			return
+		}
+		indegree[step.Name] = 0
+	}
+	downstream := make(map[string][]string, len(flow.Steps))
+	for _, step := range flow.Steps {
+		for _, depend := range step.Depends {
+			if _, ok := indegree[depend]; !ok || depend == step.Name {
+				err = errors.Wrap(ErrFlowInvalid, fmt.Sprintf("step %s depends on invalid step %s", step.Name, depend))
+				return
+			}
+			indegree[step.Name]++
+			downstream[depend] = append(downstream[depend], step.Name)
+		}
+	}
+	queue := make([]string, 0, len(flow.Steps))
+	for name, count := range indegree {
+		if count == 0 {
+			queue = append(queue, name)
+		}
+	}
+	visited := 0
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		visited++
+		for _, item := range downstream[name] {
+			indegree[item]--
+			if indegree[item] == 0 {
+				queue = append(queue, item)
+			}
+		}
+	}
+	if visited != len(flow.Steps) {
+		err = errors.Wrap(ErrFlowInvalid, "steps have cycle")
+	}
+	return
+}
+
+func getFlowUid(uid, step string) string {
+	return fmt.Sprintf("%s%s%s", uid, flowSep, step)
+}
+
+func splitFlowUid(id string) (uid, step string, ok bool) {
+	i := strings.Index(id, flowSep)
+	if i == -1 {
+		return
+	}
+	uid = id[:i]
+	step = id[i+len(flowSep):]
+	ok = true
+	return
+}
+
+func newFlowResp(item flowRun) (rp resp.DelayFlow) {
+	rp = resp.DelayFlow{
+		Uid:       item.Uid,
+		Name:      item.Name,
+		Input:     item.Input,
+		Status:    item.Status,
+		Steps:     make([]resp.DelayFlowStep, 0, len(item.Steps)),
+		CreatedAt: carbon.DateTime{Carbon: carbon.CreateFromTimestamp(item.CreatedAt)},
+		UpdatedAt: carbon.DateTime{Carbon: carbon.CreateFromTimestamp(item.UpdatedAt)},
+	}
+	for _, step := range item.Steps {
+		s := resp.DelayFlowStep{
+			Name:    step.Name,
+			Task:    step.Task,
+			Depends: step.Depends,
+			Status:  step.Status,
+			Output:  step.Output,
+			Error:   step.Error,
+			Retry:   step.Retry,
+		}
+		if s.Depends == nil {
+			s.Depends = make([]string, 0)
+		}
+		if step.StartAt > 0 {
+			s.StartAt = carbon.DateTime{Carbon: carbon.CreateFromTimestamp(step.StartAt)}
+		}
+		if step.EndAt > 0 {
+			s.EndAt = carbon.DateTime{Carbon: carbon.CreateFromTimestamp(step.EndAt)}
+		}
+		rp.Steps = append(rp.Steps, s)
+	}
+	sort.Slice(rp.Steps, func(i, j int) bool { return rp.Steps[i].Name < rp.Steps[j].Name })
+	return
+}
@@ -0,0 +1,201 @@
+package fsm
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ennismar/go-helper/pkg/constant"
+	"github.com/ennismar/go-helper/pkg/i18n"
+	"github.com/ennismar/go-helper/pkg/req"
+	"github.com/ennismar/go-helper/pkg/resp"
+	"github.com/ennismar/go-helper/pkg/utils"
+	"github.com/looplab/fsm"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+	"strings"
+)
+
+// ExportMachine export machine definition(submitter settings/levels/roles/users/edit fields) as yaml or json
+func (fs *Fsm) ExportMachine(r req.FsmExportMachine) (rp []byte) {
+	if fs.Error != nil {
+		return
+	}
+	var machine Machine
+	fs.session.
+		Model(&Machine{}).
+		Where("id = ?", r.Id).
+		First(&machine)
+	if machine.Id == constant.Zero {
+		fs.AddError(i18n.E(ErrMachineNotFound))
+		return
+	}
+	def := req.FsmCreateMachine{
+		Category:                   req.NullUint(machine.Category),
+		Name:                       machine.Name,
+		SubmitterName:              machine.SubmitterName,
+		SubmitterEditFields:        machine.SubmitterEditFields,
+		SubmitterConfirm:           req.NullUint(machine.SubmitterConfirm),
+		SubmitterConfirmEditFields: machine.SubmitterConfirmEditFields,
+		Levels:                     make([]req.FsmCreateEvent, 0),
+	}
+	utils.Json2Struct(machine.EventsJson, &def.Levels)
+	var err error
+	switch getDefinitionFormat(r.Format) {
+	case constant.FsmDefinitionFormatJson:
+		rp, err = json.MarshalIndent(def, "", "  ")
+	default:
+		rp, err = yaml.Marshal(def)
+	}
+	fs.AddError(err)
+	return
+}
+
+// DryRunMachine validate machine definition without saving, report fsm transitions, unreachable levels and referenced roles/users
+func (fs *Fsm) DryRunMachine(r req.FsmImportMachine) (rp resp.FsmMachineDryRun) {
+	rp.Transitions = make([]resp.FsmMachineTransition, 0)
+	rp.UnreachableLevels = make([]string, 0)
+	rp.RoleIds = make([]uint, 0)
+	rp.UserIds = make([]uint, 0)
+	rp.MissingRoles = make([]uint, 0)
+	rp.MissingUsers = make([]uint, 0)
+	def := fs.parseDefinition(r)
+	if fs.Error != nil {
+		return
+	}
+	rp.Category = uint(def.Category)
+	rp.Name = def.Name
+	desc, _ := buildEventDesc(def.SubmitterName, uint(def.SubmitterConfirm), def.Levels)
+	if fs.AddError(checkEvent(desc)) != nil {
+		return
+	}
+
+	// transitions of looplab/fsm, approve/refuse events share the same name
+	start := desc[0].Dst
+	f := fsm.NewFSM(start, desc, nil)
+	for _, d := range desc {
+		for _, src := range d.Src {
+			f.SetState(src)
+			if !f.Can(d.Name) {
+				continue
+			}
+			rp.Transitions = append(rp.Transitions, resp.FsmMachineTransition{
+				Src:   src,
+				Event: d.Name,
+				Dst:   d.Dst,
+			})
+		}
+	}
+
+	// walk states from the submitted state
+	reachable := []string{start}
+	for i := 0; i < len(reachable); i++ {
+		for _, item := range rp.Transitions {
+			if item.Src == reachable[i] && !utils.Contains(reachable, item.Dst) {
+				reachable = append(reachable, item.Dst)
+			}
+		}
+	}
+	for _, item := range def.Levels {
+		waiting := false
+		for _, t := range rp.Transitions {
+			if t.Event == fmt.Sprintf("%s %s", item.Name, i18n.T(constant.FsmSuffixWaiting)) && utils.Contains(reachable, t.Src) {
+				waiting = true
+				break
+			}
+		}
+		// guard is always false, the level is always skipped
+		ok, isConst := evalConstGuard(item.Guard)
+		if !waiting || (isConst && !ok) {
+			rp.UnreachableLevels = append(rp.UnreachableLevels, item.Name)
+		}
+		roleIds := append(item.Roles.Uints(), uint(item.EscalateRoleId))
+		userIds := append(item.Users.Uints(), uint(item.EscalateUserId))
+		for _, id := range roleIds {
+			if id > constant.Zero && !utils.ContainsUint(rp.RoleIds, id) {
+				rp.RoleIds = append(rp.RoleIds, id)
+			}
+		}
+		for _, id := range userIds {
+			if id > constant.Zero && !utils.ContainsUint(rp.UserIds, id) {
+				rp.UserIds = append(rp.UserIds, id)
+			}
+		}
+	}
+	return
+}
+
+// ImportMachine create machine by definition, or create a new version if the category exists
+func (fs *Fsm) ImportMachine(r req.FsmImportMachine) (rp Machine) {
+	if fs.Error != nil {
+		return
+	}
+	report := fs.DryRunMachine(r)
+	if fs.Error != nil {
+		return
+	}
+	if r.DryRun {
+		return
+	}
+	if len(report.UnreachableLevels) > 0 {
+		fs.AddError(errors.Wrap(i18n.E(ErrUnreachableLevel), strings.Join(report.UnreachableLevels, ",")))
+		return
+	}
+	def := fs.parseDefinition(r)
+	if fs.Error != nil {
+		return
+	}
+	oldMachine := fs.GetMachineByCategory(uint(def.Category))
+	if oldMachine.Id == constant.Zero {
+		rp = fs.CreateMachine(def)
+		return
+	}
+	rp = fs.UpdateMachineById(oldMachine.Id, req.FsmUpdateMachine{
+		Name:                       &def.Name,
+		SubmitterName:              &def.SubmitterName,
+		SubmitterEditFields:        &def.SubmitterEditFields,
+		SubmitterConfirm:           &def.SubmitterConfirm,
+		SubmitterConfirmEditFields: &def.SubmitterConfirmEditFields,
+		Levels:                     def.Levels,
+	})
+	return
+}
+
+// parse yaml/json definition and check levels
+func (fs *Fsm) parseDefinition(r req.FsmImportMachine) (rp req.FsmCreateMachine) {
+	if fs.Error != nil {
+		return
+	}
+	var err error
+	switch getDefinitionFormat(r.Format) {
+	case constant.FsmDefinitionFormatJson:
+		err = json.Unmarshal([]byte(r.Content), &rp)
+	default:
+		err = yaml.Unmarshal([]byte(r.Content), &rp)
+	}
+	if err != nil {
+		fs.AddError(errors.Wrap(i18n.E(ErrIllegalDefinition), err.Error()))
+		return
+	}
+	if uint(rp.Category) == constant.Zero {
+		fs.AddError(errors.Wrap(i18n.E(ErrIllegalDefinition), "category"))
+		return
+	}
+	if strings.TrimSpace(rp.SubmitterName) == "" {
+		fs.AddError(errors.Wrap(i18n.E(ErrIllegalDefinition), "submitterName"))
+		return
+	}
+	for _, item := range rp.Levels {
+		if strings.TrimSpace(item.Name) == "" {
+			fs.AddError(errors.Wrap(i18n.E(ErrIllegalDefinition), "levels.name"))
+			return
+		}
+	}
+	fs.checkLevels(rp.Levels)
+	return
+}
+
+func getDefinitionFormat(format string) string {
+	if strings.ToLower(strings.TrimSpace(format)) == constant.FsmDefinitionFormatJson {
+		return constant.FsmDefinitionFormatJson
+	}
+	return constant.FsmDefinitionFormatYaml
+}
@@ -0,0 +1,173 @@
+package fsm
+
+import (
+	"github.com/ennismar/go-helper/pkg/constant"
+	"github.com/ennismar/go-helper/pkg/i18n"
+	"github.com/ennismar/go-helper/pkg/req"
+	"github.com/ennismar/go-helper/pkg/resp"
+	"github.com/ennismar/go-helper/pkg/utils"
+	"github.com/golang-module/carbon/v2"
+	"github.com/pkg/errors"
+)
+
+// CreateDelegation user A delegates approval to user B from StartAt to EndAt(optionally only for some categories)
+func (fs *Fsm) CreateDelegation(r req.FsmCreateDelegation) (rp Delegation) {
+	if fs.Error != nil {
+		return
+	}
+	if r.FromUserId == constant.Zero || r.ToUserId == constant.Zero || r.FromUserId == r.ToUserId {
+		fs.AddError(errors.Wrap(i18n.E(ErrParams), "fromUserId/toUserId"))
+		return
+	}
+	if r.StartAt.IsZero() || r.EndAt.IsZero() || !r.EndAt.Gt(r.StartAt.Carbon) {
+		fs.AddError(errors.Wrap(i18n.E(ErrParams), "startAt/endAt"))
+		return
+	}
+	var delegation Delegation
+	utils.Struct2StructByJson(r, &delegation)
+	delegation.StartAt = r.StartAt
+	delegation.EndAt = r.EndAt
+	fs.session.Create(&delegation)
+	rp = delegation
+	return
+}
+
+// DeleteDelegationByIds delete delegations, pending logs will go back to the delegator immediately
+func (fs *Fsm) DeleteDelegationByIds(ids []uint) {
+	if fs.Error != nil {
+		return
+	}
+	if len(ids) == 0 {
+		return
+	}
+	fs.session.
+		Where("id IN (?)", ids).
+		Delete(&Delegation{})
+	return
+}
+
+// FindDelegation find delegations
+func (fs *Fsm) FindDelegation(r *req.FsmDelegation) (rp []resp.FsmDelegation) {
+	rp = make([]resp.FsmDelegation, 0)
+	if fs.Error != nil {
+		return
+	}
+	list := make([]Delegation, 0)
+	q := fs.session.Model(&Delegation{})
+	if r.FromUserId > constant.Zero {
+		q.Where("from_user_id = ?", r.FromUserId)
+	}
+	if r.ToUserId > constant.Zero {
+		q.Where("to_user_id = ?", r.ToUserId)
+	}
+	if r.Active {
+		now := carbon.Now().ToDateTimeString()
+		q.
+			Where("start_at <= ?", now).
+			Where("end_at >= ?", now)
+	}
+	page := &r.Page
+	countCache := false
+	if page.CountCache != nil {
+		countCache = *page.CountCache
+	}
+	if !page.NoPagination {
+		if !page.SkipCount {
+			q.Count(&page.Total)
+		}
+		if page.Total > 0 || page.SkipCount {
+			limit, offset := page.GetLimit()
+			q.Limit(limit).Offset(offset).Find(&list)
+		}
+	} else {
+		// no pagination
+		q.Find(&list)
+		page.Total = int64(len(list))
+		page.GetLimit()
+	}
+	page.CountCache = &countCache
+	utils.Struct2StructByJson(list, &rp)
+	return
+}
+
+// find active delegations of the delegate user
+func (fs *Fsm) findActiveDelegation(toUserId uint) (rp []Delegation) {
+	rp = make([]Delegation, 0)
+	if toUserId == constant.Zero {
+		return
+	}
+	now := carbon.Now().ToDateTimeString()
+	fs.session.
+		Model(&Delegation{}).
+		Where("to_user_id = ?", toUserId).
+		Where("start_at <= ?", now).
+		Where("end_at >= ?", now).
+		Find(&rp)
+	return
+}
+
+// find the delegation which allows the delegate user to approve the log
+func (fs *Fsm) findDelegator(toUserId uint, l Log, roles, users []uint) (rp Delegation) {
+	// submitter resubmit/confirm can not be delegated
+	if l.Resubmit == constant.One || l.Confirm == constant.One {
+		return
+	}
+	list := fs.findActiveDelegation(toUserId)
+	for _, item := range list {
+		if !item.matchCategory(l.Category) {
+			continue
+		}
+		if utils.ContainsUint(users, item.FromUserId) || (item.FromRoleId > constant.Zero && utils.ContainsUint(roles, item.FromRoleId)) {
+			rp = item
+			return
+		}
+	}
+	return
+}
+
+// find pending log ids delegated to the user, key is log id, value is delegation
+func (fs *Fsm) findDelegatedLogIds(toUserId uint) (rp map[uint]Delegation) {
+	rp = make(map[uint]Delegation)
+	list := fs.findActiveDelegation(toUserId)
+	for _, item := range list {
+		ids := make([]uint, 0)
+		fs.session.
+			Model(&LogApprovalUserRelation{}).
+			Where("user_id = ?", item.FromUserId).
+			Pluck("log_id", &ids)
+		if item.FromRoleId > constant.Zero {
+			roleLogIds := make([]uint, 0)
+			fs.session.
+				Model(&LogApprovalRoleRelation{}).
+				Where("role_id = ?", item.FromRoleId).
+				Pluck("log_id", &roleLogIds)
+			ids = append(ids, roleLogIds...)
+		}
+		if len(ids) == 0 {
+			continue
+		}
+		q := fs.session.
+			Model(&Log{}).
+			Where("id IN (?)", ids).
+			Where("approved = ?", constant.FsmLogStatusWaiting).
+			Where("resubmit = ?", constant.Zero).
+			Where("confirm = ?", constant.Zero)
+		categories := item.Categories.Uints()
+		if len(categories) > 0 {
+			q.Where("category IN (?)", categories)
+		}
+		logIds := make([]uint, 0)
+		q.Pluck("id", &logIds)
+		for _, id := range logIds {
+			if _, ok := rp[id]; !ok {
+				rp[id] = item
+			}
+		}
+	}
+	return
+}
+
+func (d Delegation) matchCategory(category uint) bool {
+	categories := d.Categories.Uints()
+	return len(categories) == 0 || utils.ContainsUint(categories, category)
+}
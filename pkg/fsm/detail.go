@@ -0,0 +1,90 @@
+package fsm
+
+import (
+	"github.com/ennismar/go-helper/pkg/constant"
+	"github.com/ennismar/go-helper/pkg/i18n"
+	"github.com/ennismar/go-helper/pkg/resp"
+	"github.com/ennismar/go-helper/pkg/utils"
+	"github.com/pkg/errors"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// compare log detail json, return per-field diff sorted by field
+func diffDetail(oldDetail, newDetail string) (rp []resp.FsmLogDetailDiff) {
+	rp = make([]resp.FsmLogDetailDiff, 0)
+	if strings.TrimSpace(newDetail) == "" || oldDetail == newDetail {
+		return
+	}
+	m1 := make(map[string]interface{})
+	m2 := make(map[string]interface{})
+	if strings.TrimSpace(oldDetail) != "" {
+		utils.Json2Struct(oldDetail, &m1)
+	}
+	utils.Json2Struct(newDetail, &m2)
+	// simple fields changed
+	update := make(map[string]interface{})
+	utils.CompareDiff(m1, m2, &update)
+	fields := make([]string, 0)
+	for k := range update {
+		fields = append(fields, k)
+	}
+	for k, v2 := range m2 {
+		v1, ok := m1[k]
+		if utils.Contains(fields, k) {
+			continue
+		}
+		// new field or complex structure(CompareDiff skips it)
+		if !ok || !reflect.DeepEqual(v1, v2) {
+			fields = append(fields, k)
+		}
+	}
+	for k := range m1 {
+		// removed field
+		if _, ok := m2[k]; !ok {
+			fields = append(fields, k)
+		}
+	}
+	sort.Strings(fields)
+	for _, k := range fields {
+		rp = append(rp, resp.FsmLogDetailDiff{
+			Field: k,
+			Old:   m1[k],
+			New:   m2[k],
+		})
+	}
+	return
+}
+
+// check changed fields are allowed by level edit settings(same rule as CheckEditLogDetailPermission)
+func checkEditFields(edit uint, editFields string, diff []resp.FsmLogDetailDiff) (err error) {
+	if len(diff) == 0 {
+		return
+	}
+	if edit != constant.One {
+		err = errors.Wrap(i18n.E(ErrNoPermissionEdit), diff[0].Field)
+		return
+	}
+	// empty edit fields means all fields are allowed
+	if strings.TrimSpace(editFields) == "" {
+		return
+	}
+	fields := strings.Split(utils.SnakeCase(editFields), ",")
+	for _, item := range diff {
+		if !utils.Contains(fields, utils.SnakeCase(item.Field)) {
+			err = errors.Wrap(i18n.E(ErrNoPermissionEdit), item.Field)
+			return
+		}
+	}
+	return
+}
+
+// get the detail diff saved by approver
+func getDetailDiff(l Log) (rp []resp.FsmLogDetailDiff) {
+	rp = make([]resp.FsmLogDetailDiff, 0)
+	if l.DetailDiff != "" {
+		utils.Json2Struct(l.DetailDiff, &rp)
+	}
+	return
+}
@@ -15,4 +15,12 @@ var (
 	ErrStartedCannotCancel      = "go-helper.fsm.error.started-cannot-cancel"
 	ErrMachineNotFound          = "go-helper.fsm.error.machine-not-found"
 	ErrDuplicateMachineCategory = "go-helper.fsm.error.duplicate-machine-category"
+	ErrRepeatApprove            = "go-helper.fsm.error.repeat-approve"
+	ErrIllegalGuard             = "go-helper.fsm.error.illegal-guard"
+	ErrNoMatchedLevel           = "go-helper.fsm.error.no-matched-level"
+	ErrQueueEmpty               = "go-helper.fsm.error.queue"
+	ErrMachineVersionNotFound   = "go-helper.fsm.error.machine-version-not-found"
+	ErrIllegalDefinition        = "go-helper.fsm.error.illegal-definition"
+	ErrUnreachableLevel         = "go-helper.fsm.error.unreachable-level"
+	ErrExchangeEmpty            = "go-helper.fsm.error.exchange"
 )
@@ -20,7 +20,9 @@ import (
 type Fsm struct {
 	ops     Options
 	session *gorm.DB
-	Error   error
+	// sla tasks waiting for commit
+	sla   []slaTask
+	Error error
 }
 
 // Migrate mysql DDL migrate rollback is not supported, Migrate before New
@@ -39,7 +41,34 @@ func Migrate(options ...func(*Options)) (err error) {
 		new(EventItem),
 		new(Log),
 		new(LogApprovalUserRelation),
+		new(LogVote),
+		new(LogAction),
+		new(Delegation),
+		new(MachineVersion),
+		new(Outbox),
 	)
+	if err != nil {
+		return
+	}
+	// events are versioned, (machine_id, sort) is no longer unique
+	if session.Migrator().HasIndex(&Event{}, "idx_m_id_sort") {
+		err = session.Migrator().DropIndex(&Event{}, "idx_m_id_sort")
+		if err != nil {
+			return
+		}
+	}
+	// save current version of the machines created before versioning
+	machines := make([]Machine, 0)
+	session.Find(&machines)
+	for _, item := range machines {
+		fs.saveMachineVersion(item)
+		session.
+			Model(&Log{}).
+			Where("category = ?", item.Category).
+			Where("machine_id = ?", constant.Zero).
+			Update("machine_id", item.Id)
+	}
+	err = fs.Error
 	return
 }
 
@@ -66,18 +95,7 @@ func (fs *Fsm) DeleteMachineByIds(ids []uint) {
 	if len(ids) == 0 {
 		return
 	}
-	machines := make([]Machine, 0)
-	fs.session.
-		Model(&Machine{}).
-		Where("id IN (?)", ids).
-		Find(&machines)
-	categories := make([]uint, 0)
-	for _, item := range machines {
-		categories = append(categories, item.Category)
-	}
-	for _, item := range categories {
-		fs.CancelLog(item)
-	}
+	// in-flight logs still finish on their machine version, new log can not be submitted
 	fs.session.
 		Where("id IN (?)", ids).
 		Delete(&Machine{})
@@ -100,18 +118,24 @@ func (fs *Fsm) CreateMachine(r req.FsmCreateMachine) (rp Machine) {
 		fs.AddError(i18n.E(ErrDuplicateMachineCategory))
 		return
 	}
+	fs.checkLevels(r.Levels)
+	if fs.Error != nil {
+		return
+	}
 	// save json for query
 	machine.EventsJson = utils.Struct2Json(r.Levels)
+	machine.Version = constant.One
 	fs.session.Create(&machine)
 	// batch fsm event
-	fs.batchCreateEvent(machine.Id, r.Levels)
+	fs.batchCreateEvent(machine.Id, machine.Version, r.Levels)
 	if fs.Error != nil {
 		return
 	}
-	fs.findEventDesc(machine.Id)
+	fs.findEventDesc(machine.Id, machine.Version)
 	if fs.Error != nil {
 		return
 	}
+	fs.saveMachineVersion(machine)
 	rp = machine
 	return
 }
@@ -125,34 +149,50 @@ func (fs *Fsm) UpdateMachineById(id uint, r req.FsmUpdateMachine) (rp Machine) {
 		Model(&Machine{}).
 		Where("id = ?", id).
 		First(&oldMachine)
-	// cancel log when machine config change
-	fs.CancelLog(oldMachine.Category)
-	if fs.Error != nil {
+	if oldMachine.Id == constant.Zero {
+		fs.AddError(i18n.E(ErrMachineNotFound))
 		return
 	}
 	levels := make([]req.FsmCreateEvent, len(r.Levels))
 	copy(levels, r.Levels)
 	r.Levels = make([]req.FsmCreateEvent, 0)
+	fs.checkLevels(levels)
+	if fs.Error != nil {
+		return
+	}
 	eventsJson := utils.Struct2Json(levels)
 	m := make(map[string]interface{}, 0)
 	utils.CompareDiff2SnakeKey(oldMachine, r, &m)
 	if oldMachine.EventsJson != eventsJson {
 		m["events_json"] = eventsJson
 	}
+	if len(m) == 0 {
+		// nothing changed, keep current version
+		rp = oldMachine
+		return
+	}
+	// create a new version, in-flight logs still finish on their old version
+	m["version"] = oldMachine.Version + 1
 	fs.session.
 		Model(&Machine{}).
 		Where("id = ?", id).
 		Updates(&m)
+	var machine Machine
+	fs.session.
+		Model(&Machine{}).
+		Where("id = ?", id).
+		First(&machine)
 	// batch fsm event
-	fs.batchCreateEvent(oldMachine.Id, levels)
+	fs.batchCreateEvent(machine.Id, machine.Version, levels)
 	if fs.Error != nil {
 		return
 	}
-	fs.findEventDesc(oldMachine.Id)
+	fs.findEventDesc(machine.Id, machine.Version)
 	if fs.Error != nil {
 		return
 	}
-	rp = oldMachine
+	fs.saveMachineVersion(machine)
+	rp = machine
 	return
 }
 
@@ -180,7 +220,7 @@ func (fs *Fsm) SubmitLog(r req.FsmCreateLog) (rp []EventItem) {
 		fs.AddError(i18n.E(ErrRepeatSubmit))
 		return
 	}
-	startEvent := fs.getStartEvent(machine.Id)
+	startEvent := fs.getStartEvent(machine.Id, machine.Version)
 	if fs.Error != nil {
 		return
 	}
@@ -189,11 +229,21 @@ func (fs *Fsm) SubmitLog(r req.FsmCreateLog) (rp []EventItem) {
 	var l Log
 	l.Category = uint(r.Category)
 	l.Uuid = r.Uuid
-	nextEvent := fs.getNextEvent(machine.Id, startEvent.Level)
+	l.MachineId = machine.Id
+	l.Version = machine.Version
+	nextEvent := fs.getNextEvent(machine.Id, machine.Version, startEvent.Level, r.DetailJson)
 	if fs.Error != nil {
 		return
 	}
+	if nextEvent.Id == constant.Zero {
+		fs.AddError(i18n.E(ErrNoMatchedLevel))
+		return
+	}
 	l.ProgressId = startEvent.DstId
+	if nextEvent.Level != startEvent.Level+1 {
+		// some levels are skipped by guard
+		l.ProgressId = getSrcItem(nextEvent, false).Id
+	}
 	l.CanApprovalRoles = nextEvent.Roles
 	l.CanApprovalUsers = nextEvent.Users
 	l.SubmitterRoleId = r.SubmitterRoleId
@@ -201,9 +251,21 @@ func (fs *Fsm) SubmitLog(r req.FsmCreateLog) (rp []EventItem) {
 	l.PrevDetail = startEvent.Dst.Name
 	l.Detail = nextEvent.Name.Name
 	l.Remark = r.Remark
+	l.DetailJson = r.DetailJson
 	l.CurrentEventId = startEvent.Id
 	l.NextEventId = nextEvent.Id
 	fs.session.Create(&l)
+	fs.scheduleSla(l.Id, nextEvent)
+	fs.addOutbox(resp.FsmOutboxEvent{
+		Event:          constant.FsmOutboxEventSubmit,
+		Category:       l.Category,
+		Uuid:           l.Uuid,
+		LogId:          l.Id,
+		Version:        l.Version,
+		ApprovalRoleId: r.SubmitterRoleId,
+		ApprovalUserId: r.SubmitterUserId,
+		Detail:         l.Detail,
+	})
 
 	rp = append(rp, []EventItem{
 		startEvent.Dst,
@@ -214,10 +276,6 @@ func (fs *Fsm) SubmitLog(r req.FsmCreateLog) (rp []EventItem) {
 
 // ApproveLog start approve log
 func (fs *Fsm) ApproveLog(r req.FsmApproveLog) (rp resp.FsmApprovalLog) {
-	if fs.Error != nil {
-		return
-	}
-	machine := fs.GetMachineByCategory(uint(r.Category))
 	if fs.Error != nil {
 		return
 	}
@@ -237,6 +295,32 @@ func (fs *Fsm) ApproveLog(r req.FsmApproveLog) (rp resp.FsmApprovalLog) {
 	if fs.Error != nil {
 		return
 	}
+	// the log always finish on the machine version it was submitted with
+	machine := fs.getLogMachine(oldLog)
+	if machine.Id == constant.Zero {
+		fs.AddError(i18n.E(ErrMachineNotFound))
+		return
+	}
+
+	// only the fields allowed by current level can be changed
+	if r.DetailJson != "" && approved != constant.FsmLogStatusCancelled {
+		diff := diffDetail(oldLog.DetailJson, r.DetailJson)
+		if fs.AddError(checkEditFields(oldLog.NextEvent.Edit, oldLog.NextEvent.EditFields, diff)) != nil {
+			return
+		}
+	}
+
+	// countersign/quorum level, wait for other approvers
+	if oldLog.NextEvent.Policy != constant.FsmApprovalPolicyAny && (approved == constant.FsmLogStatusApproved || approved == constant.FsmLogStatusRefused) {
+		pass := fs.vote(oldLog, r)
+		if fs.Error != nil {
+			return
+		}
+		if !pass {
+			rp.Pending = constant.One
+			return
+		}
+	}
 
 	// submitter cancel
 	if approved == constant.FsmLogStatusCancelled {
@@ -252,10 +336,32 @@ func (fs *Fsm) ApproveLog(r req.FsmApproveLog) (rp resp.FsmApprovalLog) {
 			Model(&Log{}).
 			Where("id = ?", oldLog.Id).
 			Updates(&m)
+		fs.addOutbox(resp.FsmOutboxEvent{
+			Event:           constant.FsmOutboxEventCancel,
+			Category:        oldLog.Category,
+			Uuid:            oldLog.Uuid,
+			LogId:           oldLog.Id,
+			Version:         oldLog.Version,
+			ApprovalRoleId:  r.ApprovalRoleId,
+			ApprovalUserId:  r.ApprovalUserId,
+			ApprovalOpinion: r.ApprovalOpinion,
+			Detail:          i18n.T(constant.FsmMsgSubmitterCancel),
+		})
 		return
 	}
 
-	desc := fs.findEventDesc(machine.Id)
+	rp = fs.approve(machine, oldLog, r)
+	return
+}
+
+// approve move the log to next/prev level, the permission has been checked
+func (fs *Fsm) approve(machine Machine, oldLog Log, r req.FsmApproveLog) (rp resp.FsmApprovalLog) {
+	approved := uint(r.Approved)
+	rp = resp.FsmApprovalLog{
+		Uuid:     r.Uuid,
+		Category: uint(r.Category),
+	}
+	desc := fs.findEventDesc(machine.Id, oldLog.Version)
 	if fs.Error != nil {
 		return
 	}
@@ -288,7 +394,7 @@ func (fs *Fsm) ApproveLog(r req.FsmApproveLog) (rp resp.FsmApprovalLog) {
 	}
 	nextName := getNextItemName(approved, eventName)
 	f.SetState(nextName)
-	event := fs.getEvent(machine.Id, eventName)
+	event := fs.getEvent(machine.Id, oldLog.Version, eventName)
 	if fs.Error != nil {
 		return
 	}
@@ -300,22 +406,30 @@ func (fs *Fsm) ApproveLog(r req.FsmApproveLog) (rp resp.FsmApprovalLog) {
 	var newLog Log
 	newLog.Category = uint(r.Category)
 	newLog.Uuid = r.Uuid
+	newLog.MachineId = machine.Id
+	newLog.Version = oldLog.Version
 	newLog.SubmitterRoleId = oldLog.SubmitterRoleId
 	newLog.SubmitterUserId = oldLog.SubmitterUserId
 	newLog.PrevDetail = nextName
 	newLog.Remark = oldLog.Remark
+	newLog.DetailJson = oldLog.DetailJson
+	if r.DetailJson != "" {
+		newLog.DetailJson = r.DetailJson
+	}
 	newLog.CurrentEventId = event.Id
+	// bind next approver(the next/prev level whose guard matches detail)
+	var nextEvent Event
 	if len(f.AvailableTransitions()) != 0 {
-		// bind next approver
-		var nextEvent Event
 		if approved == constant.FsmLogStatusApproved {
-			nextEvent = fs.getNextEvent(machine.Id, event.Level)
+			nextEvent = fs.getNextEvent(machine.Id, oldLog.Version, event.Level, newLog.DetailJson)
 		} else {
-			nextEvent = fs.getPrevEvent(machine.Id, event.Level)
+			nextEvent = fs.getPrevEvent(machine.Id, oldLog.Version, event.Level, newLog.DetailJson)
 		}
 		if fs.Error != nil {
 			return
 		}
+	}
+	if nextEvent.Id > constant.Zero {
 		// no users/roles, maybe submitter resubmit/confirm
 		noUser := false
 		if len(nextEvent.Roles) == 0 && len(nextEvent.Users) == 0 {
@@ -327,6 +441,11 @@ func (fs *Fsm) ApproveLog(r req.FsmApproveLog) (rp resp.FsmApprovalLog) {
 			}
 		}
 		newLog.ProgressId = progressItem.Id
+		if (approved == constant.FsmLogStatusApproved && nextEvent.Level != event.Level+1) ||
+			(approved == constant.FsmLogStatusRefused && nextEvent.Level+1 != event.Level) {
+			// some levels are skipped by guard
+			newLog.ProgressId = getSrcItem(nextEvent, approved == constant.FsmLogStatusRefused).Id
+		}
 		newLog.NextEventId = nextEvent.Id
 		if rp.Resubmit == constant.One {
 			newLog.Resubmit = constant.One
@@ -356,6 +475,9 @@ func (fs *Fsm) ApproveLog(r req.FsmApproveLog) (rp resp.FsmApprovalLog) {
 		newLog.Detail = i18n.T(constant.FsmMsgEnded)
 	}
 	fs.session.Create(&newLog)
+	if nextEvent.Id > constant.Zero {
+		fs.scheduleSla(newLog.Id, nextEvent)
+	}
 	m := make(map[string]interface{}, 0)
 	m["approved"] = constant.FsmLogStatusApproved
 	if approved == constant.FsmLogStatusRefused {
@@ -364,11 +486,39 @@ func (fs *Fsm) ApproveLog(r req.FsmApproveLog) (rp resp.FsmApprovalLog) {
 	m["approval_role_id"] = r.ApprovalRoleId
 	m["approval_user_id"] = r.ApprovalUserId
 	m["approval_opinion"] = r.ApprovalOpinion
+	m["delegator_role_id"] = oldLog.DelegatorRoleId
+	m["delegator_user_id"] = oldLog.DelegatorUserId
+	// who changed what at this level
+	if diff := diffDetail(oldLog.DetailJson, r.DetailJson); len(diff) > 0 {
+		m["detail_diff"] = utils.Struct2Json(diff)
+	}
 	// update oldLog approved
 	fs.session.
 		Model(&Log{}).
 		Where("id = ?", oldLog.Id).
 		Updates(&m)
+	outboxEvent := constant.FsmOutboxEventApprove
+	if oldLog.Resubmit == constant.One {
+		outboxEvent = constant.FsmOutboxEventResubmit
+	} else if oldLog.Confirm == constant.One {
+		outboxEvent = constant.FsmOutboxEventConfirm
+	} else if approved == constant.FsmLogStatusRefused {
+		outboxEvent = constant.FsmOutboxEventReject
+	}
+	fs.addOutbox(resp.FsmOutboxEvent{
+		Event:           outboxEvent,
+		Category:        oldLog.Category,
+		Uuid:            oldLog.Uuid,
+		LogId:           oldLog.Id,
+		Version:         oldLog.Version,
+		ApprovalRoleId:  r.ApprovalRoleId,
+		ApprovalUserId:  r.ApprovalUserId,
+		ApprovalOpinion: r.ApprovalOpinion,
+		Detail:          newLog.Detail,
+		Resubmit:        rp.Resubmit,
+		Confirm:         rp.Confirm,
+		End:             rp.End,
+	})
 	// status transition
 	if fs.ops.transition == nil {
 		log.WithContext(fs.ops.ctx).Warn("%s", i18n.T(ErrTransitionEmpty))
@@ -402,6 +552,16 @@ func (fs *Fsm) CancelLog(category uint) {
 		})
 	}
 	q.Updates(&m)
+	for _, item := range oldLogs {
+		fs.addOutbox(resp.FsmOutboxEvent{
+			Event:    constant.FsmOutboxEventCancel,
+			Category: item.Category,
+			Uuid:     item.Uuid,
+			LogId:    item.Id,
+			Version:  item.Version,
+			Detail:   i18n.T(constant.FsmMsgConfigChanged),
+		})
+	}
 	if fs.ops.transition == nil {
 		log.WithContext(fs.ops.ctx).Warn("%s", i18n.T(ErrTransitionEmpty))
 		return
@@ -444,6 +604,18 @@ func (fs *Fsm) CancelLogByUuids(r req.FsmCancelLog) {
 		})
 	}
 	q.Updates(&m)
+	for _, item := range oldLogs {
+		fs.addOutbox(resp.FsmOutboxEvent{
+			Event:          constant.FsmOutboxEventCancel,
+			Category:       item.Category,
+			Uuid:           item.Uuid,
+			LogId:          item.Id,
+			Version:        item.Version,
+			ApprovalRoleId: r.ApprovalRoleId,
+			ApprovalUserId: r.ApprovalUserId,
+			Detail:         i18n.T(constant.FsmMsgManualCancel),
+		})
+	}
 	// status transition
 	if fs.ops.transition == nil {
 		log.WithContext(fs.ops.ctx).Warn("%s", i18n.T(ErrTransitionEmpty))
@@ -493,8 +665,14 @@ func (fs *Fsm) CheckLogPermission(r req.FsmPermissionLog) (rp Log) {
 		users = append(users, user.Id)
 	}
 	if !utils.Contains(roles, r.ApprovalRoleId) && !utils.Contains(users, r.ApprovalUserId) {
-		fs.AddError(i18n.E(ErrNoPermissionApprove))
-		return
+		// approve on behalf of the delegator
+		delegation := fs.findDelegator(r.ApprovalUserId, last, roles, users)
+		if delegation.Id == constant.Zero {
+			fs.AddError(i18n.E(ErrNoPermissionApprove))
+			return
+		}
+		last.DelegatorRoleId = delegation.FromRoleId
+		last.DelegatorUserId = delegation.FromUserId
 	}
 	rp = last
 	return
@@ -528,15 +706,17 @@ func (fs *Fsm) CheckEditLogDetailPermission(r req.FsmCheckEditLogDetailPermissio
 	edit := false
 	editFields := ""
 	if submitter || confirm {
-		machine := fs.GetMachineByCategory(uint(r.Category))
-		if fs.Error != nil {
+		machine := fs.getLogMachine(last)
+		if machine.Id == constant.Zero {
+			fs.AddError(i18n.E(ErrMachineNotFound))
 			return
 		}
+		version := fs.getMachineVersion(machine, last.Version)
 		edit = true
 		if submitter {
-			editFields = machine.SubmitterEditFields
+			editFields = version.SubmitterEditFields
 		} else {
-			editFields = machine.SubmitterConfirmEditFields
+			editFields = version.SubmitterConfirmEditFields
 		}
 	} else {
 		userIds := make([]uint, 0)
@@ -561,9 +741,15 @@ func (fs *Fsm) CheckEditLogDetailPermission(r req.FsmCheckEditLogDetailPermissio
 		fs.AddError(i18n.E(ErrNoPermissionEdit))
 		return
 	}
-	// split permission fields
-	fields := strings.Split(utils.SnakeCase(editFields), ",")
-	if len(fields) > 0 {
+	// fields changed by new detail json need permission too
+	if r.DetailJson != "" {
+		for _, item := range diffDetail(last.DetailJson, r.DetailJson) {
+			r.Fields = append(r.Fields, item.Field)
+		}
+	}
+	// split permission fields, empty edit fields means all fields are allowed
+	if strings.TrimSpace(editFields) != "" {
+		fields := strings.Split(utils.SnakeCase(editFields), ",")
 		for _, f := range r.Fields {
 			if !utils.Contains(fields, utils.SnakeCase(f)) {
 				fs.AddError(errors.Wrap(i18n.E(ErrNoPermissionEdit), f))
@@ -647,6 +833,8 @@ func (fs *Fsm) FindLog(r req.FsmLog) (rp []Log) {
 		Preload("NextEvent.Name").
 		Preload("CanApprovalRoles").
 		Preload("CanApprovalUsers").
+		Preload("Votes").
+		Preload("Actions").
 		Where("category = ?", r.Category).
 		Where("uuid = ?", r.Uuid).
 		Find(&rp)
@@ -669,17 +857,23 @@ func (fs *Fsm) FindLogTrack(logs []Log) (rp []resp.FsmLogTrack) {
 		prevApproved := constant.FsmLogStatusWaiting
 		prevCancel := constant.Zero
 		prevOpinion := ""
+		prevVotes := make([]resp.FsmLogVote, 0)
+		prevActions := make([]resp.FsmLogAction, 0)
+		var prev Log
 		end := constant.Zero
 		cancel := constant.Zero
 		if item.Approved == constant.FsmLogStatusCancelled {
 			cancel = constant.One
 		}
 		if i > 0 {
+			prev = logs[i-1]
 			prevApproved = logs[i-1].Approved
 			if logs[i-1].Approved == constant.FsmLogStatusCancelled {
 				prevCancel = constant.One
 			}
 			prevOpinion = logs[i-1].ApprovalOpinion
+			utils.Struct2StructByJson(logs[i-1].Votes, &prevVotes)
+			utils.Struct2StructByJson(logs[i-1].Actions, &prevActions)
 		}
 		if i == l-1 && item.NextEventId == constant.Zero {
 			end = constant.One
@@ -694,16 +888,29 @@ func (fs *Fsm) FindLogTrack(logs []Log) (rp []resp.FsmLogTrack) {
 				Opinion: prevOpinion,
 				Status:  prevApproved,
 				Cancel:  prevCancel,
+				Votes:   prevVotes,
+				Actions: prevActions,
+				Changes: getDetailDiff(prev),
+				// acting approver and original approver(approved by delegate)
+				ApprovalRoleId:  prev.ApprovalRoleId,
+				ApprovalUserId:  prev.ApprovalUserId,
+				DelegatorRoleId: prev.DelegatorRoleId,
+				DelegatorUserId: prev.DelegatorUserId,
 			}, resp.FsmLogTrack{
 				Time: resp.Time{
 					CreatedAt: item.CreatedAt,
 					UpdatedAt: item.UpdatedAt,
 				},
-				Name:    item.Detail,
-				Opinion: item.ApprovalOpinion,
-				Status:  item.Approved,
-				End:     end,
-				Cancel:  cancel,
+				Name:            item.Detail,
+				Opinion:         item.ApprovalOpinion,
+				Status:          item.Approved,
+				End:             end,
+				Cancel:          cancel,
+				ApprovalRoleId:  item.ApprovalRoleId,
+				ApprovalUserId:  item.ApprovalUserId,
+				DelegatorRoleId: item.DelegatorRoleId,
+				DelegatorUserId: item.DelegatorUserId,
+				Changes:         getDetailDiff(item),
 			})
 		} else {
 			rp = append(rp, resp.FsmLogTrack{
@@ -716,14 +923,29 @@ func (fs *Fsm) FindLogTrack(logs []Log) (rp []resp.FsmLogTrack) {
 				Status:  prevApproved,
 				End:     end,
 				Cancel:  cancel,
+				Votes:   prevVotes,
+				Actions: prevActions,
+				Changes: getDetailDiff(prev),
+				// acting approver and original approver(approved by delegate)
+				ApprovalRoleId:  prev.ApprovalRoleId,
+				ApprovalUserId:  prev.ApprovalUserId,
+				DelegatorRoleId: prev.DelegatorRoleId,
+				DelegatorUserId: prev.DelegatorUserId,
 			})
 		}
 		if i == l-1 && item.Approved == constant.FsmLogStatusWaiting {
-			rp = append(rp, resp.FsmLogTrack{
+			track := resp.FsmLogTrack{
 				Name:     logs[i].Detail,
 				Resubmit: item.Resubmit,
 				Confirm:  item.Confirm,
-			})
+			}
+			// show who has and hasn't voted yet
+			pendingRoles, pendingUsers := getPendingApprovers(item)
+			utils.Struct2StructByJson(item.Votes, &track.Votes)
+			utils.Struct2StructByJson(item.Actions, &track.Actions)
+			utils.Struct2StructByJson(pendingRoles, &track.PendingRoles)
+			utils.Struct2StructByJson(pendingUsers, &track.PendingUsers)
+			rp = append(rp, track)
 		}
 	}
 	return
@@ -747,15 +969,31 @@ func (fs *Fsm) FindPendingLogByApprover(r *req.FsmPendingLog) (rp []resp.FsmAppr
 		Model(&LogApprovalRoleRelation{}).
 		Where("role_id = ?", r.ApprovalRoleId).
 		Pluck("log_id", &logIds2)
+	// get voted logs(countersign/quorum level)
+	votedIds := make([]uint, 0)
+	fs.session.
+		Model(&LogVote{}).
+		Scopes(voterScope(r.ApprovalRoleId, r.ApprovalUserId)).
+		Pluck("log_id", &votedIds)
+	// get delegated logs
+	delegated := fs.findDelegatedLogIds(r.ApprovalUserId)
 	list := make([]Log, 0)
 	ids := append(logIds1, logIds2...)
+	for id := range delegated {
+		ids = append(ids, id)
+	}
 	if len(ids) > 0 {
 		q := fs.session.
 			Model(&Log{}).
 			Preload("CanApprovalRoles").
 			Preload("CanApprovalUsers").
+			Preload("NextEvent").
+			Preload("Votes").
 			Where("approved = ?", constant.FsmLogStatusWaiting).
 			Where("id IN (?)", ids)
+		if len(votedIds) > 0 {
+			q.Where("id NOT IN (?)", votedIds)
+		}
 		if uint(r.Category) > constant.Zero {
 			q.Where("category = ?", r.Category)
 		}
@@ -781,6 +1019,16 @@ func (fs *Fsm) FindPendingLogByApprover(r *req.FsmPendingLog) (rp []resp.FsmAppr
 		page.CountCache = &countCache
 	}
 	utils.Struct2StructByJson(list, &rp)
+	for i, item := range list {
+		rp[i].Policy = item.NextEvent.Policy
+		rp[i].Quorum = item.NextEvent.Quorum
+		pendingRoles, pendingUsers := getPendingApprovers(item)
+		utils.Struct2StructByJson(pendingRoles, &rp[i].PendingRoles)
+		utils.Struct2StructByJson(pendingUsers, &rp[i].PendingUsers)
+		if d, ok := delegated[item.Id]; ok && !utils.ContainsUint(logIds1, item.Id) && !utils.ContainsUint(logIds2, item.Id) {
+			rp[i].DelegatorUserId = d.FromUserId
+		}
+	}
 	return
 }
 
@@ -797,6 +1045,7 @@ func (fs *Fsm) getLastPendingLog(r req.FsmLog) (rp Log) {
 		Preload("Progress").
 		Preload("CurrentEvent").
 		Preload("NextEvent").
+		Preload("Votes").
 		Where("category = ?", r.Category).
 		Where("uuid = ?", r.Uuid).
 		Where("approved = ?", constant.FsmLogStatusWaiting).
@@ -804,7 +1053,7 @@ func (fs *Fsm) getLastPendingLog(r req.FsmLog) (rp Log) {
 	return
 }
 
-func (fs *Fsm) getEvent(machineId uint, name string) (rp Event) {
+func (fs *Fsm) getEvent(machineId, version uint, name string) (rp Event) {
 	if fs.Error != nil {
 		return
 	}
@@ -813,6 +1062,7 @@ func (fs *Fsm) getEvent(machineId uint, name string) (rp Event) {
 		Preload("Name").
 		Preload("Dst").
 		Where("machine_id = ?", machineId).
+		Where("version = ?", version).
 		Find(&events)
 	for _, event := range events {
 		if event.Name.Name == name {
@@ -831,18 +1081,20 @@ func (fs *Fsm) getEventItemByName(name string) (rp EventItem) {
 	return
 }
 
-func (fs *Fsm) getStartEvent(machineId uint) (rp Event) {
+func (fs *Fsm) getStartEvent(machineId, version uint) (rp Event) {
 	fs.session.
 		Preload("Name").
 		Preload("Src").
 		Preload("Dst").
 		Where("machine_id = ?", machineId).
+		Where("version = ?", version).
 		Where("sort = ?", constant.Zero).
 		First(&rp)
 	return
 }
 
-func (fs *Fsm) getPrevEvent(machineId uint, level uint) (rp Event) {
+// get the nearest previous level event whose guard matches detail
+func (fs *Fsm) getPrevEvent(machineId, version, level uint, detail string) (rp Event) {
 	events := make([]Event, 0)
 	fs.session.
 		Preload("Name").
@@ -851,19 +1103,28 @@ func (fs *Fsm) getPrevEvent(machineId uint, level uint) (rp Event) {
 		Preload("Roles").
 		Preload("Users").
 		Where("machine_id = ?", machineId).
-		Where("level = ?", level-1).
+		Where("version = ?", version).
+		Where("level < ?", level).
+		Order("level DESC").
 		Order("sort").
 		Find(&events)
 	for _, event := range events {
 		if strings.HasSuffix(event.Name.Name, i18n.T(constant.FsmSuffixWaiting)) || strings.HasSuffix(event.Name.Name, i18n.T(constant.FsmSuffixResubmit)) {
-			rp = event
-			return
+			ok, err := matchGuard(event.Guard, detail)
+			if fs.AddError(err) != nil {
+				return
+			}
+			if ok {
+				rp = event
+				return
+			}
 		}
 	}
 	return
 }
 
-func (fs *Fsm) getNextEvent(machineId uint, level uint) (rp Event) {
+// get the nearest next level event whose guard matches detail
+func (fs *Fsm) getNextEvent(machineId, version, level uint, detail string) (rp Event) {
 	events := make([]Event, 0)
 	fs.session.
 		Preload("Name").
@@ -872,30 +1133,39 @@ func (fs *Fsm) getNextEvent(machineId uint, level uint) (rp Event) {
 		Preload("Roles").
 		Preload("Users").
 		Where("machine_id = ?", machineId).
-		Where("level = ?", level+1).
+		Where("version = ?", version).
+		Where("level > ?", level).
+		Order("level").
 		Order("sort").
 		Find(&events)
 	for _, event := range events {
 		if strings.HasSuffix(event.Name.Name, i18n.T(constant.FsmSuffixWaiting)) || strings.HasSuffix(event.Name.Name, i18n.T(constant.FsmSuffixConfirm)) {
-			rp = event
-			return
+			ok, err := matchGuard(event.Guard, detail)
+			if fs.AddError(err) != nil {
+				return
+			}
+			if ok {
+				rp = event
+				return
+			}
 		}
 	}
 	return
 }
 
-func (fs *Fsm) getEndEvent(machineId uint) (rp Event) {
+func (fs *Fsm) getEndEvent(machineId, version uint) (rp Event) {
 	fs.session.
 		Preload("Name").
 		Preload("Src").
 		Preload("Dst").
 		Where("machine_id = ?", machineId).
+		Where("version = ?", version).
 		Order("sort DESC").
 		First(&rp)
 	return
 }
 
-func (fs *Fsm) findEventDesc(machineId uint) (rp []fsm.EventDesc) {
+func (fs *Fsm) findEventDesc(machineId, version uint) (rp []fsm.EventDesc) {
 	events := make([]Event, 0)
 	list := make([]fsm.EventDesc, 0)
 	fs.session.
@@ -903,6 +1173,7 @@ func (fs *Fsm) findEventDesc(machineId uint) (rp []fsm.EventDesc) {
 		Preload("Src").
 		Preload("Dst").
 		Where("machine_id = ?", machineId).
+		Where("version = ?", version).
 		Order("sort").
 		Find(&events)
 	for _, event := range events {
@@ -954,7 +1225,7 @@ func (fs *Fsm) findEventDesc(machineId uint) (rp []fsm.EventDesc) {
 // L2 waiting refuse  / L1 approved               / L2 refused
 // L0 waiting confirm / L2 approved               / L0 confirmed
 // end
-func (fs *Fsm) batchCreateEvent(machineId uint, r []req.FsmCreateEvent) {
+func (fs *Fsm) batchCreateEvent(machineId, version uint, r []req.FsmCreateEvent) {
 	if fs.Error != nil {
 		return
 	}
@@ -962,10 +1233,11 @@ func (fs *Fsm) batchCreateEvent(machineId uint, r []req.FsmCreateEvent) {
 		fs.AddError(i18n.E(ErrLevelsEmpty))
 		return
 	}
-	// clear old machine
+	// clear events of this version(old versions are kept for in-flight logs)
 	fs.session.
 		Unscoped().
 		Where("machine_id = ?", machineId).
+		Where("version = ?", version).
 		Delete(&Event{})
 
 	var machine Machine
@@ -978,99 +1250,13 @@ func (fs *Fsm) batchCreateEvent(machineId uint, r []req.FsmCreateEvent) {
 		return
 	}
 
-	// save event names and event desc
+	desc, levels := buildEventDesc(machine.SubmitterName, machine.SubmitterConfirm, r)
+	// save event names
 	names := make([]string, 0)
-	desc := make([]fsm.EventDesc, 0)
-	// save event level for sort setup
-	levels := make(map[string]uint, 0)
-
-	// L0 waiting submit / L1 refused / L0 submitted
-	l0Name := fmt.Sprintf("%s %s", machine.SubmitterName, i18n.T(constant.FsmSuffixResubmit))
-	l0Srcs := []string{
-		fmt.Sprintf("%s %s", r[0].Name, i18n.T(constant.FsmSuffixRefused)),
-	}
-	l0Dst := fmt.Sprintf("%s %s", machine.SubmitterName, i18n.T(constant.FsmSuffixSubmitted))
-	names = append(names, l0Name)
-	names = append(names, l0Srcs...)
-	names = append(names, l0Dst)
-	desc = append(desc, fsm.EventDesc{
-		Name: l0Name,
-		Src:  l0Srcs,
-		Dst:  l0Dst,
-	})
-	levels[l0Name] = 0
-	levels[l0Srcs[0]] = 0
-	levels[l0Dst] = 0
-
-	l := len(r)
-	for i := 0; i < l; i++ {
-		// approve
-		// L1 waiting approve / L0 submitted , L2 refused / L1 approved
-		// L2 waiting approve / L1 approved               / L2 approved
-		li1Name := fmt.Sprintf("%s %s", r[i].Name, i18n.T(constant.FsmSuffixWaiting))
-		li1Srcs := make([]string, 0)
-		if i > 0 {
-			li1Srcs = append(li1Srcs, fmt.Sprintf("%s %s", r[i-1].Name, i18n.T(constant.FsmSuffixApproved)))
-		} else {
-			li1Srcs = append(li1Srcs, fmt.Sprintf("%s %s", machine.SubmitterName, i18n.T(constant.FsmSuffixSubmitted)))
-		}
-		li1Dst := fmt.Sprintf("%s %s", r[i].Name, i18n.T(constant.FsmSuffixApproved))
-		if i+1 < l {
-			li1Srcs = append(li1Srcs, fmt.Sprintf("%s %s", r[i+1].Name, i18n.T(constant.FsmSuffixRefused)))
-		}
-		names = append(names, li1Name)
-		names = append(names, li1Srcs...)
-		names = append(names, li1Dst)
-		desc = append(desc, fsm.EventDesc{
-			Name: li1Name,
-			Src:  li1Srcs,
-			Dst:  li1Dst,
-		})
-		levels[li1Name] = uint(i + 1)
-		levels[li1Dst] = uint(i + 1)
-
-		// refuse
-		// L1 waiting refuse / L0 submitted / L1 refused
-		// L2 waiting refuse / L1 approved  / L2 refused
-		li2Name := fmt.Sprintf("%s %s", r[i].Name, i18n.T(constant.FsmSuffixWaiting))
-		li2Srcs := make([]string, 0)
-		if i == 0 {
-			li2Srcs = append(li2Srcs, fmt.Sprintf("%s %s", machine.SubmitterName, i18n.T(constant.FsmSuffixSubmitted)))
-		} else {
-			li2Srcs = append(li2Srcs, fmt.Sprintf("%s %s", r[i-1].Name, i18n.T(constant.FsmSuffixApproved)))
-			if i+1 < l {
-				li2Srcs = append(li2Srcs, fmt.Sprintf("%s %s", r[i+1].Name, i18n.T(constant.FsmSuffixRefused)))
-			}
-		}
-		li2Dst := fmt.Sprintf("%s %s", r[i].Name, i18n.T(constant.FsmSuffixRefused))
-		names = append(names, li2Name)
-		names = append(names, li2Srcs...)
-		names = append(names, li2Dst)
-		desc = append(desc, fsm.EventDesc{
-			Name: li2Name,
-			Src:  li2Srcs,
-			Dst:  li2Dst,
-		})
-		levels[li2Name] = uint(i + 1)
-		levels[li2Dst] = uint(i + 1)
-	}
-	if machine.SubmitterConfirm == constant.One {
-		// L0 waiting confirm / L2 approved / L0 confirmed
-		l0Name := fmt.Sprintf("%s %s", machine.SubmitterName, i18n.T(constant.FsmSuffixConfirm))
-		l0Srcs := []string{
-			fmt.Sprintf("%s %s", r[l-1].Name, i18n.T(constant.FsmSuffixApproved)),
-		}
-		l0Dst := fmt.Sprintf("%s %s", machine.SubmitterName, i18n.T(constant.FsmSuffixConfirmed))
-		names = append(names, l0Name)
-		names = append(names, l0Srcs...)
-		names = append(names, l0Dst)
-		desc = append(desc, fsm.EventDesc{
-			Name: l0Name,
-			Src:  l0Srcs,
-			Dst:  l0Dst,
-		})
-		levels[l0Name] = uint(l + 1)
-		levels[l0Dst] = uint(l + 1)
+	for _, d := range desc {
+		names = append(names, d.Name)
+		names = append(names, d.Src...)
+		names = append(names, d.Dst)
 	}
 
 	// remove repeat name
@@ -1120,6 +1306,10 @@ func (fs *Fsm) batchCreateEvent(machineId uint, r []req.FsmCreateEvent) {
 		// default: no edit permission
 		edit := constant.Zero
 		editFields := ""
+		policy := constant.FsmApprovalPolicyAny
+		quorum := constant.Zero
+		guard := ""
+		var sla Event
 		roles := make([]Role, 0)
 		users := make([]User, 0)
 		if i == 0 {
@@ -1131,6 +1321,17 @@ func (fs *Fsm) batchCreateEvent(machineId uint, r []req.FsmCreateEvent) {
 			index := (i+1)/2 - 1
 			edit = uint(r[index].Edit)
 			editFields = r[index].EditFields
+			policy = uint(r[index].Policy)
+			quorum = uint(r[index].Quorum)
+			guard = strings.TrimSpace(r[index].Guard)
+			sla = Event{
+				RemindAfter:    uint(r[index].RemindAfter),
+				EscalateAfter:  uint(r[index].EscalateAfter),
+				EscalateRoleId: uint(r[index].EscalateRoleId),
+				EscalateUserId: uint(r[index].EscalateUserId),
+				TimeoutAfter:   uint(r[index].TimeoutAfter),
+				TimeoutAction:  uint(r[index].TimeoutAction),
+			}
 			// find roles/users
 			roles = fs.findRole(r[index].Roles.Uints())
 			users = fs.findUser(r[index].Users.Uints())
@@ -1142,6 +1343,7 @@ func (fs *Fsm) batchCreateEvent(machineId uint, r []req.FsmCreateEvent) {
 
 		events = append(events, Event{
 			MachineId:  machineId,
+			Version:    version,
 			Sort:       uint(i),
 			Level:      levels[d.Name],
 			NameId:     nameId,
@@ -1151,6 +1353,16 @@ func (fs *Fsm) batchCreateEvent(machineId uint, r []req.FsmCreateEvent) {
 			EditFields: editFields,
 			Roles:      roles,
 			Users:      users,
+			Policy:     policy,
+			Quorum:     quorum,
+			Guard:      guard,
+			// sla settings
+			RemindAfter:    sla.RemindAfter,
+			EscalateAfter:  sla.EscalateAfter,
+			EscalateRoleId: sla.EscalateRoleId,
+			EscalateUserId: sla.EscalateUserId,
+			TimeoutAfter:   sla.TimeoutAfter,
+			TimeoutAction:  sla.TimeoutAction,
 		})
 	}
 	if len(events) > 0 {
@@ -1159,6 +1371,109 @@ func (fs *Fsm) batchCreateEvent(machineId uint, r []req.FsmCreateEvent) {
 	return
 }
 
+// check levels before create machine events
+func (fs *Fsm) checkLevels(r []req.FsmCreateEvent) {
+	if fs.Error != nil {
+		return
+	}
+	if len(r) == 0 {
+		fs.AddError(i18n.E(ErrLevelsEmpty))
+		return
+	}
+	for _, item := range r {
+		if uint(item.Policy) > constant.FsmApprovalPolicyQuorum {
+			fs.AddError(errors.Wrap(i18n.E(ErrParams), "policy"))
+			return
+		}
+		if uint(item.Policy) == constant.FsmApprovalPolicyQuorum &&
+			(uint(item.Quorum) == constant.Zero || int(item.Quorum) > len(item.Roles.Uints())+len(item.Users.Uints())) {
+			// the level can never pass if quorum is larger than approvers
+			fs.AddError(errors.Wrap(i18n.E(ErrParams), "quorum"))
+			return
+		}
+		if fs.AddError(checkGuard(item.Guard)) != nil {
+			return
+		}
+		if uint(item.TimeoutAfter) > constant.Zero && uint(item.TimeoutAction) != constant.FsmLogStatusApproved && uint(item.TimeoutAction) != constant.FsmLogStatusRefused {
+			fs.AddError(errors.Wrap(i18n.E(ErrParams), "timeoutAction"))
+			return
+		}
+		if uint(item.EscalateAfter) > constant.Zero && uint(item.EscalateRoleId) == constant.Zero && uint(item.EscalateUserId) == constant.Zero {
+			fs.AddError(errors.Wrap(i18n.E(ErrParams), "escalateRoleId/escalateUserId"))
+			return
+		}
+	}
+}
+
+// vote save current approver vote, return true if the countersign/quorum level is passed
+func (fs *Fsm) vote(oldLog Log, r req.FsmApproveLog) (pass bool) {
+	// the delegate votes on behalf of the delegator
+	userId := r.ApprovalUserId
+	if oldLog.DelegatorUserId > constant.Zero {
+		userId = oldLog.DelegatorUserId
+	}
+	for _, item := range oldLog.Votes {
+		if (userId > constant.Zero && (item.ApprovalUserId == userId || item.DelegatorUserId == userId)) ||
+			(userId == constant.Zero && item.ApprovalRoleId == r.ApprovalRoleId) {
+			fs.AddError(i18n.E(ErrRepeatApprove))
+			return
+		}
+	}
+	v := LogVote{
+		LogId:           oldLog.Id,
+		ApprovalRoleId:  r.ApprovalRoleId,
+		ApprovalUserId:  r.ApprovalUserId,
+		DelegatorRoleId: oldLog.DelegatorRoleId,
+		DelegatorUserId: oldLog.DelegatorUserId,
+		Approved:        uint(r.Approved),
+		ApprovalOpinion: r.ApprovalOpinion,
+	}
+	diff := diffDetail(oldLog.DetailJson, r.DetailJson)
+	if len(diff) > 0 {
+		v.DetailDiff = utils.Struct2Json(diff)
+	}
+	fs.session.Create(&v)
+	// refused by anyone, the level is refused
+	if v.Approved == constant.FsmLogStatusRefused {
+		pass = true
+		return
+	}
+	escalated := fs.isEscalated(oldLog)
+	// the fallback approver substitutes all pending approvers of escalated level
+	if escalated && isEscalateVoter(oldLog.NextEvent, r) {
+		pass = true
+		return
+	}
+	oldLog.Votes = append(oldLog.Votes, v)
+	pendingRoles, pendingUsers := getPendingApprovers(oldLog)
+	if escalated {
+		// the fallback approver is not a required voter
+		pendingRoles, pendingUsers = excludeEscalateApprovers(oldLog.NextEvent, pendingRoles, pendingUsers)
+	}
+	switch oldLog.NextEvent.Policy {
+	case constant.FsmApprovalPolicyAll:
+		pass = len(pendingRoles) == 0 && len(pendingUsers) == 0
+	case constant.FsmApprovalPolicyQuorum:
+		count := uint(0)
+		for _, item := range oldLog.Votes {
+			if item.Approved == constant.FsmLogStatusApproved {
+				count++
+			}
+		}
+		pass = count >= oldLog.NextEvent.Quorum
+	default:
+		pass = true
+	}
+	// keep the edit of non-final voter, the final voter approves on it
+	if !pass && len(diff) > 0 {
+		fs.session.
+			Model(&Log{}).
+			Where("id = ?", oldLog.Id).
+			Update("detail_json", r.DetailJson)
+	}
+	return
+}
+
 func (fs *Fsm) findUser(ids []uint) []User {
 	users := make([]User, 0)
 	fs.session.
@@ -1224,6 +1539,177 @@ func (fs *Fsm) AddError(err error) error {
 	return fs.Error
 }
 
+// build looplab fsm event desc by levels, levels saves the level of each event/state name
+func buildEventDesc(submitterName string, submitterConfirm uint, r []req.FsmCreateEvent) (desc []fsm.EventDesc, levels map[string]uint) {
+	desc = make([]fsm.EventDesc, 0)
+	// save event level for sort setup
+	levels = make(map[string]uint, 0)
+
+	// L0 waiting submit / L1 refused / L0 submitted
+	l0Name := fmt.Sprintf("%s %s", submitterName, i18n.T(constant.FsmSuffixResubmit))
+	l0Srcs := []string{
+		fmt.Sprintf("%s %s", r[0].Name, i18n.T(constant.FsmSuffixRefused)),
+	}
+	l0Dst := fmt.Sprintf("%s %s", submitterName, i18n.T(constant.FsmSuffixSubmitted))
+	desc = append(desc, fsm.EventDesc{
+		Name: l0Name,
+		Src:  l0Srcs,
+		Dst:  l0Dst,
+	})
+	levels[l0Name] = 0
+	levels[l0Srcs[0]] = 0
+	levels[l0Dst] = 0
+
+	l := len(r)
+	for i := 0; i < l; i++ {
+		// approve
+		// L1 waiting approve / L0 submitted , L2 refused / L1 approved
+		// L2 waiting approve / L1 approved               / L2 approved
+		li1Name := fmt.Sprintf("%s %s", r[i].Name, i18n.T(constant.FsmSuffixWaiting))
+		li1Srcs := make([]string, 0)
+		if i > 0 {
+			li1Srcs = append(li1Srcs, fmt.Sprintf("%s %s", r[i-1].Name, i18n.T(constant.FsmSuffixApproved)))
+		} else {
+			li1Srcs = append(li1Srcs, fmt.Sprintf("%s %s", submitterName, i18n.T(constant.FsmSuffixSubmitted)))
+		}
+		li1Dst := fmt.Sprintf("%s %s", r[i].Name, i18n.T(constant.FsmSuffixApproved))
+		if i+1 < l {
+			li1Srcs = append(li1Srcs, fmt.Sprintf("%s %s", r[i+1].Name, i18n.T(constant.FsmSuffixRefused)))
+		}
+		desc = append(desc, fsm.EventDesc{
+			Name: li1Name,
+			Src:  li1Srcs,
+			Dst:  li1Dst,
+		})
+		levels[li1Name] = uint(i + 1)
+		levels[li1Dst] = uint(i + 1)
+
+		// refuse
+		// L1 waiting refuse / L0 submitted / L1 refused
+		// L2 waiting refuse / L1 approved  / L2 refused
+		li2Name := fmt.Sprintf("%s %s", r[i].Name, i18n.T(constant.FsmSuffixWaiting))
+		li2Srcs := make([]string, 0)
+		if i == 0 {
+			li2Srcs = append(li2Srcs, fmt.Sprintf("%s %s", submitterName, i18n.T(constant.FsmSuffixSubmitted)))
+		} else {
+			li2Srcs = append(li2Srcs, fmt.Sprintf("%s %s", r[i-1].Name, i18n.T(constant.FsmSuffixApproved)))
+			if i+1 < l {
+				li2Srcs = append(li2Srcs, fmt.Sprintf("%s %s", r[i+1].Name, i18n.T(constant.FsmSuffixRefused)))
+			}
+		}
+		li2Dst := fmt.Sprintf("%s %s", r[i].Name, i18n.T(constant.FsmSuffixRefused))
+		desc = append(desc, fsm.EventDesc{
+			Name: li2Name,
+			Src:  li2Srcs,
+			Dst:  li2Dst,
+		})
+		levels[li2Name] = uint(i + 1)
+		levels[li2Dst] = uint(i + 1)
+	}
+	if submitterConfirm == constant.One {
+		// L0 waiting confirm / L2 approved / L0 confirmed
+		l0Name := fmt.Sprintf("%s %s", submitterName, i18n.T(constant.FsmSuffixConfirm))
+		l0Srcs := []string{
+			fmt.Sprintf("%s %s", r[l-1].Name, i18n.T(constant.FsmSuffixApproved)),
+		}
+		l0Dst := fmt.Sprintf("%s %s", submitterName, i18n.T(constant.FsmSuffixConfirmed))
+		desc = append(desc, fsm.EventDesc{
+			Name: l0Name,
+			Src:  l0Srcs,
+			Dst:  l0Dst,
+		})
+		levels[l0Name] = uint(l + 1)
+		levels[l0Dst] = uint(l + 1)
+	}
+	return
+}
+
+// get the source item of the event, it is used as progress when some levels are skipped by guard
+func getSrcItem(event Event, refused bool) (rp EventItem) {
+	for _, item := range event.Src {
+		if strings.HasSuffix(item.Name, i18n.T(constant.FsmSuffixRefused)) == refused {
+			rp = item
+			return
+		}
+	}
+	return
+}
+
+// get approvers who have not voted yet
+func getPendingApprovers(l Log) (roles []Role, users []User) {
+	roles = make([]Role, 0)
+	users = make([]User, 0)
+	votedRoleIds := make([]uint, 0)
+	votedUserIds := make([]uint, 0)
+	for _, item := range l.Votes {
+		if item.Approved != constant.FsmLogStatusApproved {
+			continue
+		}
+		votedRoleIds = append(votedRoleIds, item.ApprovalRoleId)
+		votedUserIds = append(votedUserIds, item.ApprovalUserId)
+		if item.DelegatorUserId > constant.Zero {
+			votedRoleIds = append(votedRoleIds, item.DelegatorRoleId)
+			votedUserIds = append(votedUserIds, item.DelegatorUserId)
+		}
+	}
+	for _, item := range l.CanApprovalRoles {
+		if !utils.ContainsUint(votedRoleIds, item.Id) {
+			roles = append(roles, item)
+		}
+	}
+	for _, item := range l.CanApprovalUsers {
+		if !utils.ContainsUint(votedUserIds, item.Id) {
+			users = append(users, item)
+		}
+	}
+	return
+}
+
+// check whether the log is escalated by sla
+func (fs *Fsm) isEscalated(l Log) bool {
+	if l.NextEvent.EscalateRoleId == constant.Zero && l.NextEvent.EscalateUserId == constant.Zero {
+		return false
+	}
+	var count int64
+	fs.session.
+		Model(&LogAction{}).
+		Where("log_id = ?", l.Id).
+		Where("action = ?", constant.FsmSlaActionEscalate).
+		Count(&count)
+	return count > 0
+}
+
+func isEscalateVoter(e Event, r req.FsmApproveLog) bool {
+	return (e.EscalateUserId > constant.Zero && r.ApprovalUserId == e.EscalateUserId) ||
+		(e.EscalateRoleId > constant.Zero && r.ApprovalRoleId == e.EscalateRoleId)
+}
+
+func excludeEscalateApprovers(e Event, roles []Role, users []User) (rpRoles []Role, rpUsers []User) {
+	rpRoles = make([]Role, 0, len(roles))
+	rpUsers = make([]User, 0, len(users))
+	for _, item := range roles {
+		if item.Id != e.EscalateRoleId {
+			rpRoles = append(rpRoles, item)
+		}
+	}
+	for _, item := range users {
+		if item.Id != e.EscalateUserId {
+			rpUsers = append(rpUsers, item)
+		}
+	}
+	return
+}
+
+// votes of the voter, including the ones voted by delegate on behalf of the voter
+func voterScope(roleId, userId uint) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if userId > constant.Zero {
+			return db.Where("approval_user_id = ? OR delegator_user_id = ?", userId, userId)
+		}
+		return db.Where("approval_role_id = ? OR delegator_role_id = ?", roleId, roleId)
+	}
+}
+
 func getNextItemName(approved uint, eventName string) string {
 	name := eventName
 	if strings.HasSuffix(eventName, i18n.T(constant.FsmSuffixWaiting)) {
@@ -2,7 +2,11 @@ package fsm
 
 import (
 	"fmt"
+	"github.com/ennismar/go-helper/pkg/constant"
+	"github.com/ennismar/go-helper/pkg/delay"
 	"github.com/ennismar/go-helper/pkg/req"
+	"github.com/ennismar/go-helper/pkg/utils"
+	"github.com/golang-module/carbon/v2"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 	"gorm.io/gorm/schema"
@@ -297,3 +301,416 @@ func TestFsm_GetLogTrack(t *testing.T) {
 	fmt.Println(f.FindLogTrack(list))
 	tx.Commit()
 }
+
+func TestFsm_ApproveLogCountersign(t *testing.T) {
+	uid := "log6"
+	tx := db.Begin()
+	f := New(WithDb(tx))
+	f.CreateMachine(req.FsmCreateMachine{
+		Category:      2,
+		Name:          "Contract Approval",
+		SubmitterName: "applicant",
+		Levels: []req.FsmCreateEvent{
+			{
+				Name:   "L1",
+				Users:  "4,5,6",
+				Policy: 1, // all users must approve
+			},
+			{
+				Name:   "L2",
+				Users:  "7,8,9",
+				Policy: 2, // 2 of 3 must approve
+				Quorum: 2,
+			},
+		},
+	})
+	if f.Error != nil {
+		fmt.Println(f.Error)
+	}
+	f.SubmitLog(req.FsmCreateLog{
+		Category:        2,
+		Uuid:            uid,
+		SubmitterUserId: 123,
+	})
+	for _, id := range []uint{4, 5, 6, 7, 8} {
+		rp := f.ApproveLog(req.FsmApproveLog{
+			Category:       2,
+			Uuid:           uid,
+			ApprovalUserId: id,
+			Approved:       1,
+		})
+		if f.Error != nil {
+			fmt.Println(f.Error)
+		}
+		fmt.Println(id, rp.Pending, rp.End)
+	}
+
+	tx.Commit()
+}
+
+func TestFsm_SubmitLogWithGuard(t *testing.T) {
+	uid := "log7"
+	tx := db.Begin()
+	f := New(WithDb(tx))
+	f.CreateMachine(req.FsmCreateMachine{
+		Category:      3,
+		Name:          "Payment Approval",
+		SubmitterName: "applicant",
+		Levels: []req.FsmCreateEvent{
+			{
+				Name:  "finance",
+				Roles: "4",
+				Guard: `amount > 10000 && dept == "ops"`,
+			},
+			{
+				Name:  "manager",
+				Roles: "5",
+			},
+		},
+	})
+	if f.Error != nil {
+		fmt.Println(f.Error)
+	}
+	// finance is skipped
+	fmt.Println(f.SubmitLog(req.FsmCreateLog{
+		Category:        3,
+		Uuid:            uid,
+		SubmitterUserId: 123,
+		DetailJson:      `{"amount": 500, "dept": "ops"}`,
+	}))
+	if f.Error != nil {
+		fmt.Println(f.Error)
+	}
+
+	tx.Commit()
+}
+
+func TestFsm_Delegation(t *testing.T) {
+	tx := db.Begin()
+	f := New(WithDb(tx))
+	f.CreateDelegation(req.FsmCreateDelegation{
+		FromUserId: 4,
+		ToUserId:   10,
+		StartAt:    carbon.DateTime{Carbon: carbon.Now()},
+		EndAt:      carbon.DateTime{Carbon: carbon.Now().AddDays(7)},
+		Categories: "1",
+	})
+	if f.Error != nil {
+		fmt.Println(f.Error)
+	}
+	// user 10 can see user 4's pending logs
+	fmt.Println(f.FindPendingLogByApprover(&req.FsmPendingLog{
+		ApprovalUserId: 10,
+		Category:       1,
+	}))
+	tx.Commit()
+}
+
+func TestFsm_MachineVersion(t *testing.T) {
+	uid := "log8"
+	tx := db.Begin()
+	f := New(WithDb(tx))
+	machine := f.CreateMachine(req.FsmCreateMachine{
+		Category:      4,
+		Name:          "Purchase Approval",
+		SubmitterName: "applicant",
+		Levels: []req.FsmCreateEvent{
+			{
+				Name:  "L1",
+				Roles: "4",
+			},
+		},
+	})
+	// submit on version 1
+	f.SubmitLog(req.FsmCreateLog{
+		Category:        4,
+		Uuid:            uid,
+		SubmitterUserId: 123,
+	})
+	// version 2 adds a level, log8 still finishes on version 1
+	f.UpdateMachineById(machine.Id, req.FsmUpdateMachine{
+		Levels: []req.FsmCreateEvent{
+			{
+				Name:  "L1",
+				Roles: "4",
+			},
+			{
+				Name:  "L2",
+				Roles: "5",
+			},
+		},
+	})
+	fmt.Println(f.ApproveLog(req.FsmApproveLog{
+		Category:       4,
+		Uuid:           uid,
+		ApprovalRoleId: 4,
+		Approved:       1,
+	}))
+	fmt.Println(f.FindMachineVersion(&req.FsmMachineVersion{
+		MachineId: machine.Id,
+	}))
+	fmt.Println(f.DiffMachineVersion(req.FsmDiffMachineVersion{
+		MachineId: machine.Id,
+		From:      1,
+		To:        2,
+	}))
+	if f.Error != nil {
+		fmt.Println(f.Error)
+	}
+
+	tx.Commit()
+}
+
+func TestFsm_ImportMachine(t *testing.T) {
+	tx := db.Begin()
+	f := New(WithDb(tx))
+	r := req.FsmImportMachine{
+		Content: `
+category: 5
+name: Reimbursement Approval
+submitterName: applicant
+levels:
+  - name: L1
+    roles: 4,5
+  - name: L2
+    users: 8
+    guard: amount > 1000
+`,
+	}
+	fmt.Println(f.DryRunMachine(r))
+	f.ImportMachine(r)
+	if f.Error != nil {
+		fmt.Println(f.Error)
+	}
+	machine := f.GetMachineByCategory(5)
+	fmt.Println(string(f.ExportMachine(req.FsmExportMachine{
+		Id: machine.Id,
+	})))
+
+	tx.Commit()
+}
+
+func TestFsm_Render(t *testing.T) {
+	tx := db.Begin()
+	f := New(WithDb(tx))
+	fmt.Println(f.RenderMachine(req.FsmRender{
+		Category: 1,
+	}))
+	fmt.Println(f.RenderLog(req.FsmRender{
+		Category: 1,
+		Uuid:     "log1",
+		Format:   "mermaid",
+	}))
+	if f.Error != nil {
+		fmt.Println(f.Error)
+	}
+
+	tx.Commit()
+}
+
+func TestFsm_Outbox(t *testing.T) {
+	uid := "log9"
+	tx := db.Begin()
+	f := New(WithDb(tx), WithOutbox(true))
+	f.SubmitLog(req.FsmCreateLog{
+		Category:        1,
+		Uuid:            uid,
+		SubmitterUserId: 123,
+	})
+	if f.Error != nil {
+		fmt.Println(f.Error)
+	}
+	// the outbox event is committed/rollback together with the log
+	var count int64
+	tx.Model(&Outbox{}).Where("uuid = ?", uid).Count(&count)
+	fmt.Println(count)
+
+	tx.Commit()
+}
+
+func TestFsm_EditFieldsDiff(t *testing.T) {
+	uid := "log10"
+	tx := db.Begin()
+	f := New(WithDb(tx))
+	f.SubmitLog(req.FsmCreateLog{
+		Category:        1,
+		Uuid:            uid,
+		SubmitterUserId: 123,
+		DetailJson:      `{"name":"leave","status":0}`,
+	})
+	// L1 can edit status
+	fmt.Println(f.ApproveLog(req.FsmApproveLog{
+		Category:       1,
+		Uuid:           uid,
+		ApprovalUserId: 4,
+		Approved:       1,
+		DetailJson:     `{"name":"leave","status":1}`,
+	}))
+	// L2 has no edit permission
+	fmt.Println(f.ApproveLog(req.FsmApproveLog{
+		Category:       1,
+		Uuid:           uid,
+		ApprovalUserId: 8,
+		Approved:       1,
+		DetailJson:     `{"name":"sick leave","status":1}`,
+	}))
+	if f.Error != nil {
+		fmt.Println(f.Error)
+	}
+	f.Error = nil
+	fmt.Println(f.FindLogTrack(f.FindLog(req.FsmLog{
+		Category: 1,
+		Uuid:     uid,
+	})))
+
+	// empty edit fields allows all fields
+	f.CreateMachine(req.FsmCreateMachine{
+		Category:      4,
+		Name:          "Expense Approval",
+		SubmitterName: "applicant",
+		Levels: []req.FsmCreateEvent{
+			{
+				Name:  "L1",
+				Edit:  1,
+				Users: "4",
+			},
+		},
+	})
+	f.SubmitLog(req.FsmCreateLog{
+		Category:        4,
+		Uuid:            uid,
+		SubmitterUserId: 123,
+		DetailJson:      `{"name":"taxi","amount":10}`,
+	})
+	fmt.Println(f.ApproveLog(req.FsmApproveLog{
+		Category:       4,
+		Uuid:           uid,
+		ApprovalUserId: 4,
+		Approved:       1,
+		DetailJson:     `{"name":"taxi","amount":20}`,
+	}))
+	if f.Error != nil {
+		fmt.Println(f.Error)
+	}
+
+	tx.Commit()
+}
+
+func TestFsm_Report(t *testing.T) {
+	tx := db.Begin()
+	f := New(WithDb(tx))
+	category := req.NullUint(1)
+	fmt.Println(f.Report(req.FsmReport{
+		Category:  &category,
+		StartTime: "2022-01-01",
+		EndTime:   "2022-12-31",
+	}))
+	if f.Error != nil {
+		fmt.Println(f.Error)
+	}
+
+	tx.Commit()
+}
+
+func TestFsm_HandleSlaTask(t *testing.T) {
+	uid := "log11"
+	tx := db.Begin()
+	f := New(WithDb(tx))
+	f.CreateMachine(req.FsmCreateMachine{
+		Category:      3,
+		Name:          "Purchase Approval",
+		SubmitterName: "applicant",
+		Levels: []req.FsmCreateEvent{
+			{
+				Name:          "L1",
+				Users:         "4",
+				TimeoutAfter:  1,
+				TimeoutAction: 1, // auto approve
+			},
+		},
+	})
+	f.SubmitLog(req.FsmCreateLog{
+		Category:        3,
+		Uuid:            uid,
+		SubmitterUserId: 123,
+	})
+	if f.Error != nil {
+		fmt.Println(f.Error)
+	}
+	tx.Commit()
+	// sla tasks are enqueued only after commit
+	f.FlushSla()
+
+	f2 := New(WithDb(db))
+	logs := f2.FindLog(req.FsmLog{
+		Category: 3,
+		Uuid:     uid,
+	})
+	if len(logs) == 0 {
+		return
+	}
+	// timeout action runs in its own transaction
+	f2.HandleSlaTask(delay.Task{
+		Name: constant.FsmSlaTaskName,
+		Payload: utils.Struct2Json(slaPayload{
+			LogId:  logs[len(logs)-1].Id,
+			Action: constant.FsmSlaActionTimeout,
+		}),
+	})
+	if f2.Error != nil {
+		fmt.Println(f2.Error)
+	}
+	var count int64
+	db.Model(&LogAction{}).Where("log_id = ?", logs[len(logs)-1].Id).Count(&count)
+	fmt.Println(count)
+}
+
+func TestFsm_SlaEscalate(t *testing.T) {
+	uid := "log12"
+	tx := db.Begin()
+	f := New(WithDb(tx))
+	f.CreateMachine(req.FsmCreateMachine{
+		Category:      5,
+		Name:          "Travel Approval",
+		SubmitterName: "applicant",
+		Levels: []req.FsmCreateEvent{
+			{
+				Name:           "L1",
+				Users:          "4,5",
+				Policy:         1, // all users must approve
+				EscalateAfter:  1,
+				EscalateUserId: 9,
+			},
+		},
+	})
+	f.SubmitLog(req.FsmCreateLog{
+		Category:        5,
+		Uuid:            uid,
+		SubmitterUserId: 123,
+	})
+	logs := f.FindLog(req.FsmLog{
+		Category: 5,
+		Uuid:     uid,
+	})
+	if len(logs) == 0 {
+		fmt.Println(f.Error)
+		return
+	}
+	f.escalate(f.getLastPendingLog(req.FsmLog{
+		Category: 5,
+		Uuid:     uid,
+	}))
+	// the fallback approver passes the level alone
+	rp := f.ApproveLog(req.FsmApproveLog{
+		Category:       5,
+		Uuid:           uid,
+		ApprovalUserId: 9,
+		Approved:       1,
+	})
+	fmt.Println(rp.End)
+	if f.Error != nil {
+		fmt.Println(f.Error)
+	}
+
+	tx.Commit()
+}
@@ -0,0 +1,74 @@
+package fsm
+
+import (
+	"github.com/Knetic/govaluate"
+	"github.com/ennismar/go-helper/pkg/i18n"
+	"github.com/ennismar/go-helper/pkg/utils"
+	"github.com/pkg/errors"
+	"strings"
+)
+
+// check guard expression syntax, example: amount > 10000 && dept == "ops"
+func checkGuard(guard string) (err error) {
+	guard = strings.TrimSpace(guard)
+	if guard == "" {
+		return
+	}
+	_, err = govaluate.NewEvaluableExpression(guard)
+	if err != nil {
+		err = errors.Wrapf(i18n.E(ErrIllegalGuard), "%s: %v", guard, err)
+	}
+	return
+}
+
+// match guard expression with log detail json, empty guard always matches, guard with missing variable never matches
+func matchGuard(guard, detail string) (ok bool, err error) {
+	guard = strings.TrimSpace(guard)
+	if guard == "" {
+		ok = true
+		return
+	}
+	expr, err := govaluate.NewEvaluableExpression(guard)
+	if err != nil {
+		err = errors.Wrapf(i18n.E(ErrIllegalGuard), "%s: %v", guard, err)
+		return
+	}
+	params := make(map[string]interface{})
+	if strings.TrimSpace(detail) != "" {
+		utils.Json2Struct(detail, &params)
+	}
+	// variable missing in detail json means the guard is not matched
+	for _, v := range expr.Vars() {
+		if _, exists := params[v]; !exists {
+			return
+		}
+	}
+	res, err := expr.Evaluate(params)
+	if err != nil {
+		err = errors.Wrapf(i18n.E(ErrIllegalGuard), "%s: %v", guard, err)
+		return
+	}
+	ok, _ = res.(bool)
+	return
+}
+
+// evaluate guard expression without variables, isConst is false if it depends on log detail
+func evalConstGuard(guard string) (ok, isConst bool) {
+	guard = strings.TrimSpace(guard)
+	if guard == "" {
+		ok = true
+		isConst = true
+		return
+	}
+	expr, err := govaluate.NewEvaluableExpression(guard)
+	if err != nil || len(expr.Vars()) > 0 {
+		return
+	}
+	res, err := expr.Evaluate(nil)
+	if err != nil {
+		return
+	}
+	ok, _ = res.(bool)
+	isConst = true
+	return
+}
@@ -1,6 +1,10 @@
 package fsm
 
-import "github.com/ennismar/go-helper/ms"
+import (
+	"github.com/ennismar/go-helper/ms"
+	"github.com/ennismar/go-helper/pkg/req"
+	"github.com/golang-module/carbon/v2"
+)
 
 type Machine struct {
 	ms.M
@@ -11,14 +15,28 @@ type Machine struct {
 	SubmitterConfirm           uint    `gorm:"type:tinyint(1);default:0;comment:submitter confirm(0: no, 1: yes)" json:"submitterConfirm"`
 	SubmitterConfirmEditFields string  `gorm:"comment:submitter can edit fields when confirm" json:"submitterConfirmEditFields"`
 	EventsJson                 string  `gorm:"comment:event json str" json:"eventsJson"`
+	Version                    uint    `gorm:"default:1;comment:latest version(new log use it)" json:"version"`
 	Events                     []Event `gorm:"foreignKey:MachineId" json:"events"`
 }
 
+type MachineVersion struct {
+	ms.M
+	MachineId                  uint   `gorm:"index:idx_m_id_version,unique;" json:"machineId"`
+	Version                    uint   `gorm:"index:idx_m_id_version,unique;comment:machine version" json:"version"`
+	Name                       string `gorm:"comment:fsm name" json:"name"`
+	SubmitterName              string `gorm:"comment:submitter username or role name" json:"submitterName"`
+	SubmitterEditFields        string `gorm:"comment:submitter can edit fields" json:"submitterEditFields"`
+	SubmitterConfirm           uint   `gorm:"type:tinyint(1);default:0;comment:submitter confirm(0: no, 1: yes)" json:"submitterConfirm"`
+	SubmitterConfirmEditFields string `gorm:"comment:submitter can edit fields when confirm" json:"submitterConfirmEditFields"`
+	EventsJson                 string `gorm:"type:text;comment:event json str" json:"eventsJson"`
+}
+
 type Event struct {
 	ms.M
-	MachineId  uint        `gorm:"index:idx_m_id_sort,unique;" json:"machineId"`
+	MachineId  uint        `gorm:"index:idx_m_id_version_sort,unique;" json:"machineId"`
 	Machine    Machine     `gorm:"foreignKey:MachineId" json:"machine"`
-	Sort       uint        `gorm:"index:idx_m_id_sort,unique;comment:sort by level" json:"sort"`
+	Version    uint        `gorm:"index:idx_m_id_version_sort,unique;default:1;comment:machine version" json:"version"`
+	Sort       uint        `gorm:"index:idx_m_id_version_sort,unique;comment:sort by level" json:"sort"`
 	Level      uint        `gorm:"comment:level for query" json:"level"`
 	NameId     uint        `gorm:"comment:current event" json:"name"`
 	Name       EventItem   `gorm:"foreignKey:NameId" json:"nameId"`
@@ -29,6 +47,16 @@ type Event struct {
 	EditFields string      `gorm:"comment:approver can edit fields(split by comma, can edit all field if it empty, edit=1 take effect)" json:"editFields"`
 	Roles      []Role      `gorm:"many2many:event_role_relation;comment:approver role ids" json:"roles"`
 	Users      []User      `gorm:"many2many:event_user_relation;comment:approver user ids" json:"users"`
+	Policy     uint        `gorm:"type:tinyint(1);default:0;comment:approval policy(0: any, 1: all, 2: quorum)" json:"policy"`
+	Quorum     uint        `gorm:"default:0;comment:min approved count(policy=2 take effect)" json:"quorum"`
+	Guard      string      `gorm:"comment:guard expression over log detail json(skip this level if not matched)" json:"guard"`
+	// sla settings(unit: minute, 0: disabled)
+	RemindAfter    uint `gorm:"default:0;comment:remind approvers after x minutes" json:"remindAfter"`
+	EscalateAfter  uint `gorm:"default:0;comment:escalate to fallback approver after y minutes" json:"escalateAfter"`
+	EscalateRoleId uint `gorm:"default:0;comment:fallback approver role id" json:"escalateRoleId"`
+	EscalateUserId uint `gorm:"default:0;comment:fallback approver user id" json:"escalateUserId"`
+	TimeoutAfter   uint `gorm:"default:0;comment:auto approve/refuse after z minutes" json:"timeoutAfter"`
+	TimeoutAction  uint `gorm:"type:tinyint(1);default:0;comment:timeout action(1: approve, 2: refuse)" json:"timeoutAction"`
 }
 
 type User struct {
@@ -61,27 +89,35 @@ type EventItem struct {
 
 type Log struct {
 	ms.M
-	Category         uint      `gorm:"index:idx_category_uid_approved;comment:custom category(>0)" json:"category"`
-	Uuid             string    `gorm:"index:idx_category_uid_approved;size:36;comment:unique str" json:"uuid"`
-	Approved         uint      `gorm:"index:idx_category_uid_approved;type:tinyint(1);default:0;comment:approval status" json:"approved"`
-	ProgressId       uint      `gorm:"comment:current progress" json:"progressId"`
-	Progress         EventItem `gorm:"foreignKey:ProgressId" json:"progress"`
-	SubmitterRoleId  uint      `gorm:"comment:custom submitter role id" json:"submitterRoleId"`
-	SubmitterUserId  uint      `gorm:"comment:custom submitter user id" json:"submitterUserId"`
-	ApprovalRoleId   uint      `gorm:"comment:approver role id" json:"approvalRoleId"`
-	ApprovalUserId   uint      `gorm:"comment:approver user id" json:"approvalUserId"`
-	ApprovalOpinion  string    `gorm:"comment:approver approval opinion" json:"approvalOpinion"`
-	PrevDetail       string    `gorm:"comment:last approver detail" json:"prevDetail"`
-	Detail           string    `gorm:"comment:current approver detail" json:"detail"`
-	Remark           string    `gorm:"size:100;comment:remark(approving will use it)" json:"remark"`
-	CurrentEventId   uint      `gorm:"comment:current event id" json:"currentEventId"`
-	CurrentEvent     Event     `gorm:"foreignKey:CurrentEventId;comment:current event" json:"currentEvent"`
-	Resubmit         uint      `gorm:"type:tinyint(1);default:0;comment:waiting submitter resubmit" json:"resubmit"`
-	Confirm          uint      `gorm:"type:tinyint(1);default:0;comment:waiting submitter confirm" json:"confirm"`
-	NextEventId      uint      `gorm:"comment:next event id" json:"nextEventId"`
-	NextEvent        Event     `gorm:"foreignKey:NextEventId;comment:next event" json:"nextEvent"`
-	CanApprovalRoles []Role    `gorm:"many2many:log_approval_role_relation;comment:can approve roles" json:"canApprovalRoles"`
-	CanApprovalUsers []User    `gorm:"many2many:log_approval_user_relation;comment:can approve users" json:"canApprovalUsers"`
+	Category         uint        `gorm:"index:idx_category_uid_approved;comment:custom category(>0)" json:"category"`
+	Uuid             string      `gorm:"index:idx_category_uid_approved;size:36;comment:unique str" json:"uuid"`
+	Approved         uint        `gorm:"index:idx_category_uid_approved;type:tinyint(1);default:0;comment:approval status" json:"approved"`
+	MachineId        uint        `gorm:"comment:machine id when submitted" json:"machineId"`
+	Version          uint        `gorm:"default:1;comment:machine version when submitted(log always finish on it)" json:"version"`
+	ProgressId       uint        `gorm:"comment:current progress" json:"progressId"`
+	Progress         EventItem   `gorm:"foreignKey:ProgressId" json:"progress"`
+	SubmitterRoleId  uint        `gorm:"comment:custom submitter role id" json:"submitterRoleId"`
+	SubmitterUserId  uint        `gorm:"comment:custom submitter user id" json:"submitterUserId"`
+	ApprovalRoleId   uint        `gorm:"comment:approver role id" json:"approvalRoleId"`
+	ApprovalUserId   uint        `gorm:"comment:approver user id" json:"approvalUserId"`
+	ApprovalOpinion  string      `gorm:"comment:approver approval opinion" json:"approvalOpinion"`
+	DelegatorRoleId  uint        `gorm:"comment:original approver role id(approved by delegate)" json:"delegatorRoleId"`
+	DelegatorUserId  uint        `gorm:"comment:original approver user id(approved by delegate)" json:"delegatorUserId"`
+	PrevDetail       string      `gorm:"comment:last approver detail" json:"prevDetail"`
+	Detail           string      `gorm:"comment:current approver detail" json:"detail"`
+	Remark           string      `gorm:"size:100;comment:remark(approving will use it)" json:"remark"`
+	DetailJson       string      `gorm:"type:text;comment:submitted detail json(guard expressions use it)" json:"detailJson"`
+	DetailDiff       string      `gorm:"type:text;comment:detail json per-field diff changed by approver" json:"detailDiff"`
+	CurrentEventId   uint        `gorm:"comment:current event id" json:"currentEventId"`
+	CurrentEvent     Event       `gorm:"foreignKey:CurrentEventId;comment:current event" json:"currentEvent"`
+	Resubmit         uint        `gorm:"type:tinyint(1);default:0;comment:waiting submitter resubmit" json:"resubmit"`
+	Confirm          uint        `gorm:"type:tinyint(1);default:0;comment:waiting submitter confirm" json:"confirm"`
+	NextEventId      uint        `gorm:"comment:next event id" json:"nextEventId"`
+	NextEvent        Event       `gorm:"foreignKey:NextEventId;comment:next event" json:"nextEvent"`
+	CanApprovalRoles []Role      `gorm:"many2many:log_approval_role_relation;comment:can approve roles" json:"canApprovalRoles"`
+	CanApprovalUsers []User      `gorm:"many2many:log_approval_user_relation;comment:can approve users" json:"canApprovalUsers"`
+	Votes            []LogVote   `gorm:"foreignKey:LogId" json:"votes"`
+	Actions          []LogAction `gorm:"foreignKey:LogId" json:"actions"`
 }
 
 type LogApprovalRoleRelation struct {
@@ -93,3 +129,47 @@ type LogApprovalUserRelation struct {
 	LogId  uint `json:"logId"`
 	UserId uint `json:"userId"`
 }
+
+type LogVote struct {
+	ms.M
+	LogId           uint   `gorm:"index:idx_log_id;comment:log id" json:"logId"`
+	ApprovalRoleId  uint   `gorm:"comment:approver role id" json:"approvalRoleId"`
+	ApprovalUserId  uint   `gorm:"comment:approver user id" json:"approvalUserId"`
+	DelegatorRoleId uint   `gorm:"comment:original approver role id(approved by delegate)" json:"delegatorRoleId"`
+	DelegatorUserId uint   `gorm:"comment:original approver user id(approved by delegate)" json:"delegatorUserId"`
+	Approved        uint   `gorm:"type:tinyint(1);default:0;comment:approval status" json:"approved"`
+	ApprovalOpinion string `gorm:"comment:approver approval opinion" json:"approvalOpinion"`
+	DetailDiff      string `gorm:"type:text;comment:detail json per-field diff changed by voter" json:"detailDiff"`
+}
+
+type LogAction struct {
+	ms.M
+	LogId  uint   `gorm:"index:idx_log_id;comment:log id" json:"logId"`
+	Actor  string `gorm:"size:50;comment:action actor(system: automatic action)" json:"actor"`
+	Action uint   `gorm:"type:tinyint(1);default:0;comment:action(0: remind, 1: escalate, 2: timeout)" json:"action"`
+	Detail string `gorm:"comment:action detail" json:"detail"`
+}
+
+type Outbox struct {
+	ms.M
+	DedupKey    string          `gorm:"index:idx_dedup_key,unique;size:100;comment:dedup key(consumer should ignore repeat key)" json:"dedupKey"`
+	Event       string          `gorm:"size:20;comment:event(submit/approve/reject/cancel/resubmit/confirm)" json:"event"`
+	Category    uint            `gorm:"comment:custom category(>0)" json:"category"`
+	Uuid        string          `gorm:"size:36;comment:unique str" json:"uuid"`
+	LogId       uint            `gorm:"comment:log id" json:"logId"`
+	Payload     string          `gorm:"type:text;comment:event json" json:"payload"`
+	Status      uint            `gorm:"index:idx_status;type:tinyint(1);default:0;comment:status(0: pending, 1: published)" json:"status"`
+	Retry       uint            `gorm:"default:0;comment:publish retry count" json:"retry"`
+	LastError   string          `gorm:"type:text;comment:last publish error" json:"lastError"`
+	PublishedAt carbon.DateTime `gorm:"comment:published time" json:"publishedAt"`
+}
+
+type Delegation struct {
+	ms.M
+	FromRoleId uint            `gorm:"comment:delegator role id(optional)" json:"fromRoleId"`
+	FromUserId uint            `gorm:"index:idx_from_user_id;comment:delegator user id" json:"fromUserId"`
+	ToUserId   uint            `gorm:"index:idx_to_user_id;comment:delegate user id" json:"toUserId"`
+	StartAt    carbon.DateTime `gorm:"comment:start time" json:"startAt"`
+	EndAt      carbon.DateTime `gorm:"comment:end time" json:"endAt"`
+	Categories req.IdsStr      `gorm:"comment:only delegate these categories(split by comma, all categories if it empty)" json:"categories"`
+}
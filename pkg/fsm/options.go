@@ -3,6 +3,7 @@ package fsm
 import (
 	"context"
 	"github.com/ennismar/go-helper/pkg/constant"
+	"github.com/ennismar/go-helper/pkg/delay"
 	"github.com/ennismar/go-helper/pkg/resp"
 	"github.com/ennismar/go-helper/pkg/utils"
 	"gorm.io/gorm"
@@ -13,6 +14,10 @@ type Options struct {
 	db         *gorm.DB
 	prefix     string
 	transition func(ctx context.Context, logs ...resp.FsmApprovalLog) error
+	queue      *delay.Queue
+	reminder   func(ctx context.Context, logs ...resp.FsmSlaLog) error
+	outbox     bool
+	relayBatch int
 }
 
 func WithCtx(ctx context.Context) func(*Options) {
@@ -43,11 +48,46 @@ func WithTransition(fun func(ctx context.Context, logs ...resp.FsmApprovalLog) e
 	}
 }
 
+// WithQueue delay queue for sla(remind/escalate/timeout) tasks, if db is a transaction call FlushSla after commit
+func WithQueue(qu *delay.Queue) func(*Options) {
+	return func(options *Options) {
+		if qu != nil {
+			getOptionsOrSetDefault(options).queue = qu
+		}
+	}
+}
+
+// WithReminder notify approvers when sla remind/escalate
+func WithReminder(fun func(ctx context.Context, logs ...resp.FsmSlaLog) error) func(*Options) {
+	return func(options *Options) {
+		if fun != nil {
+			getOptionsOrSetDefault(options).reminder = fun
+		}
+	}
+}
+
+// WithOutbox save domain events to outbox table in the same transaction as logs
+func WithOutbox(flag bool) func(*Options) {
+	return func(options *Options) {
+		getOptionsOrSetDefault(options).outbox = flag
+	}
+}
+
+// WithRelayBatch max outbox events published by one relay pass
+func WithRelayBatch(count int) func(*Options) {
+	return func(options *Options) {
+		if count > 0 {
+			getOptionsOrSetDefault(options).relayBatch = count
+		}
+	}
+}
+
 func getOptionsOrSetDefault(options *Options) *Options {
 	if options == nil {
 		return &Options{
-			ctx:    getCtx(nil),
-			prefix: constant.FsmPrefix,
+			ctx:        getCtx(nil),
+			prefix:     constant.FsmPrefix,
+			relayBatch: 100,
 			transition: func(ctx context.Context, logs ...resp.FsmApprovalLog) error {
 				return nil
 			},
@@ -0,0 +1,114 @@
+package fsm
+
+import (
+	"context"
+	"fmt"
+	"github.com/ennismar/go-helper/pkg/constant"
+	"github.com/ennismar/go-helper/pkg/i18n"
+	"github.com/ennismar/go-helper/pkg/log"
+	"github.com/ennismar/go-helper/pkg/mq"
+	"github.com/ennismar/go-helper/pkg/resp"
+	"github.com/ennismar/go-helper/pkg/utils"
+	"github.com/golang-module/carbon/v2"
+	"github.com/streadway/amqp"
+	"gorm.io/gorm"
+	"time"
+)
+
+// RelayOutbox publish pending outbox events by ex.PublishJson in id order, return published count.
+// delivery is at-least-once, the event is marked published only after broker confirmed,
+// consumers should ignore repeat x-dedup-key header(or FsmOutboxEvent.DedupKey)
+func (fs *Fsm) RelayOutbox(ex *mq.Exchange, options ...func(*mq.PublishOptions)) (count int) {
+	if fs.Error != nil {
+		return
+	}
+	if ex == nil {
+		fs.AddError(i18n.E(ErrExchangeEmpty))
+		return
+	}
+	list := make([]Outbox, 0)
+	fs.session.
+		Model(&Outbox{}).
+		Where("status = ?", constant.FsmOutboxStatusPending).
+		Order("id").
+		Limit(fs.ops.relayBatch).
+		Find(&list)
+	for _, item := range list {
+		// headers are set at last, do not pass WithPublishHeaders
+		ops := make([]func(*mq.PublishOptions), 0, len(options)+2)
+		ops = append(ops, options...)
+		ops = append(ops,
+			mq.WithPublishContentType("application/json"),
+			mq.WithPublishHeaders(amqp.Table{
+				constant.FsmOutboxDedupHeader: item.DedupKey,
+				constant.FsmOutboxEventHeader: item.Event,
+			}),
+		)
+		err := ex.PublishJson(item.Payload, ops...)
+		if err != nil {
+			m := make(map[string]interface{}, 0)
+			m["retry"] = gorm.Expr("retry + ?", constant.One)
+			m["last_error"] = err.Error()
+			fs.session.
+				Model(&Outbox{}).
+				Where("id = ?", item.Id).
+				Updates(&m)
+			// stop here to keep event order, retry next pass
+			fs.AddError(err)
+			return
+		}
+		m := make(map[string]interface{}, 0)
+		m["status"] = constant.FsmOutboxStatusPublished
+		m["published_at"] = carbon.DateTime{Carbon: carbon.Now()}
+		fs.session.
+			Model(&Outbox{}).
+			Where("id = ?", item.Id).
+			Updates(&m)
+		count++
+	}
+	return
+}
+
+// StartOutboxRelay relay outbox events every interval until ctx done, it blocks so run it in a goroutine
+func (fs *Fsm) StartOutboxRelay(ctx context.Context, ex *mq.Exchange, interval time.Duration, options ...func(*mq.PublishOptions)) {
+	if interval <= 0 {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for {
+			count := fs.RelayOutbox(ex, options...)
+			if fs.Error != nil {
+				log.WithContext(fs.ops.ctx).Warn("relay fsm outbox failed: %v", fs.Error)
+				fs.Error = nil
+				break
+			}
+			if count < fs.ops.relayBatch {
+				break
+			}
+		}
+	}
+}
+
+// save domain event to outbox, fs.session is shared with logs so they are in the same transaction
+func (fs *Fsm) addOutbox(event resp.FsmOutboxEvent) {
+	if fs.Error != nil || !fs.ops.outbox {
+		return
+	}
+	event.DedupKey = fmt.Sprintf("fsm.%d.%s", event.LogId, event.Event)
+	event.CreatedAt = carbon.DateTime{Carbon: carbon.Now()}
+	fs.AddError(fs.session.Create(&Outbox{
+		DedupKey: event.DedupKey,
+		Event:    event.Event,
+		Category: event.Category,
+		Uuid:     event.Uuid,
+		LogId:    event.LogId,
+		Payload:  utils.Struct2Json(event),
+	}).Error)
+}
@@ -0,0 +1,262 @@
+package fsm
+
+import (
+	"fmt"
+	"github.com/ennismar/go-helper/pkg/constant"
+	"github.com/ennismar/go-helper/pkg/i18n"
+	"github.com/ennismar/go-helper/pkg/req"
+	"github.com/ennismar/go-helper/pkg/utils"
+	"gorm.io/gorm"
+	"sort"
+	"strings"
+)
+
+// graph is the common structure of dot/mermaid renderers
+type graph struct {
+	name    string
+	start   string
+	states  []string
+	edges   []graphEdge
+	done    []string
+	current string
+	pending string
+}
+
+type graphEdge struct {
+	src   string
+	dst   string
+	event string
+	done  bool
+}
+
+// RenderMachine render machine as graphviz dot or mermaid state diagram(r.Version=0: latest version)
+func (fs *Fsm) RenderMachine(r req.FsmRender) (rp string) {
+	if fs.Error != nil {
+		return
+	}
+	machine := fs.GetMachineByCategory(uint(r.Category))
+	if machine.Id == constant.Zero {
+		fs.AddError(i18n.E(ErrMachineNotFound))
+		return
+	}
+	version := machine.Version
+	if r.Version > constant.Zero {
+		version = r.Version
+	}
+	g := fs.newGraph(machine, version)
+	if fs.Error != nil {
+		return
+	}
+	rp = g.render(r.Format)
+	return
+}
+
+// RenderLog render log progress on its machine version, highlight completed path, current progress and pending approvers
+func (fs *Fsm) RenderLog(r req.FsmRender) (rp string) {
+	if fs.Error != nil {
+		return
+	}
+	logs := fs.FindLog(req.FsmLog{
+		Category: r.Category,
+		Uuid:     r.Uuid,
+	})
+	if fs.Error != nil {
+		return
+	}
+	if len(logs) == 0 {
+		fs.AddError(gorm.ErrRecordNotFound)
+		return
+	}
+	// sort by id, the last one is current node
+	sort.Slice(logs, func(i, j int) bool { return logs[i].Id < logs[j].Id })
+	last := logs[len(logs)-1]
+	machine := fs.getLogMachine(last)
+	if machine.Id == constant.Zero {
+		fs.AddError(i18n.E(ErrMachineNotFound))
+		return
+	}
+	g := fs.newGraph(machine, last.Version)
+	if fs.Error != nil {
+		return
+	}
+	// progress names of each log
+	ids := make([]uint, 0)
+	for _, item := range logs {
+		ids = append(ids, item.ProgressId)
+	}
+	items := make([]EventItem, 0)
+	fs.session.
+		Model(&EventItem{}).
+		Where("id IN (?)", ids).
+		Find(&items)
+	names := make(map[uint]string, len(items))
+	for _, item := range items {
+		names[item.Id] = item.Name
+	}
+	for i, item := range logs {
+		name := names[item.ProgressId]
+		if !utils.Contains(g.done, name) {
+			g.done = append(g.done, name)
+		}
+		if i == 0 {
+			continue
+		}
+		prev := names[logs[i-1].ProgressId]
+		for j := range g.edges {
+			if g.edges[j].src == prev && g.edges[j].dst == name {
+				g.edges[j].done = true
+			}
+		}
+	}
+	if last.Approved == constant.FsmLogStatusWaiting {
+		g.current = names[last.ProgressId]
+		roles, users := getPendingApprovers(last)
+		pending := make([]string, 0)
+		for _, item := range roles {
+			pending = append(pending, fmt.Sprintf("role %d", item.Id))
+		}
+		for _, item := range users {
+			pending = append(pending, fmt.Sprintf("user %d", item.Id))
+		}
+		g.pending = strings.Join(pending, ", ")
+	}
+	rp = g.render(r.Format)
+	return
+}
+
+func (fs *Fsm) newGraph(machine Machine, version uint) (g graph) {
+	desc := fs.findEventDesc(machine.Id, version)
+	if fs.Error != nil {
+		return
+	}
+	if len(desc) == 0 {
+		fs.AddError(i18n.E(ErrMachineVersionNotFound))
+		return
+	}
+	g.name = machine.Name
+	g.start = desc[0].Dst
+	g.states = []string{g.start}
+	g.done = make([]string, 0)
+	for _, d := range desc {
+		for _, src := range d.Src {
+			g.edges = append(g.edges, graphEdge{
+				src:   src,
+				dst:   d.Dst,
+				event: d.Name,
+			})
+			if !utils.Contains(g.states, src) {
+				g.states = append(g.states, src)
+			}
+		}
+		if !utils.Contains(g.states, d.Dst) {
+			g.states = append(g.states, d.Dst)
+		}
+	}
+	return
+}
+
+// the state has no available transitions
+func (g graph) isEnd(state string) bool {
+	for _, item := range g.edges {
+		if item.src == state {
+			return false
+		}
+	}
+	return true
+}
+
+func (g graph) render(format string) string {
+	if strings.EqualFold(strings.TrimSpace(format), constant.FsmRenderFormatMermaid) {
+		return g.mermaid()
+	}
+	return g.dot()
+}
+
+func (g graph) dot() string {
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("digraph %s {\n", dotQuote(g.name)))
+	b.WriteString("  rankdir=LR;\n")
+	b.WriteString("  node [shape=box, style=rounded];\n")
+	for _, state := range g.states {
+		attrs := make([]string, 0)
+		label := state
+		if g.isEnd(state) {
+			attrs = append(attrs, "peripheries=2")
+		}
+		if state == g.current {
+			attrs = append(attrs, `style="rounded,filled"`, "fillcolor=gold")
+			if g.pending != "" {
+				label = fmt.Sprintf("%s\npending: %s", state, g.pending)
+			}
+		} else if utils.Contains(g.done, state) {
+			attrs = append(attrs, `style="rounded,filled"`, "fillcolor=palegreen")
+		}
+		attrs = append([]string{fmt.Sprintf("label=%s", dotQuote(label))}, attrs...)
+		b.WriteString(fmt.Sprintf("  %s [%s];\n", dotQuote(state), strings.Join(attrs, ", ")))
+	}
+	for _, edge := range g.edges {
+		attrs := []string{fmt.Sprintf("label=%s", dotQuote(edge.event))}
+		if edge.done {
+			attrs = append(attrs, "color=green", "penwidth=2")
+		}
+		b.WriteString(fmt.Sprintf("  %s -> %s [%s];\n", dotQuote(edge.src), dotQuote(edge.dst), strings.Join(attrs, ", ")))
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+func (g graph) mermaid() string {
+	// mermaid state id can not contain spaces, use s0/s1/... as id
+	ids := make(map[string]string, len(g.states))
+	for i, state := range g.states {
+		ids[state] = fmt.Sprintf("s%d", i)
+	}
+	var b strings.Builder
+	b.WriteString("stateDiagram-v2\n")
+	if g.name != "" {
+		b.WriteString(fmt.Sprintf("  %%%% %s\n", g.name))
+	}
+	for _, state := range g.states {
+		b.WriteString(fmt.Sprintf("  %s : %s\n", ids[state], mermaidEscape(state)))
+	}
+	b.WriteString(fmt.Sprintf("  [*] --> %s\n", ids[g.start]))
+	for _, edge := range g.edges {
+		b.WriteString(fmt.Sprintf("  %s --> %s : %s\n", ids[edge.src], ids[edge.dst], mermaidEscape(edge.event)))
+	}
+	for _, state := range g.states {
+		if g.isEnd(state) {
+			b.WriteString(fmt.Sprintf("  %s --> [*]\n", ids[state]))
+		}
+	}
+	done := make([]string, 0)
+	for _, state := range g.done {
+		if state != g.current && ids[state] != "" {
+			done = append(done, ids[state])
+		}
+	}
+	if len(done) > 0 {
+		b.WriteString("  classDef done fill:#98fb98\n")
+		b.WriteString(fmt.Sprintf("  class %s done\n", strings.Join(done, ",")))
+	}
+	if g.current != "" && ids[g.current] != "" {
+		b.WriteString("  classDef current fill:#ffd700\n")
+		b.WriteString(fmt.Sprintf("  class %s current\n", ids[g.current]))
+		if g.pending != "" {
+			b.WriteString(fmt.Sprintf("  note right of %s : pending %s\n", ids[g.current], mermaidEscape(g.pending)))
+		}
+	}
+	return b.String()
+}
+
+func dotQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	return fmt.Sprintf(`"%s"`, s)
+}
+
+func mermaidEscape(s string) string {
+	s = strings.ReplaceAll(s, ":", "#58;")
+	s = strings.ReplaceAll(s, "\n", " ")
+	return s
+}
@@ -0,0 +1,308 @@
+package fsm
+
+import (
+	"github.com/ennismar/go-helper/pkg/constant"
+	"github.com/ennismar/go-helper/pkg/i18n"
+	"github.com/ennismar/go-helper/pkg/req"
+	"github.com/ennismar/go-helper/pkg/resp"
+	"github.com/golang-module/carbon/v2"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"math"
+	"sort"
+	"strings"
+)
+
+// reportBatch logs loaded by one batch, keep memory bounded for a long date range
+const reportBatch = 500
+
+type reportRange struct {
+	category *req.NullUint
+	start    carbon.Carbon
+	end      carbon.Carbon
+}
+
+// Report approval statistics: daily throughput, median/p95 time and rejection rate per level/approver, current backlog per role.
+// level/approver statistics only include approval levels(submitter resubmit/confirm are excluded),
+// a countersign/quorum level counts every vote as one approver action
+func (fs *Fsm) Report(r req.FsmReport) (rp resp.FsmReport) {
+	rp.Throughput = make([]resp.FsmReportThroughput, 0)
+	rp.Levels = make([]resp.FsmReportLevel, 0)
+	rp.Approvers = make([]resp.FsmReportApprover, 0)
+	rp.Backlog = make([]resp.FsmReportBacklog, 0)
+	if fs.Error != nil {
+		return
+	}
+	rg := fs.parseReportRange(r)
+	if fs.Error != nil {
+		return
+	}
+	rp.Throughput = fs.reportThroughput(rg)
+	rp.Levels, rp.Approvers = fs.reportDuration(rg)
+	rp.Backlog = fs.reportBacklog(rg)
+	return
+}
+
+func (fs *Fsm) parseReportRange(r req.FsmReport) (rg reportRange) {
+	rg.category = r.Category
+	startTime := strings.TrimSpace(r.StartTime)
+	endTime := strings.TrimSpace(r.EndTime)
+	if startTime != "" {
+		rg.start = carbon.Parse(startTime)
+		if rg.start.Error != nil || rg.start.IsZero() {
+			fs.AddError(errors.Wrap(i18n.E(ErrParams), "startTime"))
+			return
+		}
+	}
+	if endTime != "" {
+		rg.end = carbon.Parse(endTime)
+		if rg.end.Error != nil || rg.end.IsZero() {
+			fs.AddError(errors.Wrap(i18n.E(ErrParams), "endTime"))
+			return
+		}
+		if len(endTime) <= len("2006-01-02") {
+			rg.end = rg.end.EndOfDay()
+		}
+	}
+	return
+}
+
+// filter by category and column(created_at/updated_at) time range
+func (rg reportRange) scope(column string) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if rg.category != nil {
+			db = db.Where("category = ?", *rg.category)
+		}
+		if !rg.start.IsZero() {
+			db = db.Where(column+" >= ?", rg.start.ToDateTimeString())
+		}
+		if !rg.end.IsZero() {
+			db = db.Where(column+" <= ?", rg.end.ToDateTimeString())
+		}
+		return db
+	}
+}
+
+func (fs *Fsm) reportThroughput(rg reportRange) (rp []resp.FsmReportThroughput) {
+	rp = make([]resp.FsmReportThroughput, 0)
+	days := make(map[string]*resp.FsmReportThroughput)
+	day := func(t carbon.DateTime) *resp.FsmReportThroughput {
+		date := t.Carbon.ToDateString()
+		if _, ok := days[date]; !ok {
+			days[date] = &resp.FsmReportThroughput{
+				Date: date,
+			}
+		}
+		return days[date]
+	}
+	// the first log of each uuid is the submission
+	submitted := make([]Log, 0)
+	q := fs.session.
+		Model(&Log{}).
+		Select("category, uuid, MIN(created_at) AS created_at").
+		Group("category, uuid")
+	if rg.category != nil {
+		q.Where("category = ?", *rg.category)
+	}
+	if !rg.start.IsZero() {
+		q.Having("MIN(created_at) >= ?", rg.start.ToDateTimeString())
+	}
+	if !rg.end.IsZero() {
+		q.Having("MIN(created_at) <= ?", rg.end.ToDateTimeString())
+	}
+	q.Find(&submitted)
+	for _, item := range submitted {
+		day(item.CreatedAt).Submitted++
+	}
+	ended := make([]carbon.DateTime, 0)
+	fs.session.
+		Model(&Log{}).
+		Scopes(rg.scope("created_at")).
+		Where("approved = ?", constant.FsmLogStatusApproved).
+		Where("next_event_id = ?", constant.Zero).
+		Pluck("created_at", &ended)
+	for _, item := range ended {
+		day(item).Ended++
+	}
+	cancelled := make([]carbon.DateTime, 0)
+	fs.session.
+		Model(&Log{}).
+		Scopes(rg.scope("updated_at")).
+		Where("approved = ?", constant.FsmLogStatusCancelled).
+		Pluck("updated_at", &cancelled)
+	for _, item := range cancelled {
+		day(item).Cancelled++
+	}
+	for _, item := range days {
+		rp = append(rp, *item)
+	}
+	sort.Slice(rp, func(i, j int) bool { return rp[i].Date < rp[j].Date })
+	return
+}
+
+type reportSample struct {
+	seconds []int64
+	refused int64
+}
+
+func (s *reportSample) add(seconds int64, approved uint) {
+	if seconds < 0 {
+		seconds = 0
+	}
+	s.seconds = append(s.seconds, seconds)
+	if approved == constant.FsmLogStatusRefused {
+		s.refused++
+	}
+}
+
+func (s reportSample) duration() (rp resp.FsmReportDuration) {
+	rp.Count = int64(len(s.seconds))
+	rp.Refused = s.refused
+	if rp.Count == 0 {
+		return
+	}
+	rp.RejectionRate = math.Round(float64(s.refused)/float64(rp.Count)*10000) / 10000
+	sort.Slice(s.seconds, func(i, j int) bool { return s.seconds[i] < s.seconds[j] })
+	rp.Median = percentile(s.seconds, 50)
+	rp.P95 = percentile(s.seconds, 95)
+	return
+}
+
+func (fs *Fsm) reportDuration(rg reportRange) (levels []resp.FsmReportLevel, approvers []resp.FsmReportApprover) {
+	levels = make([]resp.FsmReportLevel, 0)
+	approvers = make([]resp.FsmReportApprover, 0)
+	levelKeys := make([]resp.FsmReportLevel, 0)
+	levelSamples := make(map[[2]uint]*reportSample)
+	approverKeys := make([]resp.FsmReportApprover, 0)
+	approverSamples := make(map[[2]uint]*reportSample)
+	list := make([]Log, 0)
+	fs.session.
+		Model(&Log{}).
+		Preload("NextEvent").
+		Preload("NextEvent.Name").
+		Preload("Votes").
+		Scopes(rg.scope("created_at")).
+		Where("approved IN (?)", []uint{constant.FsmLogStatusApproved, constant.FsmLogStatusRefused}).
+		Where("next_event_id > ?", constant.Zero).
+		Where("resubmit = ?", constant.Zero).
+		Where("confirm = ?", constant.Zero).
+		FindInBatches(&list, reportBatch, func(tx *gorm.DB, batch int) error {
+			for _, item := range list {
+				key := [2]uint{item.Category, item.NextEvent.Level}
+				if _, ok := levelSamples[key]; !ok {
+					levelSamples[key] = &reportSample{}
+					levelKeys = append(levelKeys, resp.FsmReportLevel{
+						Category: item.Category,
+						Level:    item.NextEvent.Level,
+						Name:     item.NextEvent.Name.Name,
+					})
+				}
+				levelSamples[key].add(item.CreatedAt.Carbon.DiffInSeconds(item.UpdatedAt.Carbon), item.Approved)
+				actions := item.Votes
+				if len(actions) == 0 {
+					actions = []LogVote{
+						{
+							ApprovalRoleId: item.ApprovalRoleId,
+							ApprovalUserId: item.ApprovalUserId,
+							Approved:       item.Approved,
+						},
+					}
+					actions[0].CreatedAt = item.UpdatedAt
+				}
+				for _, action := range actions {
+					key := [2]uint{action.ApprovalRoleId, action.ApprovalUserId}
+					if _, ok := approverSamples[key]; !ok {
+						approverSamples[key] = &reportSample{}
+						approverKeys = append(approverKeys, resp.FsmReportApprover{
+							ApprovalRoleId: action.ApprovalRoleId,
+							ApprovalUserId: action.ApprovalUserId,
+						})
+					}
+					approverSamples[key].add(item.CreatedAt.Carbon.DiffInSeconds(action.CreatedAt.Carbon), action.Approved)
+				}
+			}
+			return nil
+		})
+	for _, item := range levelKeys {
+		item.FsmReportDuration = levelSamples[[2]uint{item.Category, item.Level}].duration()
+		levels = append(levels, item)
+	}
+	sort.Slice(levels, func(i, j int) bool {
+		if levels[i].Category != levels[j].Category {
+			return levels[i].Category < levels[j].Category
+		}
+		return levels[i].Level < levels[j].Level
+	})
+	for _, item := range approverKeys {
+		item.FsmReportDuration = approverSamples[[2]uint{item.ApprovalRoleId, item.ApprovalUserId}].duration()
+		approvers = append(approvers, item)
+	}
+	// the slowest approver first
+	sort.SliceStable(approvers, func(i, j int) bool { return approvers[i].Median > approvers[j].Median })
+	return
+}
+
+// pending logs per approver role, range only filters category(backlog is the current snapshot)
+func (fs *Fsm) reportBacklog(rg reportRange) (rp []resp.FsmReportBacklog) {
+	rp = make([]resp.FsmReportBacklog, 0)
+	pending := make([]Log, 0)
+	q := fs.session.
+		Model(&Log{}).
+		Select("id, created_at").
+		Where("approved = ?", constant.FsmLogStatusWaiting).
+		Where("next_event_id > ?", constant.Zero)
+	if rg.category != nil {
+		q.Where("category = ?", *rg.category)
+	}
+	q.Find(&pending)
+	if len(pending) == 0 {
+		return
+	}
+	createdAt := make(map[uint]carbon.DateTime, len(pending))
+	ids := make([]uint, 0, len(pending))
+	for _, item := range pending {
+		createdAt[item.Id] = item.CreatedAt
+		ids = append(ids, item.Id)
+	}
+	relations := make([]LogApprovalRoleRelation, 0)
+	fs.session.
+		Model(&LogApprovalRoleRelation{}).
+		Where("log_id IN (?)", ids).
+		Where("role_id > ?", constant.Zero).
+		Find(&relations)
+	roles := make(map[uint]*resp.FsmReportBacklog)
+	now := carbon.Now()
+	for _, item := range relations {
+		if _, ok := roles[item.RoleId]; !ok {
+			roles[item.RoleId] = &resp.FsmReportBacklog{
+				RoleId: item.RoleId,
+			}
+		}
+		roles[item.RoleId].Count++
+		if seconds := createdAt[item.LogId].Carbon.DiffInSeconds(now); seconds > roles[item.RoleId].Oldest {
+			roles[item.RoleId].Oldest = seconds
+		}
+	}
+	for _, item := range roles {
+		rp = append(rp, *item)
+	}
+	sort.Slice(rp, func(i, j int) bool {
+		if rp[i].Count != rp[j].Count {
+			return rp[i].Count > rp[j].Count
+		}
+		return rp[i].RoleId < rp[j].RoleId
+	})
+	return
+}
+
+// nearest-rank percentile of sorted seconds
+func percentile(sorted []int64, p float64) int64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
@@ -0,0 +1,241 @@
+package fsm
+
+import (
+	"fmt"
+	"github.com/ennismar/go-helper/pkg/constant"
+	"github.com/ennismar/go-helper/pkg/delay"
+	"github.com/ennismar/go-helper/pkg/i18n"
+	"github.com/ennismar/go-helper/pkg/log"
+	"github.com/ennismar/go-helper/pkg/req"
+	"github.com/ennismar/go-helper/pkg/resp"
+	"github.com/ennismar/go-helper/pkg/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"strings"
+	"time"
+)
+
+type slaPayload struct {
+	LogId  uint `json:"logId"`
+	Action uint `json:"action"`
+}
+
+type slaTask struct {
+	slaPayload
+	in time.Duration
+}
+
+// IsSlaTask check whether the delay queue task is created by fsm sla
+func IsSlaTask(t delay.Task) bool {
+	return strings.HasPrefix(t.Name, constant.FsmSlaTaskName)
+}
+
+// HandleSlaTask process sla task in its own transaction, call it in delay.WithQueueHandler:
+//
+//	if fsm.IsSlaTask(t) {
+//	  f := fsm.New(fsm.WithDb(db), fsm.WithQueue(qu))
+//	  f.HandleSlaTask(t)
+//	  return f.Error
+//	}
+//
+// sla tasks of the next level are enqueued after commit(call FlushSla after commit if db is a transaction)
+func (fs *Fsm) HandleSlaTask(t delay.Task) {
+	if fs.Error != nil {
+		return
+	}
+	session := fs.session
+	err := session.Transaction(func(tx *gorm.DB) error {
+		fs.session = tx
+		fs.handleSla(t)
+		return fs.Error
+	})
+	fs.session = session
+	if err != nil {
+		// rollback, the tasks will never be needed
+		fs.sla = nil
+		fs.AddError(err)
+		return
+	}
+	if !inTx(fs.session) {
+		fs.FlushSla()
+	}
+	return
+}
+
+func (fs *Fsm) handleSla(t delay.Task) {
+	var p slaPayload
+	utils.Json2Struct(t.Payload, &p)
+	var l Log
+	fs.session.
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Model(&Log{}).
+		Preload("CanApprovalRoles").
+		Preload("CanApprovalUsers").
+		Preload("NextEvent").
+		Preload("Votes").
+		Where("id = ?", p.LogId).
+		Where("approved = ?", constant.FsmLogStatusWaiting).
+		First(&l)
+	if l.Id == constant.Zero {
+		// the log has been approved/cancelled, nothing to do
+		return
+	}
+	switch p.Action {
+	case constant.FsmSlaActionRemind:
+		fs.remind(l)
+	case constant.FsmSlaActionEscalate:
+		fs.escalate(l)
+	case constant.FsmSlaActionTimeout:
+		fs.timeout(l)
+	}
+	return
+}
+
+// FlushSla enqueue sla tasks scheduled in transaction, call it after the transaction committed
+// (enqueued at once if db is not a transaction)
+func (fs *Fsm) FlushSla() {
+	if fs.Error != nil {
+		return
+	}
+	if len(fs.sla) == 0 {
+		return
+	}
+	if fs.ops.queue == nil {
+		log.WithContext(fs.ops.ctx).Warn("%s", i18n.T(ErrQueueEmpty))
+		fs.sla = nil
+		return
+	}
+	for len(fs.sla) > 0 {
+		item := fs.sla[0]
+		err := fs.ops.queue.Once(
+			delay.WithQueueTaskUuid(fmt.Sprintf("%s.%d.%d", constant.FsmSlaTaskName, item.LogId, item.Action)),
+			delay.WithQueueTaskName(constant.FsmSlaTaskName),
+			delay.WithQueueTaskPayload(utils.Struct2Json(item.slaPayload)),
+			delay.WithQueueTaskIn(item.in),
+		)
+		if fs.AddError(err) != nil {
+			return
+		}
+		fs.sla = fs.sla[1:]
+	}
+	return
+}
+
+// schedule sla tasks of the next event, they are enqueued after commit
+// since task may run before the log is visible(or the transaction is rollback)
+func (fs *Fsm) scheduleSla(logId uint, event Event) {
+	if fs.Error != nil {
+		return
+	}
+	actions := map[uint]uint{
+		constant.FsmSlaActionRemind:   event.RemindAfter,
+		constant.FsmSlaActionEscalate: event.EscalateAfter,
+		constant.FsmSlaActionTimeout:  event.TimeoutAfter,
+	}
+	for action, after := range actions {
+		if after == constant.Zero {
+			continue
+		}
+		fs.sla = append(fs.sla, slaTask{
+			slaPayload: slaPayload{
+				LogId:  logId,
+				Action: action,
+			},
+			in: time.Duration(after) * time.Minute,
+		})
+	}
+	if !inTx(fs.session) {
+		fs.FlushSla()
+	}
+	return
+}
+
+// inTx check whether session is a transaction
+func inTx(session *gorm.DB) bool {
+	_, ok := session.Statement.ConnPool.(gorm.TxCommitter)
+	return ok
+}
+
+func (fs *Fsm) remind(l Log) {
+	pendingRoles, pendingUsers := getPendingApprovers(l)
+	fs.addSystemAction(l.Id, constant.FsmSlaActionRemind, i18n.T(constant.FsmMsgAutoRemind))
+	fs.notify(l, constant.FsmSlaActionRemind, pendingRoles, pendingUsers)
+}
+
+func (fs *Fsm) escalate(l Log) {
+	roles := make([]Role, 0)
+	users := make([]User, 0)
+	if l.NextEvent.EscalateRoleId > constant.Zero {
+		roles = fs.findRole([]uint{l.NextEvent.EscalateRoleId})
+	}
+	if l.NextEvent.EscalateUserId > constant.Zero {
+		users = fs.findUser([]uint{l.NextEvent.EscalateUserId})
+	}
+	// fallback approver can approve the log too, the vote substitutes all pending approvers(see vote)
+	if len(roles) > 0 {
+		fs.AddError(fs.session.Model(&l).Association("CanApprovalRoles").Append(&roles))
+	}
+	if len(users) > 0 {
+		fs.AddError(fs.session.Model(&l).Association("CanApprovalUsers").Append(&users))
+	}
+	if fs.Error != nil {
+		return
+	}
+	fs.addSystemAction(l.Id, constant.FsmSlaActionEscalate, i18n.T(constant.FsmMsgAutoEscalate))
+	fs.notify(l, constant.FsmSlaActionEscalate, roles, users)
+}
+
+func (fs *Fsm) timeout(l Log) {
+	machine := fs.getLogMachine(l)
+	if machine.Id == constant.Zero {
+		fs.AddError(i18n.E(ErrMachineNotFound))
+		return
+	}
+	opinion := i18n.T(constant.FsmMsgAutoApproved)
+	if l.NextEvent.TimeoutAction == constant.FsmLogStatusRefused {
+		opinion = i18n.T(constant.FsmMsgAutoRefused)
+	}
+	fs.addSystemAction(l.Id, constant.FsmSlaActionTimeout, opinion)
+	// preload progress for fsm instance
+	fs.session.
+		Model(&EventItem{}).
+		Where("id = ?", l.ProgressId).
+		First(&l.Progress)
+	// status transition is called by approve
+	fs.approve(machine, l, req.FsmApproveLog{
+		Category:        req.NullUint(l.Category),
+		Uuid:            l.Uuid,
+		ApprovalOpinion: opinion,
+		Approved:        req.NullUint(l.NextEvent.TimeoutAction),
+	})
+	return
+}
+
+func (fs *Fsm) addSystemAction(logId, action uint, detail string) {
+	fs.session.Create(&LogAction{
+		LogId:  logId,
+		Actor:  constant.FsmSystemActor,
+		Action: action,
+		Detail: detail,
+	})
+}
+
+func (fs *Fsm) notify(l Log, action uint, roles []Role, users []User) {
+	if fs.ops.reminder == nil {
+		return
+	}
+	item := resp.FsmSlaLog{
+		Uuid:     l.Uuid,
+		Category: l.Category,
+		Action:   action,
+		RoleIds:  make([]uint, 0),
+		UserIds:  make([]uint, 0),
+	}
+	for _, role := range roles {
+		item.RoleIds = append(item.RoleIds, role.Id)
+	}
+	for _, user := range users {
+		item.UserIds = append(item.UserIds, user.Id)
+	}
+	fs.AddError(fs.ops.reminder(fs.ops.ctx, item))
+}
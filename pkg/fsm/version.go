@@ -0,0 +1,210 @@
+package fsm
+
+import (
+	"github.com/ennismar/go-helper/pkg/constant"
+	"github.com/ennismar/go-helper/pkg/i18n"
+	"github.com/ennismar/go-helper/pkg/req"
+	"github.com/ennismar/go-helper/pkg/resp"
+	"github.com/ennismar/go-helper/pkg/utils"
+	"reflect"
+	"sort"
+)
+
+// FindMachineVersion find machine versions(latest first)
+func (fs *Fsm) FindMachineVersion(r *req.FsmMachineVersion) (rp []resp.FsmMachineVersion) {
+	rp = make([]resp.FsmMachineVersion, 0)
+	if fs.Error != nil {
+		return
+	}
+	list := make([]MachineVersion, 0)
+	q := fs.session.
+		Model(&MachineVersion{}).
+		Where("machine_id = ?", r.MachineId).
+		Order("version DESC")
+	page := &r.Page
+	countCache := false
+	if page.CountCache != nil {
+		countCache = *page.CountCache
+	}
+	if !page.NoPagination {
+		if !page.SkipCount {
+			q.Count(&page.Total)
+		}
+		if page.Total > 0 || page.SkipCount {
+			limit, offset := page.GetLimit()
+			q.Limit(limit).Offset(offset).Find(&list)
+		}
+	} else {
+		// no pagination
+		q.Find(&list)
+		page.Total = int64(len(list))
+		page.GetLimit()
+	}
+	page.CountCache = &countCache
+	utils.Struct2StructByJson(list, &rp)
+	return
+}
+
+// DiffMachineVersion compare two machine versions, levels are compared by position
+func (fs *Fsm) DiffMachineVersion(r req.FsmDiffMachineVersion) (rp resp.FsmMachineVersionDiff) {
+	rp.MachineId = r.MachineId
+	rp.From = r.From
+	rp.To = r.To
+	rp.Fields = make([]resp.FsmMachineVersionField, 0)
+	rp.Levels = make([]resp.FsmMachineVersionLevel, 0)
+	if fs.Error != nil {
+		return
+	}
+	versions := make([]MachineVersion, 0)
+	fs.session.
+		Model(&MachineVersion{}).
+		Where("machine_id = ?", r.MachineId).
+		Where("version IN (?)", []uint{r.From, r.To}).
+		Find(&versions)
+	var from, to MachineVersion
+	for _, item := range versions {
+		if item.Version == r.From {
+			from = item
+		}
+		if item.Version == r.To {
+			to = item
+		}
+	}
+	if from.Id == constant.Zero || to.Id == constant.Zero {
+		fs.AddError(i18n.E(ErrMachineVersionNotFound))
+		return
+	}
+	rp.Fields = diffFields(from, to, "id", "createdAt", "updatedAt", "deletedAt", "machineId", "version", "eventsJson")
+
+	fromLevels := make([]req.FsmCreateEvent, 0)
+	toLevels := make([]req.FsmCreateEvent, 0)
+	utils.Json2Struct(from.EventsJson, &fromLevels)
+	utils.Json2Struct(to.EventsJson, &toLevels)
+	l := len(fromLevels)
+	if len(toLevels) > l {
+		l = len(toLevels)
+	}
+	for i := 0; i < l; i++ {
+		level := resp.FsmMachineVersionLevel{
+			Level: uint(i + 1),
+		}
+		switch {
+		case i >= len(fromLevels):
+			level.Name = toLevels[i].Name
+			level.Action = constant.FsmVersionDiffAdded
+			level.Fields = diffFields(nil, toLevels[i])
+		case i >= len(toLevels):
+			level.Name = fromLevels[i].Name
+			level.Action = constant.FsmVersionDiffRemoved
+			level.Fields = diffFields(fromLevels[i], nil)
+		default:
+			level.Name = toLevels[i].Name
+			level.Action = constant.FsmVersionDiffChanged
+			level.Fields = diffFields(fromLevels[i], toLevels[i])
+			if len(level.Fields) == 0 {
+				continue
+			}
+		}
+		rp.Levels = append(rp.Levels, level)
+	}
+	return
+}
+
+// save machine snapshot as a version, skip if the version exists
+func (fs *Fsm) saveMachineVersion(machine Machine) {
+	if fs.Error != nil {
+		return
+	}
+	var count int64
+	fs.session.
+		Model(&MachineVersion{}).
+		Where("machine_id = ?", machine.Id).
+		Where("version = ?", machine.Version).
+		Count(&count)
+	if count > 0 {
+		return
+	}
+	fs.session.Create(&MachineVersion{
+		MachineId:                  machine.Id,
+		Version:                    machine.Version,
+		Name:                       machine.Name,
+		SubmitterName:              machine.SubmitterName,
+		SubmitterEditFields:        machine.SubmitterEditFields,
+		SubmitterConfirm:           machine.SubmitterConfirm,
+		SubmitterConfirmEditFields: machine.SubmitterConfirmEditFields,
+		EventsJson:                 machine.EventsJson,
+	})
+}
+
+// get machine snapshot by version, fallback to current machine if the snapshot is missing(Migrate not run)
+func (fs *Fsm) getMachineVersion(machine Machine, version uint) (rp MachineVersion) {
+	fs.session.
+		Model(&MachineVersion{}).
+		Where("machine_id = ?", machine.Id).
+		Where("version = ?", version).
+		First(&rp)
+	if rp.Id == constant.Zero {
+		rp = MachineVersion{
+			MachineId:                  machine.Id,
+			Version:                    version,
+			Name:                       machine.Name,
+			SubmitterName:              machine.SubmitterName,
+			SubmitterEditFields:        machine.SubmitterEditFields,
+			SubmitterConfirm:           machine.SubmitterConfirm,
+			SubmitterConfirmEditFields: machine.SubmitterConfirmEditFields,
+			EventsJson:                 machine.EventsJson,
+		}
+	}
+	return
+}
+
+// get the machine which the log was submitted with(deleted machine is included, in-flight logs can still finish)
+func (fs *Fsm) getLogMachine(l Log) (rp Machine) {
+	if l.MachineId == constant.Zero {
+		rp = fs.GetMachineByCategory(l.Category)
+		return
+	}
+	fs.session.
+		Unscoped().
+		Model(&Machine{}).
+		Where("id = ?", l.MachineId).
+		First(&rp)
+	return
+}
+
+// compare json fields of two structs(nil means empty), skip some keys
+func diffFields(from, to interface{}, skip ...string) (rp []resp.FsmMachineVersionField) {
+	rp = make([]resp.FsmMachineVersionField, 0)
+	m1 := make(map[string]interface{})
+	m2 := make(map[string]interface{})
+	if from != nil {
+		utils.Struct2StructByJson(from, &m1)
+	}
+	if to != nil {
+		utils.Struct2StructByJson(to, &m2)
+	}
+	keys := make([]string, 0)
+	for k := range m1 {
+		keys = append(keys, k)
+	}
+	for k := range m2 {
+		if _, ok := m1[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if utils.Contains(skip, k) {
+			continue
+		}
+		if reflect.DeepEqual(m1[k], m2[k]) {
+			continue
+		}
+		rp = append(rp, resp.FsmMachineVersionField{
+			Name: k,
+			From: m1[k],
+			To:   m2[k],
+		})
+	}
+	return
+}
@@ -67,5 +67,16 @@ func Http(options ...func(*HttpOptions)) {
 		log.WithContext(ctx).WithError(err).Error("[%s][http server]forced to shutdown failed", ops.proName)
 	}
 
+	// drain in-flight works of background workers
+	if len(ops.stops) > 0 {
+		stopCtx, stopCancel := context.WithTimeout(ops.ctx, time.Duration(ops.stopTimeout)*time.Second)
+		defer stopCancel()
+		for _, f := range ops.stops {
+			if err := f(stopCtx); err != nil {
+				log.WithContext(ctx).WithError(err).Error("[%s][http server]stop worker failed", ops.proName)
+			}
+		}
+	}
+
 	log.WithContext(ctx).Info("[%s][http server]exiting", ops.proName)
 }
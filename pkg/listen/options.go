@@ -9,14 +9,16 @@ import (
 )
 
 type HttpOptions struct {
-	ctx       context.Context
-	host      string
-	port      int
-	pprofPort int
-	urlPrefix string
-	proName   string
-	handler   http.Handler
-	exit      func()
+	ctx         context.Context
+	host        string
+	port        int
+	pprofPort   int
+	urlPrefix   string
+	proName     string
+	handler     http.Handler
+	exit        func()
+	stops       []func(context.Context) error
+	stopTimeout int
 }
 
 func WithHttpCtx(ctx context.Context) func(*HttpOptions) {
@@ -71,14 +73,34 @@ func WithHttpExit(f func()) func(*HttpOptions) {
 	}
 }
 
+// WithHttpStop stop background workers(such as mq.Worker.Stop) after http server is shutdown
+func WithHttpStop(fs ...func(ctx context.Context) error) func(*HttpOptions) {
+	return func(options *HttpOptions) {
+		for _, f := range fs {
+			if f != nil {
+				getHttpOptionsOrSetDefault(options).stops = append(getHttpOptionsOrSetDefault(options).stops, f)
+			}
+		}
+	}
+}
+
+func WithHttpStopTimeout(second int) func(*HttpOptions) {
+	return func(options *HttpOptions) {
+		if second > 0 {
+			getHttpOptionsOrSetDefault(options).stopTimeout = second
+		}
+	}
+}
+
 func getHttpOptionsOrSetDefault(options *HttpOptions) *HttpOptions {
 	if options == nil {
 		return &HttpOptions{
-			ctx:       context.Background(),
-			host:      "0.0.0.0",
-			port:      8080,
-			urlPrefix: "api",
-			proName:   "project",
+			ctx:         context.Background(),
+			host:        "0.0.0.0",
+			port:        8080,
+			urlPrefix:   "api",
+			proName:     "project",
+			stopTimeout: 30,
 		}
 	}
 	return options
@@ -5,6 +5,7 @@ import (
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/base64"
+	"fmt"
 	"github.com/ennismar/go-helper/pkg/constant"
 	"github.com/ennismar/go-helper/pkg/log"
 	"github.com/ennismar/go-helper/pkg/resp"
@@ -139,6 +140,25 @@ func Sign(options ...func(*SignOptions)) gin.HandlerFunc {
 }
 
 func verifySign(secret, signature, method, uri, timestamp, body string) (flag bool) {
+	flag = getSignature(secret, method, uri, timestamp, body) == signature
+	return
+}
+
+// GetSignToken generate token header value for Sign middleware, timestamp is unix seconds
+func GetSignToken(appId, secret, method, uri, timestamp, body string) string {
+	if body == "" {
+		body = constant.MiddlewareParamsNullBody
+	}
+	ops := getSignOptionsOrSetDefault(nil)
+	return fmt.Sprintf(
+		`%s="%s",%s="%s",%s="%s"`,
+		ops.headerKey[1], appId,
+		ops.headerKey[2], timestamp,
+		ops.headerKey[3], getSignature(secret, method, uri, timestamp, body),
+	)
+}
+
+func getSignature(secret, method, uri, timestamp, body string) string {
 	b := bytes.NewBuffer(nil)
 	b.WriteString(method)
 	b.WriteString(constant.MiddlewareSignSeparator)
@@ -149,9 +169,7 @@ func verifySign(secret, signature, method, uri, timestamp, body string) (flag bo
 	b.WriteString(utils.JsonWithSort(body))
 	hash := hmac.New(sha256.New, []byte(secret))
 	hash.Write(b.Bytes())
-	digest := base64.StdEncoding.EncodeToString(hash.Sum(nil))
-	flag = digest == signature
-	return
+	return base64.StdEncoding.EncodeToString(hash.Sum(nil))
 }
 
 func abort(c *gin.Context, format interface{}, a ...interface{}) {
@@ -1,13 +1,16 @@
 package middleware
 
 import (
+	"context"
 	"github.com/ennismar/go-helper/pkg/constant"
+	"github.com/ennismar/go-helper/pkg/log"
 	"github.com/ennismar/go-helper/pkg/resp"
 	"github.com/ennismar/go-helper/pkg/tracing"
 	"github.com/gin-gonic/gin"
 	"github.com/thoas/go-funk"
 	"gorm.io/gorm"
 	"net/http"
+	"sync"
 )
 
 func Transaction(options ...func(*TransactionOptions)) gin.HandlerFunc {
@@ -39,7 +42,9 @@ func Transaction(options ...func(*TransactionOptions)) gin.HandlerFunc {
 					if !noTransaction {
 						if rp.Code == resp.Ok || c.GetBool(constant.MiddlewareTransactionForceCommitCtxKey) {
 							// commit transaction
-							tx.Commit()
+							if tx.Commit().Error == nil {
+								runHooks(c)
+							}
 						} else {
 							// rollback transaction
 							tx.Rollback()
@@ -56,8 +61,8 @@ func Transaction(options ...func(*TransactionOptions)) gin.HandlerFunc {
 				// throw up exception
 				panic(err)
 			} else {
-				if !noTransaction {
-					tx.Commit()
+				if !noTransaction && tx.Commit().Error == nil {
+					runHooks(c)
 				}
 			}
 			c.Abort()
@@ -65,6 +70,7 @@ func Transaction(options ...func(*TransactionOptions)) gin.HandlerFunc {
 		if !noTransaction {
 			tx := ops.dbNoTx.Begin()
 			c.Set(constant.MiddlewareTransactionTxCtxKey, tx)
+			c.Set(constant.MiddlewareTransactionHooksCtxKey, &TransactionHooks{})
 		}
 		c.Next()
 	}
@@ -80,3 +86,43 @@ func getTx(c *gin.Context, ops TransactionOptions) *gorm.DB {
 	}
 	return tx
 }
+
+// TransactionHooks funcs run after transaction committed, they are dropped if rollback
+type TransactionHooks struct {
+	lock sync.Mutex
+	funs []func()
+}
+
+func (h *TransactionHooks) Add(fun func()) {
+	if fun == nil {
+		return
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.funs = append(h.funs, fun)
+}
+
+// Run call hooks in order, each hook runs only once
+func (h *TransactionHooks) Run(ctx context.Context) {
+	h.lock.Lock()
+	funs := h.funs
+	h.funs = nil
+	h.lock.Unlock()
+	for _, fun := range funs {
+		func() {
+			// transaction is committed, panic of hook can not change the response
+			defer func() {
+				if err := recover(); err != nil {
+					log.WithContext(ctx).Error("transaction hook panic: %v", err)
+				}
+			}()
+			fun()
+		}()
+	}
+}
+
+func runHooks(c *gin.Context) {
+	if hooks, ok := c.Value(constant.MiddlewareTransactionHooksCtxKey).(*TransactionHooks); ok {
+		hooks.Run(c)
+	}
+}
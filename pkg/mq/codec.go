@@ -0,0 +1,128 @@
+package mq
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/ugorji/go/codec"
+	"google.golang.org/protobuf/proto"
+	"mime"
+	"strings"
+	"sync"
+)
+
+const (
+	JsonContentType    = "application/json"
+	ProtoContentType   = "application/x-protobuf"
+	MsgpackContentType = "application/msgpack"
+)
+
+// DefaultCodecs json/protobuf/msgpack codecs, Rabbit uses it if WithCodecs is not set
+var DefaultCodecs = NewCodecs(
+	JsonCodec{},
+	ProtoCodec{},
+	MsgpackCodec{},
+)
+
+// Codec encode/decode message body of content type
+type Codec interface {
+	ContentType() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// Codecs codec registry by content type
+type Codecs struct {
+	lock   sync.RWMutex
+	codecs map[string]Codec
+}
+
+func NewCodecs(codecs ...Codec) *Codecs {
+	c := &Codecs{
+		codecs: make(map[string]Codec),
+	}
+	for _, item := range codecs {
+		c.Register(item)
+	}
+	return c
+}
+
+// Register add codec by its content type and aliases
+func (c *Codecs) Register(cd Codec, aliases ...string) {
+	if cd == nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, item := range append([]string{cd.ContentType()}, aliases...) {
+		c.codecs[mediaType(item)] = cd
+	}
+}
+
+// Get codec of content type, params such as charset are ignored
+func (c *Codecs) Get(contentType string) (cd Codec, ok bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	cd, ok = c.codecs[mediaType(contentType)]
+	return
+}
+
+func mediaType(contentType string) string {
+	if t, _, err := mime.ParseMediaType(contentType); err == nil {
+		return t
+	}
+	return strings.ToLower(strings.TrimSpace(contentType))
+}
+
+type JsonCodec struct{}
+
+func (JsonCodec) ContentType() string {
+	return JsonContentType
+}
+
+func (JsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// ProtoCodec protobuf codec, value must be proto.Message
+type ProtoCodec struct{}
+
+func (ProtoCodec) ContentType() string {
+	return ProtoContentType
+}
+
+func (ProtoCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, errors.Errorf("%T is not proto message", v)
+	}
+	return proto.Marshal(m)
+}
+
+func (ProtoCodec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return errors.Errorf("%T is not proto message", v)
+	}
+	return proto.Unmarshal(data, m)
+}
+
+var msgpackHandle = &codec.MsgpackHandle{}
+
+type MsgpackCodec struct{}
+
+func (MsgpackCodec) ContentType() string {
+	return MsgpackContentType
+}
+
+func (MsgpackCodec) Marshal(v interface{}) (b []byte, err error) {
+	err = codec.NewEncoderBytes(&b, msgpackHandle).Encode(v)
+	return
+}
+
+func (MsgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	return codec.NewDecoderBytes(data, msgpackHandle).Decode(v)
+}
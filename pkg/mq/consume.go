@@ -32,10 +32,20 @@ func (qu *Queue) Consume(handler func(context.Context, string, amqp.Delivery) bo
 		d := msg.Delivery
 		a := d.Acknowledger
 		tag := d.DeliveryTag
-		ok := handler(ctx, co.q, d)
+		mctx := newReasonContext(ctx)
+		ok := handler(mctx, co.q, d)
 		if co.ops.autoAck {
 			return
 		}
+		// failed message is moved to retry queue, ack it
+		if !ok && qu.retryEnabled(co.ops) {
+			e := qu.retryDelivery(mctx, d, getConsumeReason(mctx))
+			if e != nil {
+				log.WithContext(ctx).WithError(e).Error("consume retry failed")
+			} else {
+				ok = true
+			}
+		}
 		if ok {
 			e := a.Ack(tag, false)
 			if e != nil {
@@ -85,11 +95,19 @@ func (qu *Queue) ConsumeOne(size int, handler func(c context.Context, q string,
 	for i, d := range ds {
 		a := d.Acknowledger
 		tag := d.DeliveryTag
-		ctx = context.WithValue(ctx, "index", i)
+		ctx = newReasonContext(context.WithValue(ctx, "index", i))
 		ok := handler(ctx, co.q, d)
 		if co.ops.autoAck {
 			return
 		}
+		if !ok && qu.retryEnabled(co.ops) {
+			e := qu.retryDelivery(ctx, d, getConsumeReason(ctx))
+			if e != nil {
+				log.WithContext(ctx).WithError(e).Error("consume one retry failed")
+			} else {
+				ok = true
+			}
+		}
 		if ok {
 			e := a.Ack(tag, false)
 			if e != nil {
@@ -104,8 +122,8 @@ func (qu *Queue) ConsumeOne(size int, handler func(c context.Context, q string,
 		} else {
 			retryCount = 1
 		}
-		// need to retry, requeue with set custom header
-		if co.ops.nackRetry {
+		// need to retry, requeue with set custom header(retry queues are not declared)
+		if co.ops.nackRetry && !qu.retryEnabled(co.ops) {
 			if retryCount < co.ops.nackMaxRetryCount {
 				d.Headers["x-retry-count"] = retryCount
 				err = qu.ex.PublishByte(
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"github.com/streadway/amqp"
+	"google.golang.org/protobuf/types/known/emptypb"
 	"os"
 	"testing"
 	"time"
@@ -64,3 +65,109 @@ func TestQueue_ConsumeOne(t *testing.T) {
 		}
 	}
 }
+
+func TestQueue_ConsumeBatch(t *testing.T) {
+	qu := NewRabbit(uri).
+		Exchange(
+			WithExchangeName("ex1"),
+		).Queue(
+		WithQueueName("q1"),
+		WithQueueDeclare(false),
+		WithQueueBind(false),
+	)
+	if qu.Error != nil {
+		panic(qu.Error)
+	}
+	w, err := qu.ConsumeBatch(
+		func(ctx context.Context, q string, ds []amqp.Delivery) bool {
+			fmt.Println(ctx, q, len(ds))
+			time.Sleep(time.Second)
+			return true
+		},
+		WithConsumeWorkers(4),
+		WithConsumeBatch(10, 500),
+		WithConsumeAutoRequestId(true),
+	)
+	if err != nil {
+		panic(err)
+	}
+	time.Sleep(10 * time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	fmt.Println(w.Stop(ctx))
+}
+
+func TestQueue_ConsumeRetry(t *testing.T) {
+	qu := NewRabbit(uri).
+		Exchange(
+			WithExchangeName("ex1"),
+		).Queue(
+		WithQueueName("q4"),
+		WithQueueRouteKeys("rt4"),
+		WithQueueRetry(3, time.Second),
+	)
+	if qu.Error != nil {
+		panic(qu.Error)
+	}
+	w, err := qu.ConsumeWorker(
+		func(ctx context.Context, q string, d amqp.Delivery) bool {
+			fmt.Println(q, d.Headers["x-retry-count"], string(d.Body))
+			SetConsumeReason(ctx, "invalid body")
+			return false
+		},
+		WithConsumeNackRetry(true),
+	)
+	if err != nil {
+		panic(err)
+	}
+	time.Sleep(20 * time.Second)
+	fmt.Println(w.Stop(context.Background()))
+	fmt.Println(qu.FindParked(10))
+	fmt.Println(qu.ReplayParked(10))
+}
+
+type order struct {
+	Id   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+func TestQueue_ConsumeTyped(t *testing.T) {
+	ex := NewRabbit(uri).
+		Exchange(
+			WithExchangeName("ex1"),
+		)
+	if ex.Error != nil {
+		panic(ex.Error)
+	}
+	qu := ex.QueueWithDeadLetter(
+		WithQueueName("q5"),
+		WithQueueRouteKeys("rt5"),
+		WithQueueDeadLetterName("dl-ex"),
+		WithQueueDeadLetterKey("dlr"),
+	)
+	if qu.Error != nil {
+		panic(qu.Error)
+	}
+	r := NewRouter()
+	r.Handle("order", func() interface{} {
+		return &order{}
+	}, func(ctx context.Context, m interface{}, d amqp.Delivery) bool {
+		fmt.Println(d.ContentType, m.(*order))
+		return true
+	})
+	r.HandleProto(&emptypb.Empty{}, func(ctx context.Context, m interface{}, d amqp.Delivery) bool {
+		fmt.Println(d.ContentType, m.(*emptypb.Empty))
+		return true
+	})
+	w, err := qu.ConsumeTyped(r, WithConsumeWorkers(2))
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(ex.PublishTyped("order", order{Id: 1, Name: "json"}, WithPublishRouteKey("rt5")))
+	fmt.Println(ex.PublishTyped("order", order{Id: 2, Name: "msgpack"}, WithPublishRouteKey("rt5"), WithPublishContentType(MsgpackContentType)))
+	fmt.Println(ex.PublishTyped("", &emptypb.Empty{}, WithPublishRouteKey("rt5")))
+	// no handler, it is sent to dead letter
+	fmt.Println(ex.PublishTyped("user", order{Id: 3}, WithPublishRouteKey("rt5")))
+	time.Sleep(5 * time.Second)
+	fmt.Println(w.Stop(context.Background()))
+}
@@ -0,0 +1,221 @@
+package mq
+
+import (
+	"fmt"
+	"github.com/ennismar/go-helper/pkg/log"
+	"github.com/pkg/errors"
+	"github.com/streadway/amqp"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	delayPluginUnknown int32 = iota
+	delayPluginEnabled
+	delayPluginMissing
+)
+
+const (
+	delayedExchangeKind = "x-delayed-message"
+	delayHeader         = "x-delay"
+	delayTierHeader     = "x-delay-tier"
+	// max delay of x-delayed-message plugin
+	delayPluginMax = time.Duration(1<<32-1) * time.Millisecond
+)
+
+// defaultDelayTiers ttl queues created if x-delayed-message plugin is missing
+var defaultDelayTiers = []time.Duration{
+	time.Second,
+	5 * time.Second,
+	10 * time.Second,
+	30 * time.Second,
+	time.Minute,
+	5 * time.Minute,
+	10 * time.Minute,
+	30 * time.Minute,
+	time.Hour,
+	2 * time.Hour,
+	6 * time.Hour,
+	12 * time.Hour,
+	24 * time.Hour,
+}
+
+// exchangeDelay delayed publish topology of exchange
+type exchangeDelay struct {
+	// x-delayed-message plugin is used
+	plugin bool
+	// exchange name delayed messages published to
+	name  string
+	tiers []time.Duration
+}
+
+// setupDelay declare delayed topology once:
+// 1. plugin: <name>.delayed(x-delayed-message fanout) -> <name>, x-delay header is the delay
+// 2. fallback: <name>.delay.ttl(headers) -> <name>.delay.<tier ms>(ttl queue) -> dead letter to <name>
+// routing key is kept in both, so bindings of <name> still work
+func (ex *Exchange) setupDelay() (rp *exchangeDelay, err error) {
+	ex.lock.Lock()
+	defer ex.lock.Unlock()
+	if ex.delay != nil {
+		rp = ex.delay
+		return
+	}
+	if atomic.LoadInt32(&ex.rb.delayPlugin) != delayPluginMissing {
+		err = ex.declarePluginDelay()
+		if err == nil {
+			atomic.StoreInt32(&ex.rb.delayPlugin, delayPluginEnabled)
+			ex.delay = &exchangeDelay{
+				plugin: true,
+				name:   ex.ops.name + ".delayed",
+			}
+			rp = ex.delay
+			return
+		}
+		if e, ok := errors.Cause(err).(*amqp.Error); !ok || e.Code != amqp.CommandInvalid {
+			return
+		}
+		log.WithContext(ex.rb.ops.ctx).WithError(err).Warn("x-delayed-message plugin is missing, use ttl queues")
+		atomic.StoreInt32(&ex.rb.delayPlugin, delayPluginMissing)
+	}
+	tiers := append([]time.Duration{}, ex.ops.delayTiers...)
+	if len(tiers) == 0 {
+		tiers = defaultDelayTiers
+	}
+	sort.Slice(tiers, func(i, j int) bool {
+		return tiers[i] < tiers[j]
+	})
+	err = ex.declareTierDelay(tiers)
+	if err != nil {
+		return
+	}
+	ex.delay = &exchangeDelay{
+		name:  ex.ops.name + ".delay.ttl",
+		tiers: tiers,
+	}
+	rp = ex.delay
+	return
+}
+
+// declarePluginDelay declare delayed exchange on a dedicated connection,
+// broker closes the connection(503 command invalid) if the plugin is missing, pooled connections are not affected
+func (ex *Exchange) declarePluginDelay() (err error) {
+	var conn *amqp.Connection
+	conn, err = amqp.DialConfig(ex.rb.poolConfig.URI, amqp.Config{
+		Heartbeat: time.Duration(ex.rb.ops.heartbeat) * time.Second,
+		Dial:      amqp.DefaultDial(time.Duration(ex.rb.ops.timeout) * time.Second),
+	})
+	if err != nil {
+		err = errors.Wrap(err, "failed dial delayed exchange connection")
+		return
+	}
+	defer conn.Close()
+	var ch *amqp.Channel
+	ch, err = conn.Channel()
+	if err != nil {
+		err = errors.Wrap(err, "failed open delayed exchange channel")
+		return
+	}
+	name := ex.ops.name + ".delayed"
+	if err = ch.ExchangeDeclare(
+		name,
+		delayedExchangeKind,
+		ex.ops.durable,
+		ex.ops.autoDelete,
+		false,
+		false,
+		amqp.Table{
+			"x-delayed-type": amqp.ExchangeFanout,
+		},
+	); err != nil {
+		err = errors.Wrapf(err, "failed declare delayed exchange %s", name)
+		return
+	}
+	if err = ch.ExchangeBind(ex.ops.name, "", name, false, nil); err != nil {
+		err = errors.Wrapf(err, "failed bind delayed exchange %s to %s", name, ex.ops.name)
+		return
+	}
+	return
+}
+
+func (ex *Exchange) declareTierDelay(tiers []time.Duration) (err error) {
+	ch := ex.rb.pool.GetChannelFromPool()
+	defer func() {
+		ex.rb.pool.ReturnChannel(ch, true)
+	}()
+	name := ex.ops.name + ".delay.ttl"
+	if err = ch.Channel.ExchangeDeclare(
+		name,
+		amqp.ExchangeHeaders,
+		ex.ops.durable,
+		ex.ops.autoDelete,
+		false,
+		false,
+		nil,
+	); err != nil {
+		err = errors.Wrapf(err, "failed declare delay exchange %s", name)
+		return
+	}
+	for _, tier := range tiers {
+		ms := tier.Milliseconds()
+		q := fmt.Sprintf("%s.delay.%d", ex.ops.name, ms)
+		if _, err = ch.Channel.QueueDeclare(
+			q,
+			ex.ops.durable,
+			ex.ops.autoDelete,
+			false,
+			false,
+			amqp.Table{
+				"x-message-ttl":          int32(ms),
+				"x-dead-letter-exchange": ex.ops.name,
+			},
+		); err != nil {
+			err = errors.Wrapf(err, "failed to declare delay queue %s", q)
+			return
+		}
+		if err = ch.Channel.QueueBind(
+			q,
+			"",
+			name,
+			false,
+			amqp.Table{
+				"x-match":       "all",
+				delayTierHeader: strconv.FormatInt(ms, 10),
+			},
+		); err != nil {
+			err = errors.Wrapf(err, "failed to bind delay queue %s", q)
+			return
+		}
+	}
+	return
+}
+
+// publishing message of delayed publish, user expiration is overwritten by ttl queues
+func (d exchangeDelay) publishing(delay time.Duration, msg amqp.Publishing) (rp amqp.Publishing, err error) {
+	rp = msg
+	headers := make(amqp.Table, len(msg.Headers)+1)
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+	rp.Headers = headers
+	if d.plugin {
+		if delay > delayPluginMax {
+			err = errors.Errorf("max delay is %s", delayPluginMax)
+			return
+		}
+		headers[delayHeader] = delay.Milliseconds()
+		return
+	}
+	// the smallest tier not less than delay, message expiration makes the delay exact
+	// (message may wait for the ones in front of it, up to the gap of the tier and the previous tier)
+	for _, tier := range d.tiers {
+		if tier >= delay {
+			headers[delayTierHeader] = strconv.FormatInt(tier.Milliseconds(), 10)
+			rp.Expiration = strconv.FormatInt(delay.Milliseconds(), 10)
+			return
+		}
+	}
+	err = errors.Errorf("max delay is %s", d.tiers[len(d.tiers)-1])
+	return
+}
@@ -5,6 +5,7 @@ import (
 	"github.com/ennismar/go-helper/pkg/utils"
 	"github.com/streadway/amqp"
 	"github.com/thoas/go-funk"
+	"time"
 )
 
 type RabbitOptions struct {
@@ -15,6 +16,11 @@ type RabbitOptions struct {
 	maxConnection       int
 	maxChannel          int
 	healthCheckInterval int
+	spool               Spool
+	spoolInterval       int
+	spoolBatch          int
+	spoolMaxAttempts    int
+	codecs              *Codecs
 }
 
 func WithCtx(ctx context.Context) func(*RabbitOptions) {
@@ -71,6 +77,49 @@ func WithHealthCheckInterval(milli int) func(*RabbitOptions) {
 	}
 }
 
+// WithSpool unconfirmed messages are saved in spool and replayed after reconnected
+func WithSpool(spool Spool) func(*RabbitOptions) {
+	return func(options *RabbitOptions) {
+		if !utils.InterfaceIsNil(spool) {
+			getRabbitOptionsOrSetDefault(options).spool = spool
+		}
+	}
+}
+
+func WithSpoolReplayInterval(milli int) func(*RabbitOptions) {
+	return func(options *RabbitOptions) {
+		if milli > 0 {
+			getRabbitOptionsOrSetDefault(options).spoolInterval = milli
+		}
+	}
+}
+
+func WithSpoolReplayBatch(size int) func(*RabbitOptions) {
+	return func(options *RabbitOptions) {
+		if size > 0 {
+			getRabbitOptionsOrSetDefault(options).spoolBatch = size
+		}
+	}
+}
+
+// WithSpoolMaxAttempts spooled message is moved to dead area of spool after failed to replay count times(nack/channel closed), default 5
+func WithSpoolMaxAttempts(count int) func(*RabbitOptions) {
+	return func(options *RabbitOptions) {
+		if count > 0 {
+			getRabbitOptionsOrSetDefault(options).spoolMaxAttempts = count
+		}
+	}
+}
+
+// WithCodecs codec registry of PublishTyped/ConsumeTyped, default is DefaultCodecs
+func WithCodecs(codecs *Codecs) func(*RabbitOptions) {
+	return func(options *RabbitOptions) {
+		if codecs != nil {
+			getRabbitOptionsOrSetDefault(options).codecs = codecs
+		}
+	}
+}
+
 func getRabbitOptionsOrSetDefault(options *RabbitOptions) *RabbitOptions {
 	if options == nil {
 		return &RabbitOptions{
@@ -80,6 +129,10 @@ func getRabbitOptionsOrSetDefault(options *RabbitOptions) *RabbitOptions {
 			maxConnection:       10,
 			maxChannel:          50,
 			healthCheckInterval: 100,
+			spoolInterval:       5000,
+			spoolBatch:          100,
+			spoolMaxAttempts:    5,
+			codecs:              DefaultCodecs,
 		}
 	}
 	return options
@@ -95,6 +148,8 @@ type ExchangeOptions struct {
 	args       amqp.Table
 	declare    bool
 	namePrefix string
+	delay      bool
+	delayTiers []time.Duration
 }
 
 func WithExchangeName(name string) func(*ExchangeOptions) {
@@ -151,6 +206,25 @@ func WithExchangeNamePrefix(prefix string) func(*ExchangeOptions) {
 	}
 }
 
+// WithExchangeDelay declare delayed publish topology when binding exchange
+func WithExchangeDelay(flag bool) func(*ExchangeOptions) {
+	return func(options *ExchangeOptions) {
+		getExchangeOptionsOrSetDefault(options).delay = flag
+	}
+}
+
+// WithExchangeDelayTiers ttl queue tiers if x-delayed-message plugin is missing, max tier is the max delay
+func WithExchangeDelayTiers(tiers ...time.Duration) func(*ExchangeOptions) {
+	return func(options *ExchangeOptions) {
+		d := getExchangeOptionsOrSetDefault(options)
+		for _, item := range tiers {
+			if item >= time.Millisecond {
+				d.delayTiers = append(d.delayTiers, item)
+			}
+		}
+	}
+}
+
 func getExchangeOptionsOrSetDefault(options *ExchangeOptions) *ExchangeOptions {
 	if options == nil {
 		return &ExchangeOptions{
@@ -177,6 +251,8 @@ type QueueOptions struct {
 	deadLetterKey  string
 	messageTTL     int32
 	namePrefix     string
+	retry          int32
+	retryBase      time.Duration
 }
 
 func WithQueueName(name string) func(*QueueOptions) {
@@ -257,6 +333,18 @@ func WithQueueMessageTTL(ttl int32) func(*QueueOptions) {
 	}
 }
 
+// WithQueueRetry declare attempts retry queues(ttl of nth is base*2^(n-1)) and parking lot queue,
+// failed messages are retried by them if WithConsumeNackRetry is true(queues are declared only if WithQueueDeclare is true,
+// otherwise they must exist or retry is disabled)
+func WithQueueRetry(attempts int32, base time.Duration) func(*QueueOptions) {
+	return func(options *QueueOptions) {
+		if attempts > 0 && base >= time.Millisecond {
+			getQueueOptionsOrSetDefault(options).retry = attempts
+			getQueueOptionsOrSetDefault(options).retryBase = base
+		}
+	}
+}
+
 func getQueueOptionsOrSetDefault(options *QueueOptions) *QueueOptions {
 	if options == nil {
 		return &QueueOptions{
@@ -283,6 +371,11 @@ type PublishOptions struct {
 	expiration           string
 	deadLetter           bool
 	deadLetterFirstQueue string
+	messageId            string
+	confirm              func(Confirm)
+	spool                bool
+	delay                time.Duration
+	msgType              string
 }
 
 func WithPublishCtx(ctx context.Context) func(*PublishOptions) {
@@ -362,6 +455,44 @@ func WithPublishDeadLetterFirstQueue(q string) func(*PublishOptions) {
 	}
 }
 
+func WithPublishMessageId(id string) func(*PublishOptions) {
+	return func(options *PublishOptions) {
+		getPublishOptionsOrSetDefault(options).messageId = id
+	}
+}
+
+// WithPublishConfirm receive broker ack/nack result of each message(one route key is one message)
+func WithPublishConfirm(fun func(c Confirm)) func(*PublishOptions) {
+	return func(options *PublishOptions) {
+		if fun != nil {
+			getPublishOptionsOrSetDefault(options).confirm = fun
+		}
+	}
+}
+
+// WithPublishSpool save message to Rabbit spool if publish failed, default true(only if WithSpool is set)
+func WithPublishSpool(flag bool) func(*PublishOptions) {
+	return func(options *PublishOptions) {
+		getPublishOptionsOrSetDefault(options).spool = flag
+	}
+}
+
+// WithPublishDelay deliver message to queues after delay(by x-delayed-message plugin or ttl queues)
+func WithPublishDelay(delay time.Duration) func(*PublishOptions) {
+	return func(options *PublishOptions) {
+		if delay > 0 {
+			getPublishOptionsOrSetDefault(options).delay = delay
+		}
+	}
+}
+
+// WithPublishMessageType message type header and property, Router of ConsumeTyped dispatches by it
+func WithPublishMessageType(msgType string) func(*PublishOptions) {
+	return func(options *PublishOptions) {
+		getPublishOptionsOrSetDefault(options).msgType = msgType
+	}
+}
+
 func getPublishOptionsOrSetDefault(options *PublishOptions) *PublishOptions {
 	if options == nil {
 		return &PublishOptions{
@@ -372,6 +503,7 @@ func getPublishOptionsOrSetDefault(options *PublishOptions) *PublishOptions {
 			timeout:           10000,
 			reconnectInterval: 1000,
 			idleInterval:      1000,
+			spool:             true,
 		}
 	}
 	return options
@@ -389,6 +521,9 @@ type ConsumeOptions struct {
 	nackMaxRetryCount int32
 	autoRequestId     bool
 	oneCtx            context.Context
+	workers           int
+	batchSize         int
+	batchWait         int
 }
 
 func WithConsumeQosPrefetchCount(prefetchCount int) func(*ConsumeOptions) {
@@ -459,11 +594,35 @@ func WithConsumeOneContext(ctx context.Context) func(*ConsumeOptions) {
 	}
 }
 
+// WithConsumeWorkers concurrent handler count of ConsumeWorker/ConsumeBatch
+func WithConsumeWorkers(count int) func(*ConsumeOptions) {
+	return func(options *ConsumeOptions) {
+		if count > 0 {
+			getConsumeOptionsOrSetDefault(options).workers = count
+		}
+	}
+}
+
+// WithConsumeBatch ConsumeBatch handler receives up to size deliveries, or less after waiting milli since the first one
+func WithConsumeBatch(size, milli int) func(*ConsumeOptions) {
+	return func(options *ConsumeOptions) {
+		if size > 0 {
+			getConsumeOptionsOrSetDefault(options).batchSize = size
+		}
+		if milli >= 0 {
+			getConsumeOptionsOrSetDefault(options).batchWait = milli
+		}
+	}
+}
+
 func getConsumeOptionsOrSetDefault(options *ConsumeOptions) *ConsumeOptions {
 	if options == nil {
 		return &ConsumeOptions{
 			qosPrefetchCount:  2,
 			nackMaxRetryCount: 5,
+			workers:           1,
+			batchSize:         1,
+			batchWait:         100,
 		}
 	}
 	return options
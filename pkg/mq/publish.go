@@ -2,7 +2,6 @@ package mq
 
 import (
 	"github.com/google/uuid"
-	"github.com/houseofcat/turbocookedrabbit/v2/pkg/tcr"
 	"github.com/pkg/errors"
 	"github.com/streadway/amqp"
 	"google.golang.org/protobuf/proto"
@@ -11,11 +10,22 @@ import (
 )
 
 type Publish struct {
-	ops       PublishOptions
-	ex        *Exchange
-	msg       amqp.Publishing
-	publisher *tcr.Publisher
-	Error     error
+	ops      PublishOptions
+	ex       *Exchange
+	exchange string
+	msg      amqp.Publishing
+	Error    error
+}
+
+// Confirm publisher confirm result of one message
+type Confirm struct {
+	MessageId string
+	RouteKey  string
+	// broker acked the message
+	Ack bool
+	// message is saved to spool, it will be replayed after reconnected
+	Spooled bool
+	Error   error
 }
 
 // PublishProto publish grpc proto msg
@@ -26,7 +36,8 @@ func (ex *Exchange) PublishProto(m proto.Message, options ...func(*PublishOption
 		err = errors.WithStack(err)
 		return
 	}
-	err = ex.PublishByte(b, options...)
+	// protobuf content type can be decoded by ConsumeTyped, it can be overwritten by options
+	err = ex.PublishByte(b, append([]func(*PublishOptions){WithPublishContentType(ProtoContentType)}, options...)...)
 	return
 }
 
@@ -83,60 +94,98 @@ func (ex *Exchange) beforePublish(options ...func(*PublishOptions)) *Publish {
 	if ops.deliveryMode <= 0 || ops.deliveryMode > amqp.Persistent {
 		ops.deliveryMode = amqp.Persistent
 	}
+	if ops.msgType != "" {
+		ops.headers[MessageTypeHeader] = ops.msgType
+	}
+	if ops.messageId == "" {
+		ops.messageId = uuid.NewString()
+	}
 	pu.ops = *ops
 	msg := amqp.Publishing{
+		MessageId:    ops.messageId,
+		Type:         ops.msgType,
 		DeliveryMode: ops.deliveryMode,
 		Timestamp:    time.Now(),
 		ContentType:  ops.contentType,
 		Headers:      ops.headers,
 		Expiration:   ops.expiration,
+		AppId:        ex.rb.poolConfig.ApplicationName,
 	}
 	pu.msg = msg
 	pu.ex = ex
-	pu.publisher = tcr.NewPublisherFromConfig(
-		&tcr.RabbitSeasoning{
-			PoolConfig: ex.rb.poolConfig,
-			PublisherConfig: &tcr.PublisherConfig{
-				AutoAck:                false,
-				SleepOnIdleInterval:    uint32(ops.idleInterval),
-				SleepOnErrorInterval:   uint32(ops.reconnectInterval),
-				PublishTimeOutInterval: uint32(ops.timeout),
-			},
-		},
-		pu.ex.rb.pool,
-	)
+	pu.exchange = ex.ops.name
+	if ops.delay > 0 {
+		d, err := ex.setupDelay()
+		if err != nil {
+			pu.Error = err
+			return &pu
+		}
+		pu.msg, err = d.publishing(ops.delay, msg)
+		if err != nil {
+			pu.Error = err
+			return &pu
+		}
+		pu.exchange = d.name
+	}
 	return &pu
 }
 
 func (pu *Publish) publish() (err error) {
-	if atomic.LoadInt32(&pu.ex.rb.lost) == 1 {
-		err = errors.Errorf("connection maybe lost")
-		return
-	}
 	for _, key := range pu.ops.routeKeys {
-		envelope := &tcr.Envelope{
-			DeliveryMode: pu.msg.DeliveryMode,
-			Exchange:     pu.ex.ops.name,
-			RoutingKey:   key,
-			ContentType:  pu.msg.ContentType,
-			Headers:      pu.msg.Headers,
-			Mandatory:    pu.ops.mandatory,
-			Immediate:    pu.ops.immediate,
+		c := pu.confirm(key)
+		if pu.ops.confirm != nil {
+			pu.ops.confirm(c)
 		}
-		letter := &tcr.Letter{
-			LetterID:   uuid.New(),
-			RetryCount: uint32(pu.ops.maxRetryCount),
-			Body:       pu.msg.Body,
-			Envelope:   envelope,
+		if c.Error != nil && !c.Spooled {
+			err = errors.Wrapf(c.Error, "publish failed")
+			return
 		}
-		err = pu.publisher.PublishWithConfirmationContextError(
-			pu.ops.ctx,
-			letter,
-		)
-		if err != nil {
-			err = errors.Wrapf(err, "publish failed")
+	}
+	return
+}
+
+// confirm publish one message with retry, save it to spool if all retries failed
+func (pu *Publish) confirm(key string) (rp Confirm) {
+	rb := pu.ex.rb
+	rp.MessageId = pu.msg.MessageId
+	rp.RouteKey = key
+	for i := 0; i < pu.ops.maxRetryCount; i++ {
+		if atomic.LoadInt32(&rb.lost) == 1 {
+			rp.Error = errors.Errorf("connection maybe lost")
+			// no need to wait reconnect if spool is available
+			if pu.spool() {
+				break
+			}
+		} else {
+			rp.Error = rb.publishConfirm(
+				pu.ops.ctx,
+				pu.exchange,
+				key,
+				pu.ops.mandatory,
+				pu.ops.immediate,
+				pu.msg,
+				time.Duration(pu.ops.timeout)*time.Millisecond,
+			)
+			if rp.Error == nil {
+				rp.Ack = true
+				return
+			}
+		}
+		if i < pu.ops.maxRetryCount-1 {
+			time.Sleep(time.Duration(pu.ops.reconnectInterval) * time.Millisecond)
+		}
+	}
+	if pu.spool() {
+		e := rb.pushSpool(newSpoolMessage(pu.exchange, key, pu.msg))
+		if e != nil {
+			rp.Error = errors.Wrapf(e, "spool failed, last publish error: %v", rp.Error)
 			return
 		}
+		rp.Spooled = true
 	}
 	return
 }
+
+func (pu *Publish) spool() bool {
+	return pu.ops.spool && pu.ex.rb.ops.spool != nil
+}
@@ -89,3 +89,58 @@ func TestExchange_PublishProto2(t *testing.T) {
 		fmt.Println(time.Now(), "send end", err)
 	}
 }
+
+func TestExchange_PublishSpool(t *testing.T) {
+	rb := NewRabbit(
+		uri,
+		WithSpool(NewFileSpool("spool")),
+		WithSpoolReplayInterval(3000),
+	)
+	if rb.Error != nil {
+		panic(rb.Error)
+	}
+	ex := rb.Exchange(
+		WithExchangeName("ex1"),
+		WithExchangeDeclare(false),
+	)
+	if ex.Error != nil {
+		panic(ex.Error)
+	}
+
+	for {
+		time.Sleep(time.Second)
+		// stop rabbitmq for a while, messages are spooled and replayed after restarted
+		err := ex.PublishJson(
+			`{"id":1}`,
+			WithPublishRouteKey("rt1"),
+			WithPublishConfirm(func(c Confirm) {
+				fmt.Println(c.MessageId, c.RouteKey, c.Ack, c.Spooled, c.Error)
+			}),
+		)
+		fmt.Println(time.Now(), "send end", err)
+		fmt.Println(rb.SpoolStats())
+	}
+}
+
+func TestExchange_PublishDelay(t *testing.T) {
+	rb := NewRabbit(uri)
+	if rb.Error != nil {
+		panic(rb.Error)
+	}
+	ex := rb.Exchange(
+		WithExchangeName("ex1"),
+		WithExchangeDelay(true),
+		WithExchangeDelayTiers(time.Second, 10*time.Second, time.Minute),
+	)
+	if ex.Error != nil {
+		panic(ex.Error)
+	}
+	for _, item := range []time.Duration{time.Second, 5 * time.Second, 30 * time.Second, 2 * time.Minute} {
+		err := ex.PublishJson(
+			fmt.Sprintf(`{"delay":"%s","time":"%s"}`, item, time.Now()),
+			WithPublishRouteKey("rt1"),
+			WithPublishDelay(item),
+		)
+		fmt.Println(item, err)
+	}
+}
@@ -1,11 +1,14 @@
 package mq
 
 import (
+	"context"
+	"fmt"
 	"github.com/ennismar/go-helper/pkg/log"
 	"github.com/google/uuid"
 	"github.com/houseofcat/turbocookedrabbit/v2/pkg/tcr"
 	"github.com/pkg/errors"
 	"github.com/streadway/amqp"
+	"sync"
 	"sync/atomic"
 	"time"
 )
@@ -16,19 +19,45 @@ type Rabbit struct {
 	poolConfig *tcr.PoolConfig
 	healthHost *tcr.ConnectionHost
 	lost       int32
-	Error      error
+	replaying  int32
+	spooled    uint64
+	replayed   uint64
+	failed     uint64
+	dead       uint64
+	// replay state of spool head message
+	headLock sync.Mutex
+	head     spoolHead
+	// x-delayed-message plugin state
+	delayPlugin int32
+	Error       error
+}
+
+type spoolHead struct {
+	key      string
+	id       string
+	attempts int
+	err      string
 }
 
+var (
+	errBrokerNack    = errors.New("broker nack")
+	errChannelClosed = errors.New("channel closed before confirm")
+)
+
 type Exchange struct {
 	ops   ExchangeOptions
 	rb    *Rabbit
+	lock  sync.Mutex
+	delay *exchangeDelay
 	Error error
 }
 
 type Queue struct {
-	ops   QueueOptions
-	ex    *Exchange
-	Error error
+	ops QueueOptions
+	ex  *Exchange
+	// retry queues and parking lot queue exist
+	retryReady bool
+	Error      error
 }
 
 func NewRabbit(dsn string, options ...func(*RabbitOptions)) (rb *Rabbit) {
@@ -83,11 +112,17 @@ func NewRabbit(dsn string, options ...func(*RabbitOptions)) (rb *Rabbit) {
 }
 
 func (rb *Rabbit) healthCheck() {
+	var last time.Time
 	// InfiniteLoop: Stay here till we reconnect.
 	for {
 		ok := rb.healthHost.Connect()
 		if ok {
-			atomic.CompareAndSwapInt32(&rb.lost, 1, 0)
+			reconnected := atomic.CompareAndSwapInt32(&rb.lost, 1, 0)
+			// replay spool after reconnected, messages spooled by nack/timeout are replayed by interval
+			if rb.ops.spool != nil && (reconnected || time.Since(last) > time.Duration(rb.ops.spoolInterval)*time.Millisecond) {
+				last = time.Now()
+				go rb.replay()
+			}
 		} else {
 			atomic.CompareAndSwapInt32(&rb.lost, 0, 1)
 		}
@@ -103,6 +138,174 @@ func (rb *Rabbit) Ping() (err error) {
 	return
 }
 
+// SpoolStats spool depth and counters, depth is 0 if WithSpool is not set
+func (rb *Rabbit) SpoolStats() (rp SpoolStats, err error) {
+	rp.Spooled = atomic.LoadUint64(&rb.spooled)
+	rp.Replayed = atomic.LoadUint64(&rb.replayed)
+	rp.Failed = atomic.LoadUint64(&rb.failed)
+	rp.Dead = atomic.LoadUint64(&rb.dead)
+	rb.headLock.Lock()
+	rp.HeadId = rb.head.id
+	rp.HeadAttempts = rb.head.attempts
+	rp.HeadError = rb.head.err
+	rb.headLock.Unlock()
+	if rb.ops.spool == nil {
+		return
+	}
+	rp.Depth, err = rb.ops.spool.Len(rb.ops.ctx)
+	if err != nil {
+		err = errors.WithStack(err)
+	}
+	return
+}
+
+// publishConfirm publish one message on confirm mode channel and wait broker ack/nack
+func (rb *Rabbit) publishConfirm(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing, timeout time.Duration) (err error) {
+	ch := rb.pool.GetChannelFromPool()
+	// drop confirmations of previous timeout publish
+	ch.FlushConfirms()
+	confirms := ch.Confirmations
+	errs := ch.Errors
+	err = ch.Channel.Publish(exchange, key, mandatory, immediate, msg)
+	if err != nil {
+		rb.pool.ReturnChannel(ch, true)
+		err = errors.Wrapf(errChannelClosed, "publish message %s: %v", msg.MessageId, err)
+		return
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case c := <-confirms:
+		rb.pool.ReturnChannel(ch, false)
+		if !c.Ack {
+			err = errors.Wrapf(errBrokerNack, "message %s", msg.MessageId)
+		}
+	case e := <-errs:
+		// channel closed, it will be reconnected
+		go rb.pool.ReturnChannel(ch, true)
+		err = errors.Wrapf(errChannelClosed, "message %s: %v", msg.MessageId, e)
+	case <-timer.C:
+		// late confirmation may be received by others, recreate channel(it maybe blocked till reconnected)
+		go rb.pool.ReturnChannel(ch, true)
+		err = errors.Errorf("confirm of message %s timeout", msg.MessageId)
+	case <-ctx.Done():
+		go rb.pool.ReturnChannel(ch, true)
+		err = errors.Wrapf(ctx.Err(), "confirm of message %s cancelled", msg.MessageId)
+	}
+	return
+}
+
+func (rb *Rabbit) pushSpool(msg SpoolMessage) (err error) {
+	err = rb.ops.spool.Push(rb.ops.ctx, msg)
+	if err != nil {
+		atomic.AddUint64(&rb.failed, 1)
+		return
+	}
+	atomic.AddUint64(&rb.spooled, 1)
+	log.WithContext(rb.ops.ctx).WithFields(map[string]interface{}{
+		"Id":       msg.Id,
+		"Exchange": msg.Exchange,
+		"RouteKey": msg.RouteKey,
+	}).Warn("publish failed, message is spooled")
+	return
+}
+
+// replay publish spooled messages in order, stop at the first failure(keep order, retry by next replay),
+// invalid message or the one failed WithSpoolMaxAttempts times is moved to dead area so it does not block others
+func (rb *Rabbit) replay() {
+	if !atomic.CompareAndSwapInt32(&rb.replaying, 0, 1) {
+		return
+	}
+	defer atomic.StoreInt32(&rb.replaying, 0)
+	ctx := rb.ops.ctx
+	timeout := time.Duration(rb.ops.timeout) * time.Second
+	for atomic.LoadInt32(&rb.lost) == 0 {
+		list, err := rb.ops.spool.Peek(ctx, rb.ops.spoolBatch)
+		if err != nil {
+			atomic.AddUint64(&rb.failed, 1)
+			log.WithContext(ctx).WithError(err).Error("peek spool failed")
+			return
+		}
+		if len(list) == 0 {
+			return
+		}
+		count := 0
+		for _, item := range list {
+			if item.Invalid != "" {
+				err = errors.New(item.Invalid)
+			} else {
+				err = rb.publishConfirm(ctx, item.Exchange, item.RouteKey, false, false, item.publishing(), timeout)
+			}
+			if err == nil {
+				rb.spoolHead(item, nil)
+				count++
+				continue
+			}
+			if !rb.spoolHead(item, err) {
+				break
+			}
+			// poison message
+			if e := rb.ops.spool.Dead(ctx, item); e != nil {
+				err = errors.Wrap(e, "move spool message to dead area failed")
+				break
+			}
+			atomic.AddUint64(&rb.dead, 1)
+			log.WithContext(ctx).WithError(err).WithFields(map[string]interface{}{
+				"Id":       item.Id,
+				"Exchange": item.Exchange,
+				"RouteKey": item.RouteKey,
+			}).Error("spool message can not be replayed, moved to dead area")
+			rb.spoolHead(item, nil)
+			err = nil
+			count++
+		}
+		if count > 0 {
+			e := rb.ops.spool.Remove(ctx, count)
+			if e != nil {
+				// messages will be replayed again, consumer should be idempotent
+				atomic.AddUint64(&rb.failed, 1)
+				log.WithContext(ctx).WithError(e).Error("remove replayed spool failed")
+				return
+			}
+			atomic.AddUint64(&rb.replayed, uint64(count))
+		}
+		if err != nil {
+			atomic.AddUint64(&rb.failed, 1)
+			log.WithContext(ctx).WithError(err).Warn("replay spool failed")
+			return
+		}
+	}
+}
+
+// spoolHead record replay result of head message, returns true if it is poison(should be moved to dead area):
+// invalid message, or nack/channel closed(e.g. exchange not found) WithSpoolMaxAttempts times
+func (rb *Rabbit) spoolHead(item SpoolMessage, err error) (poison bool) {
+	rb.headLock.Lock()
+	defer rb.headLock.Unlock()
+	if err == nil {
+		rb.head = spoolHead{}
+		return
+	}
+	key := fmt.Sprintf("%s.%d.%s.%s", item.Id, item.Time, item.Exchange, item.RouteKey)
+	if rb.head.key != key {
+		rb.head = spoolHead{
+			key: key,
+			id:  item.Id,
+		}
+	}
+	rb.head.err = err.Error()
+	if item.Invalid != "" {
+		poison = true
+		return
+	}
+	// timeout/cancelled may be caused by broker, not the message
+	if errors.Is(err, errBrokerNack) || errors.Is(err, errChannelClosed) {
+		rb.head.attempts++
+	}
+	poison = rb.head.attempts >= rb.ops.spoolMaxAttempts
+	return
+}
+
 // bind a exchange
 func (rb *Rabbit) Exchange(options ...func(*ExchangeOptions)) *Exchange {
 	ex := rb.beforeExchange(options...)
@@ -117,6 +320,14 @@ func (rb *Rabbit) Exchange(options ...func(*ExchangeOptions)) *Exchange {
 			return ex
 		}
 	}
+	// delayed topology can also be declared by the first delayed publish
+	if ex.ops.delay {
+		_, err := ex.setupDelay()
+		if err != nil {
+			ex.Error = errors.WithStack(err)
+			return ex
+		}
+	}
 	return ex
 }
 
@@ -174,6 +385,20 @@ func (ex *Exchange) Queue(options ...func(*QueueOptions)) *Queue {
 			return qu
 		}
 	}
+	if qu.ops.retry > 0 {
+		if qu.ops.declare {
+			err := qu.declareRetry()
+			if err != nil {
+				qu.Error = errors.WithStack(err)
+				return qu
+			}
+		} else if err := qu.checkRetry(); err != nil {
+			// failed messages are nacked as usual, publishing to missing queue loses them
+			log.WithContext(qu.ex.rb.ops.ctx).WithError(err).Warn("retry queues are missing, retry is disabled")
+			return qu
+		}
+		qu.retryReady = true
+	}
 	return qu
 }
 
@@ -0,0 +1,300 @@
+package mq
+
+import (
+	"context"
+	"fmt"
+	"github.com/ennismar/go-helper/pkg/log"
+	"github.com/pkg/errors"
+	"github.com/streadway/amqp"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	retryCountHeader     = "x-retry-count"
+	retryReasonHeader    = "x-retry-reason"
+	originExchangeHeader = "x-origin-exchange"
+	originKeyHeader      = "x-origin-routing-key"
+	parkingReasonHeader  = "x-parking-reason"
+	parkingTimeHeader    = "x-parking-time"
+)
+
+// ParkedMessage message in parking lot queue, it failed after all retries
+type ParkedMessage struct {
+	MessageId  string
+	Reason     string
+	RetryCount int32
+	ParkedAt   time.Time
+	// exchange/routing key of the first delivery
+	Exchange string
+	RouteKey string
+	Headers  amqp.Table
+	Body     []byte
+}
+
+type consumeReasonKey struct{}
+
+type consumeReason struct {
+	lock   sync.Mutex
+	reason string
+}
+
+// SetConsumeReason save failure reason of consume handler, it is saved in retry/parking message headers
+func SetConsumeReason(ctx context.Context, reason string) {
+	if r, ok := ctx.Value(consumeReasonKey{}).(*consumeReason); ok {
+		r.lock.Lock()
+		r.reason = reason
+		r.lock.Unlock()
+	}
+}
+
+func newReasonContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, consumeReasonKey{}, &consumeReason{})
+}
+
+func getConsumeReason(ctx context.Context) string {
+	if r, ok := ctx.Value(consumeReasonKey{}).(*consumeReason); ok {
+		r.lock.Lock()
+		defer r.lock.Unlock()
+		return r.reason
+	}
+	return ""
+}
+
+func (qu *Queue) retryName(n int32) string {
+	return fmt.Sprintf("%s.retry.%d", qu.ops.name, n)
+}
+
+func (qu *Queue) parkingName() string {
+	return qu.ops.name + ".parking"
+}
+
+// retryTTL ttl of the nth retry queue, base*2^(n-1)
+func (qu *Queue) retryTTL(n int32) int32 {
+	ttl := float64(qu.ops.retryBase.Milliseconds()) * math.Pow(2, float64(n-1))
+	if ttl > math.MaxInt32 {
+		ttl = math.MaxInt32
+	}
+	return int32(ttl)
+}
+
+// declareRetry declare retry queues(dead letter to queue by default exchange) and parking lot queue
+func (qu *Queue) declareRetry() (err error) {
+	ch := qu.ex.rb.pool.GetChannelFromPool()
+	defer func() {
+		qu.ex.rb.pool.ReturnChannel(ch, true)
+	}()
+	for i := int32(1); i <= qu.ops.retry; i++ {
+		name := qu.retryName(i)
+		if _, err = ch.Channel.QueueDeclare(
+			name,
+			qu.ops.durable,
+			qu.ops.autoDelete,
+			false,
+			false,
+			amqp.Table{
+				"x-message-ttl":             qu.retryTTL(i),
+				"x-dead-letter-exchange":    "",
+				"x-dead-letter-routing-key": qu.ops.name,
+			},
+		); err != nil {
+			err = errors.Wrapf(err, "failed to declare retry queue %s", name)
+			return
+		}
+	}
+	if _, err = ch.Channel.QueueDeclare(
+		qu.parkingName(),
+		qu.ops.durable,
+		false,
+		false,
+		false,
+		nil,
+	); err != nil {
+		err = errors.Wrapf(err, "failed to declare parking queue %s", qu.parkingName())
+	}
+	return
+}
+
+// checkRetry passive declare retry queues and parking lot queue
+func (qu *Queue) checkRetry() (err error) {
+	// passive declare closes channel if queue is missing, do not use pooled channel
+	ch := qu.ex.rb.pool.GetTransientChannel(false)
+	defer ch.Close()
+	names := make([]string, 0, qu.ops.retry+1)
+	for i := int32(1); i <= qu.ops.retry; i++ {
+		names = append(names, qu.retryName(i))
+	}
+	names = append(names, qu.parkingName())
+	for _, name := range names {
+		if _, err = ch.QueueDeclarePassive(name, qu.ops.durable, false, false, false, nil); err != nil {
+			err = errors.Wrapf(err, "failed to check retry queue %s", name)
+			return
+		}
+	}
+	return
+}
+
+// retryEnabled retry only if retry queues are ready, otherwise the unroutable message is confirmed and lost after ack
+func (qu *Queue) retryEnabled(ops ConsumeOptions) bool {
+	return ops.nackRetry && qu.ops.retry > 0 && qu.retryReady
+}
+
+// retry publish failed delivery to next retry queue, or parking lot queue after all retries
+func (qu *Queue) retryDelivery(ctx context.Context, d amqp.Delivery, reason string) (err error) {
+	headers := make(amqp.Table, len(d.Headers)+3)
+	for k, v := range d.Headers {
+		headers[k] = v
+	}
+	count, _ := headers[retryCountHeader].(int32)
+	if _, ok := headers[originExchangeHeader]; !ok {
+		headers[originExchangeHeader] = d.Exchange
+		headers[originKeyHeader] = d.RoutingKey
+	}
+	if reason == "" {
+		reason = "consume handler failed"
+	}
+	name := qu.parkingName()
+	if count < qu.ops.retry {
+		count++
+		name = qu.retryName(count)
+		headers[retryCountHeader] = count
+		headers[retryReasonHeader] = reason
+	} else {
+		headers[parkingReasonHeader] = reason
+		headers[parkingTimeHeader] = time.Now().Unix()
+		log.WithContext(ctx).WithFields(map[string]interface{}{
+			"Queue":     qu.ops.name,
+			"MessageId": d.MessageId,
+			"Reason":    reason,
+		}).Warn("maximum retry exceeded, message is parked")
+	}
+	err = qu.ex.rb.publishConfirm(
+		ctx,
+		"",
+		name,
+		false,
+		false,
+		amqp.Publishing{
+			Headers:         headers,
+			ContentType:     d.ContentType,
+			ContentEncoding: d.ContentEncoding,
+			DeliveryMode:    d.DeliveryMode,
+			Priority:        d.Priority,
+			CorrelationId:   d.CorrelationId,
+			ReplyTo:         d.ReplyTo,
+			MessageId:       d.MessageId,
+			Timestamp:       d.Timestamp,
+			Type:            d.Type,
+			AppId:           d.AppId,
+			Body:            d.Body,
+		},
+		time.Duration(qu.ex.rb.ops.timeout)*time.Second,
+	)
+	return
+}
+
+// FindParked inspect parked messages, they are requeued to parking lot queue after read
+func (qu *Queue) FindParked(size int) (rp []ParkedMessage, err error) {
+	if qu.Error != nil {
+		err = errors.WithStack(qu.Error)
+		return
+	}
+	ch := qu.ex.rb.pool.GetChannelFromPool()
+	defer func() {
+		qu.ex.rb.pool.ReturnChannel(ch, true)
+	}()
+	var ds []amqp.Delivery
+	ds, err = qu.getBatch(ch, qu.parkingName(), size, false)
+	if len(ds) > 0 {
+		// requeue all read messages
+		if e := ch.Channel.Nack(ds[len(ds)-1].DeliveryTag, true, true); e != nil && err == nil {
+			err = e
+		}
+	}
+	if err != nil {
+		err = errors.WithStack(err)
+		return
+	}
+	rp = make([]ParkedMessage, 0, len(ds))
+	for _, d := range ds {
+		rp = append(rp, newParkedMessage(d))
+	}
+	return
+}
+
+// ReplayParked move parked messages back to queue with retry count reset, returns replayed count
+func (qu *Queue) ReplayParked(size int) (count int, err error) {
+	if qu.Error != nil {
+		err = errors.WithStack(qu.Error)
+		return
+	}
+	ch := qu.ex.rb.pool.GetChannelFromPool()
+	defer func() {
+		qu.ex.rb.pool.ReturnChannel(ch, true)
+	}()
+	timeout := time.Duration(qu.ex.rb.ops.timeout) * time.Second
+	for count < size {
+		d, ok, e := ch.Channel.Get(qu.parkingName(), false)
+		if e != nil {
+			err = errors.WithStack(e)
+			return
+		}
+		if !ok {
+			return
+		}
+		headers := make(amqp.Table, len(d.Headers))
+		for k, v := range d.Headers {
+			headers[k] = v
+		}
+		for _, key := range []string{retryCountHeader, retryReasonHeader, parkingReasonHeader, parkingTimeHeader} {
+			delete(headers, key)
+		}
+		err = qu.ex.rb.publishConfirm(
+			qu.ex.rb.ops.ctx,
+			"",
+			qu.ops.name,
+			false,
+			false,
+			amqp.Publishing{
+				Headers:      headers,
+				ContentType:  d.ContentType,
+				DeliveryMode: d.DeliveryMode,
+				MessageId:    d.MessageId,
+				Timestamp:    d.Timestamp,
+				Type:         d.Type,
+				AppId:        d.AppId,
+				Body:         d.Body,
+			},
+			timeout,
+		)
+		if err != nil {
+			d.Nack(false, true)
+			err = errors.Wrapf(err, "replay parked message %s failed", d.MessageId)
+			return
+		}
+		err = d.Ack(false)
+		if err != nil {
+			err = errors.WithStack(err)
+			return
+		}
+		count++
+	}
+	return
+}
+
+func newParkedMessage(d amqp.Delivery) ParkedMessage {
+	rp := ParkedMessage{
+		MessageId: d.MessageId,
+		Headers:   d.Headers,
+		Body:      d.Body,
+	}
+	rp.Reason, _ = d.Headers[parkingReasonHeader].(string)
+	rp.RetryCount, _ = d.Headers[retryCountHeader].(int32)
+	rp.Exchange, _ = d.Headers[originExchangeHeader].(string)
+	rp.RouteKey, _ = d.Headers[originKeyHeader].(string)
+	if v, ok := d.Headers[parkingTimeHeader].(int64); ok {
+		rp.ParkedAt = time.Unix(v, 0)
+	}
+	return rp
+}
@@ -0,0 +1,374 @@
+package mq
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+	"github.com/streadway/amqp"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Spool durable buffer of unconfirmed messages, Rabbit replays them in order after reconnected(see WithSpool)
+type Spool interface {
+	// Push append message to the tail
+	Push(ctx context.Context, msg SpoolMessage) error
+	// Peek read first size messages without removing them
+	Peek(ctx context.Context, size int) ([]SpoolMessage, error)
+	// Remove delete first count messages
+	Remove(ctx context.Context, count int) error
+	// Len spool depth
+	Len(ctx context.Context) (int64, error)
+	// Dead save message can never be replayed to dead area, it is removed by Remove after that
+	Dead(ctx context.Context, msg SpoolMessage) error
+}
+
+// SpoolMessage message saved in spool, all properties of amqp.Publishing are kept
+type SpoolMessage struct {
+	Id              string     `json:"id"`
+	Exchange        string     `json:"exchange"`
+	RouteKey        string     `json:"routeKey"`
+	ContentType     string     `json:"contentType"`
+	ContentEncoding string     `json:"contentEncoding,omitempty"`
+	DeliveryMode    uint8      `json:"deliveryMode"`
+	Priority        uint8      `json:"priority,omitempty"`
+	CorrelationId   string     `json:"correlationId,omitempty"`
+	ReplyTo         string     `json:"replyTo,omitempty"`
+	Expiration      string     `json:"expiration"`
+	Timestamp       time.Time  `json:"timestamp,omitempty"`
+	Type            string     `json:"type,omitempty"`
+	UserId          string     `json:"userId,omitempty"`
+	AppId           string     `json:"appId,omitempty"`
+	Headers         amqp.Table `json:"headers"`
+	Body            []byte     `json:"body"`
+	// spooled unix timestamp
+	Time int64 `json:"time"`
+	// decode error of invalid message returned by Peek(its position is kept), Raw is the saved data
+	Invalid string `json:"-"`
+	Raw     []byte `json:"-"`
+}
+
+// SpoolStats spool metrics of Rabbit
+type SpoolStats struct {
+	// messages in spool now
+	Depth int64 `json:"depth"`
+	// total messages saved to spool
+	Spooled uint64 `json:"spooled"`
+	// total messages replayed successfully
+	Replayed uint64 `json:"replayed"`
+	// total spool read/write/replay errors
+	Failed uint64 `json:"failed"`
+	// total messages moved to dead area(invalid or failed WithSpoolMaxAttempts times)
+	Dead uint64 `json:"dead"`
+	// replay is stalled by the head message till it is replayed or moved to dead area
+	HeadId       string `json:"headId"`
+	HeadAttempts int    `json:"headAttempts"`
+	HeadError    string `json:"headError"`
+}
+
+func newSpoolMessage(exchange, key string, msg amqp.Publishing) SpoolMessage {
+	return SpoolMessage{
+		Id:              msg.MessageId,
+		Exchange:        exchange,
+		RouteKey:        key,
+		ContentType:     msg.ContentType,
+		ContentEncoding: msg.ContentEncoding,
+		DeliveryMode:    msg.DeliveryMode,
+		Priority:        msg.Priority,
+		CorrelationId:   msg.CorrelationId,
+		ReplyTo:         msg.ReplyTo,
+		Expiration:      msg.Expiration,
+		Timestamp:       msg.Timestamp,
+		Type:            msg.Type,
+		UserId:          msg.UserId,
+		AppId:           msg.AppId,
+		Headers:         msg.Headers,
+		Body:            msg.Body,
+		Time:            time.Now().Unix(),
+	}
+}
+
+func (sm SpoolMessage) publishing() amqp.Publishing {
+	timestamp := sm.Timestamp
+	if timestamp.IsZero() {
+		// spooled by old version
+		timestamp = time.Unix(sm.Time, 0)
+	}
+	return amqp.Publishing{
+		MessageId:       sm.Id,
+		ContentType:     sm.ContentType,
+		ContentEncoding: sm.ContentEncoding,
+		DeliveryMode:    sm.DeliveryMode,
+		Priority:        sm.Priority,
+		CorrelationId:   sm.CorrelationId,
+		ReplyTo:         sm.ReplyTo,
+		Expiration:      sm.Expiration,
+		Timestamp:       timestamp,
+		Type:            sm.Type,
+		UserId:          sm.UserId,
+		AppId:           sm.AppId,
+		Headers:         sm.Headers,
+		Body:            sm.Body,
+	}
+}
+
+func encodeSpoolMessage(msg SpoolMessage) ([]byte, error) {
+	if msg.Invalid != "" {
+		return msg.Raw, nil
+	}
+	return json.Marshal(msg)
+}
+
+// invalidSpoolMessage placeholder of undecodable data, replay moves it to dead area
+func invalidSpoolMessage(data []byte, err error) SpoolMessage {
+	return SpoolMessage{
+		Invalid: err.Error(),
+		Raw:     data,
+	}
+}
+
+// decodeSpoolMessage json numbers in headers are restored to int32/int64/float64(amqp.Table does not support float64 integer as x-retry-count)
+func decodeSpoolMessage(data []byte) (msg SpoolMessage, err error) {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	err = d.Decode(&msg)
+	if err != nil {
+		return
+	}
+	if msg.Headers != nil {
+		msg.Headers = spoolHeader(msg.Headers).(amqp.Table)
+	}
+	return
+}
+
+func spoolHeader(v interface{}) interface{} {
+	switch val := v.(type) {
+	case json.Number:
+		if i, err := val.Int64(); err == nil {
+			if i >= math.MinInt32 && i <= math.MaxInt32 {
+				return int32(i)
+			}
+			return i
+		}
+		f, _ := val.Float64()
+		return f
+	case amqp.Table:
+		for k, item := range val {
+			val[k] = spoolHeader(item)
+		}
+		return val
+	case map[string]interface{}:
+		return spoolHeader(amqp.Table(val))
+	case []interface{}:
+		for i, item := range val {
+			val[i] = spoolHeader(item)
+		}
+		return val
+	}
+	return v
+}
+
+// RedisSpool spool by redis list, one key should be used by only one Rabbit
+type RedisSpool struct {
+	redis redis.UniversalClient
+	key   string
+}
+
+func NewRedisSpool(rd redis.UniversalClient, key string) *RedisSpool {
+	if key == "" {
+		key = "mq.spool"
+	}
+	return &RedisSpool{
+		redis: rd,
+		key:   key,
+	}
+}
+
+func (rs RedisSpool) Push(ctx context.Context, msg SpoolMessage) (err error) {
+	var data []byte
+	data, err = encodeSpoolMessage(msg)
+	if err != nil {
+		return
+	}
+	err = rs.redis.RPush(ctx, rs.key, data).Err()
+	return
+}
+
+func (rs RedisSpool) Peek(ctx context.Context, size int) (rp []SpoolMessage, err error) {
+	var list []string
+	list, err = rs.redis.LRange(ctx, rs.key, 0, int64(size-1)).Result()
+	if err != nil {
+		return
+	}
+	rp = make([]SpoolMessage, 0, len(list))
+	for _, item := range list {
+		var msg SpoolMessage
+		var e error
+		msg, e = decodeSpoolMessage([]byte(item))
+		if e != nil {
+			msg = invalidSpoolMessage([]byte(item), e)
+		}
+		rp = append(rp, msg)
+	}
+	return
+}
+
+func (rs RedisSpool) Remove(ctx context.Context, count int) error {
+	if count <= 0 {
+		return nil
+	}
+	return rs.redis.LTrim(ctx, rs.key, int64(count), -1).Err()
+}
+
+func (rs RedisSpool) Len(ctx context.Context) (int64, error) {
+	return rs.redis.LLen(ctx, rs.key).Result()
+}
+
+// Dead push message to list <key>.dead
+func (rs RedisSpool) Dead(ctx context.Context, msg SpoolMessage) (err error) {
+	var data []byte
+	data, err = encodeSpoolMessage(msg)
+	if err != nil {
+		return
+	}
+	err = rs.redis.RPush(ctx, rs.key+".dead", data).Err()
+	return
+}
+
+// FileSpool spool by local dir, each message is saved as a json file
+type FileSpool struct {
+	dir  string
+	lock sync.Mutex
+	seq  int64
+}
+
+func NewFileSpool(dir string) *FileSpool {
+	return &FileSpool{
+		dir: dir,
+	}
+}
+
+func (fs *FileSpool) Push(_ context.Context, msg SpoolMessage) (err error) {
+	var data []byte
+	data, err = encodeSpoolMessage(msg)
+	if err != nil {
+		return
+	}
+	err = os.MkdirAll(fs.dir, os.ModePerm)
+	if err != nil {
+		return
+	}
+	fs.lock.Lock()
+	// keep file names ordered even if the clock goes back
+	seq := time.Now().UnixNano()
+	if seq <= fs.seq {
+		seq = fs.seq + 1
+	}
+	fs.seq = seq
+	fs.lock.Unlock()
+	name := filepath.Join(fs.dir, fmt.Sprintf("%019d.json", seq))
+	// write tmp file first, half-written message will not be replayed
+	tmp := name + ".tmp"
+	err = ioutil.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return
+	}
+	err = os.Rename(tmp, name)
+	return
+}
+
+func (fs *FileSpool) Peek(_ context.Context, size int) (rp []SpoolMessage, err error) {
+	var names []string
+	names, err = fs.names()
+	if err != nil {
+		return
+	}
+	if len(names) > size {
+		names = names[:size]
+	}
+	rp = make([]SpoolMessage, 0, len(names))
+	for _, name := range names {
+		var data []byte
+		data, err = ioutil.ReadFile(filepath.Join(fs.dir, name))
+		if err != nil {
+			return
+		}
+		var msg SpoolMessage
+		var e error
+		msg, e = decodeSpoolMessage(data)
+		if e != nil {
+			msg = invalidSpoolMessage(data, errors.Wrapf(e, "invalid spool file %s", name))
+		}
+		rp = append(rp, msg)
+	}
+	return
+}
+
+func (fs *FileSpool) Remove(_ context.Context, count int) (err error) {
+	var names []string
+	names, err = fs.names()
+	if err != nil {
+		return
+	}
+	if len(names) > count {
+		names = names[:count]
+	}
+	for _, name := range names {
+		err = os.Remove(filepath.Join(fs.dir, name))
+		if err != nil && !os.IsNotExist(err) {
+			return
+		}
+	}
+	err = nil
+	return
+}
+
+func (fs *FileSpool) Len(_ context.Context) (int64, error) {
+	names, err := fs.names()
+	return int64(len(names)), err
+}
+
+// Dead save message to <dir>/dead, file names are ordered too
+func (fs *FileSpool) Dead(_ context.Context, msg SpoolMessage) (err error) {
+	var data []byte
+	data, err = encodeSpoolMessage(msg)
+	if err != nil {
+		return
+	}
+	dir := filepath.Join(fs.dir, "dead")
+	err = os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("%019d.json", time.Now().UnixNano())), data, 0644)
+	return
+}
+
+// names sorted message file names
+func (fs *FileSpool) names() (rp []string, err error) {
+	var list []os.FileInfo
+	list, err = ioutil.ReadDir(fs.dir)
+	if os.IsNotExist(err) {
+		err = nil
+		return
+	}
+	if err != nil {
+		return
+	}
+	for _, item := range list {
+		if !item.IsDir() && strings.HasSuffix(item.Name(), ".json") {
+			rp = append(rp, item.Name())
+		}
+	}
+	sort.Strings(rp)
+	return
+}
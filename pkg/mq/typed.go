@@ -0,0 +1,197 @@
+package mq
+
+import (
+	"context"
+	"github.com/ennismar/go-helper/pkg/log"
+	"github.com/pkg/errors"
+	"github.com/streadway/amqp"
+	"google.golang.org/protobuf/proto"
+	"sync"
+	"time"
+)
+
+const (
+	// MessageTypeHeader message type of typed message, Router dispatches by it
+	MessageTypeHeader = "x-message-type"
+	deadReasonHeader  = "x-dead-letter-reason"
+)
+
+// TypedHandler handle decoded message, m is created by the factory of Router.Handle
+type TypedHandler func(ctx context.Context, m interface{}, d amqp.Delivery) bool
+
+// Router typed handlers by message type
+type Router struct {
+	lock   sync.RWMutex
+	routes map[string]typedRoute
+}
+
+type typedRoute struct {
+	new     func() interface{}
+	handler TypedHandler
+}
+
+func NewRouter() *Router {
+	return &Router{
+		routes: make(map[string]typedRoute),
+	}
+}
+
+// Handle register handler of message type, newFun returns a new pointer to decode into
+func (r *Router) Handle(msgType string, newFun func() interface{}, handler TypedHandler) {
+	if msgType == "" || newFun == nil || handler == nil {
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.routes[msgType] = typedRoute{
+		new:     newFun,
+		handler: handler,
+	}
+}
+
+// HandleProto register handler of proto message, message type is the proto full name(same as PublishTyped)
+func (r *Router) HandleProto(m proto.Message, handler TypedHandler) {
+	if m == nil {
+		return
+	}
+	r.Handle(protoName(m), func() interface{} {
+		return m.ProtoReflect().New().Interface()
+	}, handler)
+}
+
+func (r *Router) decode(codecs *Codecs, d amqp.Delivery) (m interface{}, handler TypedHandler, err error) {
+	msgType, _ := d.Headers[MessageTypeHeader].(string)
+	if msgType == "" {
+		msgType = d.Type
+	}
+	if msgType == "" {
+		err = errors.Errorf("message type is empty")
+		return
+	}
+	r.lock.RLock()
+	route, ok := r.routes[msgType]
+	r.lock.RUnlock()
+	if !ok {
+		err = errors.Errorf("no handler of message type %s", msgType)
+		return
+	}
+	cd, ok := codecs.Get(d.ContentType)
+	if !ok {
+		err = errors.Errorf("unsupported content type %s", d.ContentType)
+		return
+	}
+	m = route.new()
+	err = cd.Unmarshal(d.Body, m)
+	if err != nil {
+		err = errors.Wrapf(err, "decode %s by %s failed", msgType, cd.ContentType())
+		return
+	}
+	handler = route.handler
+	return
+}
+
+// ConsumeTyped consume by ConsumeWorker, deliveries are decoded by codec of content type and dispatched by message type,
+// undecodable messages are sent to dead letter of queue with reason(nacked if dead letter is not set)
+func (qu *Queue) ConsumeTyped(r *Router, options ...func(*ConsumeOptions)) (w *Worker, err error) {
+	if r == nil {
+		err = errors.Errorf("router is nil")
+		return
+	}
+	w, err = qu.ConsumeWorker(func(ctx context.Context, q string, d amqp.Delivery) bool {
+		m, handler, e := r.decode(qu.ex.rb.ops.codecs, d)
+		if e != nil {
+			e = qu.deadLetter(ctx, d, e.Error())
+			if e != nil {
+				log.WithContext(ctx).WithError(e).Error("consume typed dead letter failed")
+				return false
+			}
+			return true
+		}
+		return handler(ctx, m, d)
+	}, options...)
+	return
+}
+
+// deadLetter publish delivery to dead letter exchange of queue with reason header
+func (qu *Queue) deadLetter(ctx context.Context, d amqp.Delivery, reason string) (err error) {
+	log.WithContext(ctx).WithFields(map[string]interface{}{
+		"Queue":     qu.ops.name,
+		"MessageId": d.MessageId,
+		"Reason":    reason,
+	}).Warn("message is dead")
+	if qu.ops.deadLetterName == "" {
+		err = errors.Errorf("dead letter of queue %s is empty", qu.ops.name)
+		return
+	}
+	key := qu.ops.deadLetterKey
+	if key == "" {
+		key = d.RoutingKey
+	}
+	headers := make(amqp.Table, len(d.Headers)+1)
+	for k, v := range d.Headers {
+		headers[k] = v
+	}
+	headers[deadReasonHeader] = reason
+	err = qu.ex.rb.publishConfirm(
+		ctx,
+		qu.ops.deadLetterName,
+		key,
+		false,
+		false,
+		amqp.Publishing{
+			Headers:      headers,
+			ContentType:  d.ContentType,
+			DeliveryMode: d.DeliveryMode,
+			MessageId:    d.MessageId,
+			Timestamp:    d.Timestamp,
+			Type:         d.Type,
+			AppId:        d.AppId,
+			Body:         d.Body,
+		},
+		time.Duration(qu.ex.rb.ops.timeout)*time.Second,
+	)
+	return
+}
+
+// PublishTyped encode m by codec of content type and set message type header,
+// default content type is protobuf for proto.Message(message type is the proto full name if empty) and json for others
+func (ex *Exchange) PublishTyped(msgType string, m interface{}, options ...func(*PublishOptions)) (err error) {
+	if ex.Error != nil {
+		err = errors.WithStack(ex.Error)
+		return
+	}
+	contentType := JsonContentType
+	if pm, ok := m.(proto.Message); ok {
+		contentType = ProtoContentType
+		if msgType == "" {
+			msgType = protoName(pm)
+		}
+	}
+	if msgType == "" {
+		err = errors.Errorf("message type is empty")
+		return
+	}
+	options = append([]func(*PublishOptions){WithPublishContentType(contentType)}, options...)
+	options = append(options, WithPublishMessageType(msgType))
+	ops := getPublishOptionsOrSetDefault(nil)
+	for _, f := range options {
+		f(ops)
+	}
+	cd, ok := ex.rb.ops.codecs.Get(ops.contentType)
+	if !ok {
+		err = errors.Errorf("unsupported content type %s", ops.contentType)
+		return
+	}
+	var b []byte
+	b, err = cd.Marshal(m)
+	if err != nil {
+		err = errors.WithStack(err)
+		return
+	}
+	err = ex.PublishByte(b, options...)
+	return
+}
+
+func protoName(m proto.Message) string {
+	return string(m.ProtoReflect().Descriptor().FullName())
+}
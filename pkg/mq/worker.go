@@ -0,0 +1,293 @@
+package mq
+
+import (
+	"context"
+	"fmt"
+	"github.com/ennismar/go-helper/pkg/log"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/streadway/amqp"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Worker concurrent consumer of queue, deliveries are handled by workers(or batches) and acked in order
+type Worker struct {
+	co       *Consume
+	ops      ConsumeOptions
+	qu       *Queue
+	q        string
+	tag      string
+	handler  func(context.Context, string, []amqp.Delivery) bool
+	jobs     chan workerJob
+	lock     sync.Mutex
+	ch       *amqp.Channel
+	stopping int32
+	done     chan struct{}
+}
+
+type workerJob struct {
+	ds    []amqp.Delivery
+	acker *acker
+}
+
+// acker settle deliveries of one channel by delivery tag order:
+// continuous successes are acked by one multiple ack, failures are nacked one by one
+type acker struct {
+	ch      *amqp.Channel
+	requeue bool
+	lock    sync.Mutex
+	next    uint64
+	results map[uint64]bool
+	wg      sync.WaitGroup
+}
+
+// ConsumeWorker consume by WithConsumeWorkers concurrent handlers, use Stop to drain it
+func (qu *Queue) ConsumeWorker(handler func(context.Context, string, amqp.Delivery) bool, options ...func(*ConsumeOptions)) (w *Worker, err error) {
+	if handler == nil {
+		err = errors.Errorf("handler is nil")
+		return
+	}
+	w, err = qu.ConsumeBatch(func(ctx context.Context, q string, ds []amqp.Delivery) bool {
+		return handler(ctx, q, ds[0])
+	}, append(options, WithConsumeBatch(1, 0))...)
+	return
+}
+
+// ConsumeBatch handler receives up to WithConsumeBatch size deliveries(or less if waited too long), all of them are acked/nacked by the result
+func (qu *Queue) ConsumeBatch(handler func(context.Context, string, []amqp.Delivery) bool, options ...func(*ConsumeOptions)) (w *Worker, err error) {
+	if handler == nil {
+		err = errors.Errorf("handler is nil")
+		return
+	}
+	co := qu.beforeConsume(options...)
+	if co.Error != nil {
+		err = errors.WithStack(co.Error)
+		return
+	}
+	w = &Worker{
+		co:      co,
+		ops:     co.ops,
+		qu:      qu,
+		q:       co.q,
+		tag:     co.ops.consumer,
+		handler: handler,
+		jobs:    make(chan workerJob, co.ops.workers),
+		done:    make(chan struct{}),
+	}
+	if w.tag == "" {
+		w.tag = fmt.Sprintf("%s-%s", co.q, uuid.NewString()[:8])
+	}
+	// prefetch should be enough to fill all workers
+	if size := w.ops.workers * w.ops.batchSize; w.ops.qosPrefetchCount < size {
+		w.ops.qosPrefetchCount = size
+	}
+	var wg sync.WaitGroup
+	wg.Add(w.ops.workers)
+	for i := 0; i < w.ops.workers; i++ {
+		go func() {
+			defer wg.Done()
+			w.work()
+		}()
+	}
+	go func() {
+		w.run()
+		close(w.jobs)
+		wg.Wait()
+		close(w.done)
+	}()
+	return
+}
+
+// Stop cancel consumer and wait in-flight deliveries handled and acks settled,
+// channel is closed if ctx is done first(unacked deliveries will be redelivered by broker)
+func (w *Worker) Stop(ctx context.Context) (err error) {
+	if atomic.CompareAndSwapInt32(&w.stopping, 0, 1) {
+		w.lock.Lock()
+		if w.ch != nil {
+			// deliveries channel will be closed after all sent deliveries received
+			e := w.ch.Cancel(w.tag, false)
+			if e != nil {
+				w.ch.Close()
+			}
+		}
+		w.lock.Unlock()
+	}
+	select {
+	case <-w.done:
+	case <-ctx.Done():
+		w.lock.Lock()
+		if w.ch != nil {
+			w.ch.Close()
+		}
+		w.lock.Unlock()
+		err = errors.Wrapf(ctx.Err(), "stop consumer %s", w.tag)
+	}
+	return
+}
+
+func (w *Worker) run() {
+	rb := w.qu.ex.rb
+	for atomic.LoadInt32(&w.stopping) == 0 {
+		// blocked till connection is available
+		ch := rb.pool.GetTransientChannel(false)
+		deliveries, err := w.consume(ch)
+		if err == nil && deliveries == nil {
+			// stopped before consuming
+			ch.Close()
+			break
+		}
+		if err != nil {
+			ch.Close()
+			log.WithContext(rb.ops.ctx).WithError(err).Error("consume worker failed")
+			time.Sleep(time.Duration(rb.ops.healthCheckInterval) * time.Millisecond)
+			continue
+		}
+		a := &acker{
+			ch:      ch,
+			requeue: w.ops.nackRequeue,
+			results: make(map[uint64]bool),
+		}
+		// return when consumer is cancelled or channel is closed
+		w.read(deliveries, a)
+		a.wg.Wait()
+		w.lock.Lock()
+		w.ch = nil
+		ch.Close()
+		w.lock.Unlock()
+	}
+}
+
+func (w *Worker) consume(ch *amqp.Channel) (deliveries <-chan amqp.Delivery, err error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	// Stop is called before channel is ready
+	if atomic.LoadInt32(&w.stopping) == 1 {
+		return
+	}
+	err = ch.Qos(w.ops.qosPrefetchCount, 0, false)
+	if err != nil {
+		return
+	}
+	deliveries, err = ch.Consume(w.q, w.tag, w.ops.autoAck, w.ops.exclusive, false, w.ops.noWait, w.ops.args)
+	if err != nil {
+		return
+	}
+	w.ch = ch
+	return
+}
+
+// read group deliveries into batches by size or wait time
+func (w *Worker) read(deliveries <-chan amqp.Delivery, a *acker) {
+	size := w.ops.batchSize
+	batch := make([]amqp.Delivery, 0, size)
+	var timer *time.Timer
+	var wait <-chan time.Time
+	flush := func() {
+		if timer != nil {
+			timer.Stop()
+			timer = nil
+			wait = nil
+		}
+		if len(batch) > 0 {
+			w.jobs <- workerJob{
+				ds:    batch,
+				acker: a,
+			}
+			batch = make([]amqp.Delivery, 0, size)
+		}
+	}
+	for {
+		select {
+		case d, ok := <-deliveries:
+			if !ok {
+				flush()
+				return
+			}
+			if !w.ops.autoAck {
+				a.add(d.DeliveryTag)
+			}
+			batch = append(batch, d)
+			if len(batch) >= size {
+				flush()
+			} else if timer == nil {
+				timer = time.NewTimer(time.Duration(w.ops.batchWait) * time.Millisecond)
+				wait = timer.C
+			}
+		case <-wait:
+			flush()
+		}
+	}
+}
+
+func (w *Worker) work() {
+	for job := range w.jobs {
+		ctx := newReasonContext(w.co.newContext(nil))
+		ok := w.handler(ctx, w.q, job.ds)
+		if w.ops.autoAck {
+			continue
+		}
+		results := make(map[uint64]bool, len(job.ds))
+		for _, d := range job.ds {
+			r := ok
+			// failed message is moved to retry queue, ack it
+			if !ok && w.qu.retryEnabled(w.ops) {
+				e := w.qu.retryDelivery(ctx, d, getConsumeReason(ctx))
+				if e != nil {
+					log.WithContext(ctx).WithError(e).Error("consume worker retry failed")
+				}
+				r = e == nil
+			}
+			results[d.DeliveryTag] = r
+		}
+		job.acker.done(ctx, results)
+	}
+}
+
+func (a *acker) add(tag uint64) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.next == 0 {
+		a.next = tag
+	}
+	a.wg.Add(1)
+}
+
+func (a *acker) done(ctx context.Context, results map[uint64]bool) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	for tag, ok := range results {
+		a.results[tag] = ok
+	}
+	var last uint64
+	for {
+		r, exists := a.results[a.next]
+		if !exists {
+			break
+		}
+		delete(a.results, a.next)
+		if r {
+			last = a.next
+		} else {
+			a.ack(ctx, last)
+			last = 0
+			if e := a.ch.Nack(a.next, false, a.requeue); e != nil {
+				log.WithContext(ctx).WithError(e).Error("consume worker nack failed")
+			}
+		}
+		a.next++
+		a.wg.Done()
+	}
+	a.ack(ctx, last)
+}
+
+// ack all deliveries up to tag
+func (a *acker) ack(ctx context.Context, tag uint64) {
+	if tag == 0 {
+		return
+	}
+	if e := a.ch.Ack(tag, true); e != nil {
+		log.WithContext(ctx).WithError(e).Error("consume worker ack failed")
+	}
+}
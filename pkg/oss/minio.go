@@ -93,7 +93,13 @@ func (mo *MinioOss) BatchRemove(ctx context.Context, bucketName string, objectNa
 }
 
 func (mo *MinioOss) GetPreviewUrl(ctx context.Context, bucketName, objectName string) (rp string) {
-	u, err := mo.client.PresignedGetObject(ctx, bucketName, objectName, time.Second*24*60*60, url.Values{})
+	rp, _ = mo.GetPresignedUrl(ctx, bucketName, objectName, time.Second*24*60*60)
+	return
+}
+
+func (mo *MinioOss) GetPresignedUrl(ctx context.Context, bucketName, objectName string, expire time.Duration) (rp string, err error) {
+	var u *url.URL
+	u, err = mo.client.PresignedGetObject(ctx, bucketName, objectName, expire, url.Values{})
 	if err != nil {
 		return
 	}
@@ -58,6 +58,8 @@ const (
 	MessageRespNormal    string = "2-2-1"
 	MessageRespUnRead    string = "2-3-1"
 	MessageRespOnline    string = "2-4-1"
+	// export progress of delay.Export
+	MessageRespExportProgress string = "2-5-1"
 )
 
 // var hub MessageHub
@@ -150,6 +152,14 @@ func (h *MessageHub) SendToUserIds(userIds []uint, msg resp.MessageWs) {
 	}
 }
 
+// SendExportProgress push delay export progress, it can be used by delay.WithExportPush
+func (h *MessageHub) SendExportProgress(userIds []uint, rp resp.DelayExportProgress) {
+	h.SendToUserIds(userIds, resp.MessageWs{
+		Type:   MessageRespExportProgress,
+		Detail: resp.GetSuccessWithData(rp),
+	})
+}
+
 func (h *MessageHub) run() {
 	for {
 		select {
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/ennismar/go-helper/pkg/constant"
 	"github.com/ennismar/go-helper/pkg/log"
+	"github.com/ennismar/go-helper/pkg/middleware"
 	"github.com/ennismar/go-helper/pkg/resp"
 	"github.com/ennismar/go-helper/pkg/tracing"
 	"github.com/ennismar/go-helper/pkg/utils"
@@ -61,6 +62,17 @@ func (my MySql) ForceCommit() {
 	}
 }
 
+// AfterCommit run fun after transaction of middleware committed(dropped if rollback), it runs at once without middleware transaction
+func (my MySql) AfterCommit(fun func()) {
+	if my.ops.ctx != nil {
+		if hooks, ok := my.ops.ctx.Value(constant.MiddlewareTransactionHooksCtxKey).(*middleware.TransactionHooks); ok {
+			hooks.Add(fun)
+			return
+		}
+	}
+	fun()
+}
+
 func (my MySql) GetById(id uint, model interface{}, options ...func(*MysqlReadOptions)) {
 	my.FindByColumns(id, model, options...)
 }
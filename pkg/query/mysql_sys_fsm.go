@@ -2,7 +2,9 @@ package query
 
 import (
 	"github.com/ennismar/go-helper/pkg/constant"
+	"github.com/ennismar/go-helper/pkg/delay"
 	"github.com/ennismar/go-helper/pkg/fsm"
+	"github.com/ennismar/go-helper/pkg/log"
 	"github.com/ennismar/go-helper/pkg/req"
 	"github.com/ennismar/go-helper/pkg/resp"
 	"github.com/ennismar/go-helper/pkg/tracing"
@@ -56,6 +58,7 @@ func (my MySql) FsmSubmitLog(r req.FsmCreateLog) (err error) {
 	my.ops.fsmOps = append(my.ops.fsmOps, fsm.WithCtx(my.Ctx), fsm.WithDb(my.Tx))
 	f := fsm.New(my.ops.fsmOps...)
 	f.SubmitLog(r)
+	my.flushFsmSla(f)
 	return f.Error
 }
 
@@ -66,6 +69,18 @@ func (my MySql) FsmApproveLog(r req.FsmApproveLog) (err error) {
 	my.ops.fsmOps = append(my.ops.fsmOps, fsm.WithCtx(my.Ctx), fsm.WithDb(my.Tx))
 	f := fsm.New(my.ops.fsmOps...)
 	f.ApproveLog(r)
+	my.flushFsmSla(f)
+	return f.Error
+}
+
+// FsmHandleSlaTask handle fsm sla(remind/escalate/timeout) task from delay queue
+func (my MySql) FsmHandleSlaTask(t delay.Task) error {
+	_, span := tracer.Start(my.Ctx, tracing.Name(tracing.Db, "FsmHandleSlaTask"))
+	defer span.End()
+	my.ops.fsmOps = append(my.ops.fsmOps, fsm.WithCtx(my.Ctx), fsm.WithDb(my.Tx))
+	f := fsm.New(my.ops.fsmOps...)
+	f.HandleSlaTask(t)
+	my.flushFsmSla(f)
 	return f.Error
 }
 
@@ -118,3 +133,94 @@ func (my MySql) DeleteFsmByIds(ids []uint) error {
 	f.DeleteMachineByIds(ids)
 	return f.Error
 }
+
+// FindFsmVersion find finite state machine versions
+func (my MySql) FindFsmVersion(r *req.FsmMachineVersion) []resp.FsmMachineVersion {
+	_, span := tracer.Start(my.Ctx, tracing.Name(tracing.Db, "FindFsmVersion"))
+	defer span.End()
+	my.ops.fsmOps = append(my.ops.fsmOps, fsm.WithCtx(my.Ctx), fsm.WithDb(my.Tx))
+	f := fsm.New(my.ops.fsmOps...)
+	return f.FindMachineVersion(r)
+}
+
+// DiffFsmVersion compare two finite state machine versions
+func (my MySql) DiffFsmVersion(r req.FsmDiffMachineVersion) (rp resp.FsmMachineVersionDiff, err error) {
+	_, span := tracer.Start(my.Ctx, tracing.Name(tracing.Db, "DiffFsmVersion"))
+	defer span.End()
+	my.ops.fsmOps = append(my.ops.fsmOps, fsm.WithCtx(my.Ctx), fsm.WithDb(my.Tx))
+	f := fsm.New(my.ops.fsmOps...)
+	rp = f.DiffMachineVersion(r)
+	err = f.Error
+	return
+}
+
+// ExportFsm export finite state machine definition as yaml/json
+func (my MySql) ExportFsm(r req.FsmExportMachine) (rp []byte, err error) {
+	_, span := tracer.Start(my.Ctx, tracing.Name(tracing.Db, "ExportFsm"))
+	defer span.End()
+	my.ops.fsmOps = append(my.ops.fsmOps, fsm.WithCtx(my.Ctx), fsm.WithDb(my.Tx))
+	f := fsm.New(my.ops.fsmOps...)
+	rp = f.ExportMachine(r)
+	err = f.Error
+	return
+}
+
+// DryRunFsm validate finite state machine definition without saving
+func (my MySql) DryRunFsm(r req.FsmImportMachine) (rp resp.FsmMachineDryRun, err error) {
+	_, span := tracer.Start(my.Ctx, tracing.Name(tracing.Db, "DryRunFsm"))
+	defer span.End()
+	my.ops.fsmOps = append(my.ops.fsmOps, fsm.WithCtx(my.Ctx), fsm.WithDb(my.Tx))
+	f := fsm.New(my.ops.fsmOps...)
+	rp = f.DryRunMachine(r)
+	err = f.Error
+	return
+}
+
+// ImportFsm import finite state machine definition from yaml/json
+func (my MySql) ImportFsm(r req.FsmImportMachine) error {
+	_, span := tracer.Start(my.Ctx, tracing.Name(tracing.Db, "ImportFsm"))
+	defer span.End()
+	my.ops.fsmOps = append(my.ops.fsmOps, fsm.WithCtx(my.Ctx), fsm.WithDb(my.Tx))
+	f := fsm.New(my.ops.fsmOps...)
+	f.ImportMachine(r)
+	return f.Error
+}
+
+// RenderFsm render finite state machine or log progress as graphviz dot/mermaid
+func (my MySql) RenderFsm(r req.FsmRender) (rp string, err error) {
+	_, span := tracer.Start(my.Ctx, tracing.Name(tracing.Db, "RenderFsm"))
+	defer span.End()
+	my.ops.fsmOps = append(my.ops.fsmOps, fsm.WithCtx(my.Ctx), fsm.WithDb(my.Tx))
+	f := fsm.New(my.ops.fsmOps...)
+	if r.Uuid != "" {
+		rp = f.RenderLog(r)
+	} else {
+		rp = f.RenderMachine(r)
+	}
+	err = f.Error
+	return
+}
+
+// FsmReport approval statistics(throughput/time per level/rejection rate/backlog)
+func (my MySql) FsmReport(r req.FsmReport) (rp resp.FsmReport, err error) {
+	_, span := tracer.Start(my.Ctx, tracing.Name(tracing.Db, "FsmReport"))
+	defer span.End()
+	my.ops.fsmOps = append(my.ops.fsmOps, fsm.WithCtx(my.Ctx), fsm.WithDb(my.Tx))
+	f := fsm.New(my.ops.fsmOps...)
+	rp = f.Report(r)
+	err = f.Error
+	return
+}
+
+// flushFsmSla enqueue sla tasks of f after transaction committed
+func (my MySql) flushFsmSla(f *fsm.Fsm) {
+	if f.Error != nil {
+		return
+	}
+	my.AfterCommit(func() {
+		f.FlushSla()
+		if f.Error != nil {
+			log.WithContext(my.Ctx).WithError(f.Error).Error("enqueue fsm sla tasks failed")
+		}
+	})
+}
@@ -0,0 +1,82 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"github.com/ennismar/go-helper/pkg/constant"
+	"github.com/ennismar/go-helper/pkg/delay"
+	"github.com/ennismar/go-helper/pkg/fsm"
+	"github.com/ennismar/go-helper/pkg/middleware"
+	"github.com/ennismar/go-helper/pkg/req"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"os"
+	"testing"
+)
+
+func TestMySql_FsmSubmitLogSla(t *testing.T) {
+	dsn := os.Getenv("TEST_MYSQL_DSN")
+	uri := os.Getenv("TEST_REDIS_URI")
+	if dsn == "" || uri == "" {
+		t.Skip("TEST_MYSQL_DSN or TEST_REDIS_URI is not set")
+	}
+	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{
+		DisableForeignKeyConstraintWhenMigrating: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	qu := delay.NewQueue(
+		delay.WithQueueRedisUri(uri),
+	)
+	if qu.Error != nil {
+		t.Fatal(qu.Error)
+	}
+	fsm.Migrate(fsm.WithDb(db))
+	category := req.NullUint(101)
+	f := fsm.New(fsm.WithDb(db))
+	f.CreateMachine(req.FsmCreateMachine{
+		Category:      category,
+		Name:          "Sla Approval",
+		SubmitterName: "applicant",
+		Levels: []req.FsmCreateEvent{
+			{
+				Name:        "L1",
+				Users:       "4",
+				RemindAfter: 30,
+			},
+		},
+	})
+
+	// same as transaction middleware
+	tx := db.Begin()
+	hooks := &middleware.TransactionHooks{}
+	ctx := context.WithValue(context.Background(), constant.MiddlewareTransactionTxCtxKey, tx)
+	ctx = context.WithValue(ctx, constant.MiddlewareTransactionHooksCtxKey, hooks)
+	my := NewMySql(
+		WithMysqlDb(db),
+		WithMysqlCtx(ctx),
+		WithMysqlFsmOps(fsm.WithQueue(qu)),
+	)
+	err = my.FsmSubmitLog(req.FsmCreateLog{
+		Category:        category,
+		Uuid:            "sla1",
+		SubmitterUserId: 123,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tasks, _ := qu.FindTask(&req.DelayTask{
+		Name: constant.FsmSlaTaskName,
+	})
+	before := len(tasks)
+	tx.Commit()
+	hooks.Run(ctx)
+	tasks, _ = qu.FindTask(&req.DelayTask{
+		Name: constant.FsmSlaTaskName,
+	})
+	fmt.Println(before, len(tasks))
+	if len(tasks) <= before {
+		t.Fatal("sla task is not enqueued after commit")
+	}
+}
@@ -61,6 +61,8 @@ func WithMysqlCasbinEnforcer(enforcer *casbin.Enforcer) func(*MysqlOptions) {
 	}
 }
 
+// WithMysqlFsmOps options of fsm, set delay queue for sla tasks by WithMysqlFsmOps(fsm.WithQueue(qu)),
+// the tasks are enqueued after transaction of middleware committed
 func WithMysqlFsmOps(ops ...func(options *fsm.Options)) func(*MysqlOptions) {
 	return func(options *MysqlOptions) {
 		getMysqlOptionsOrSetDefault(options).fsmOps = append(getMysqlOptionsOrSetDefault(options).fsmOps, ops...)
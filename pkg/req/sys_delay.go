@@ -8,3 +8,39 @@ type DelayExportHistory struct {
 	End      *NullUint `json:"end" form:"end"`
 	resp.Page
 }
+
+type DelayTask struct {
+	State string `json:"state" form:"state"` // scheduled/pending/active/retry/archived(default: scheduled)
+	Name  string `json:"name" form:"name"`
+	Uid   string `json:"uid" form:"uid"`
+	resp.Page
+}
+
+type DelayTaskUids struct {
+	Uids []string `json:"uids"`
+}
+
+type DelayCron struct {
+	Name   string `json:"name" form:"name"`
+	Uid    string `json:"uid" form:"uid"`
+	Paused *bool  `json:"paused" form:"paused"`
+}
+
+type DelayUpdateCron struct {
+	Uid  string `json:"uid"`
+	Expr string `json:"expr"`
+}
+
+type DelayTaskRun struct {
+	Uid    string    `json:"uid" form:"uid"`
+	Name   string    `json:"name" form:"name"`
+	Status *NullUint `json:"status" form:"status"`
+	resp.Page
+}
+
+type DelayFlow struct {
+	Uid    string    `json:"uid" form:"uid"`
+	Name   string    `json:"name" form:"name"`
+	Status *NullUint `json:"status" form:"status"`
+	resp.Page
+}
@@ -1,23 +1,36 @@
 package req
 
-import "github.com/ennismar/go-helper/pkg/resp"
+import (
+	"github.com/ennismar/go-helper/pkg/resp"
+	"github.com/golang-module/carbon/v2"
+)
 
 type FsmCreateMachine struct {
-	Category                   NullUint         `json:"category"`
-	Name                       string           `json:"name"`
-	SubmitterName              string           `json:"submitterName"`
-	SubmitterEditFields        string           `json:"submitterEditFields"`
-	SubmitterConfirm           NullUint         `json:"submitterConfirm"`
-	SubmitterConfirmEditFields string           `json:"submitterConfirmEditFields"`
-	Levels                     []FsmCreateEvent `json:"levels"`
+	Category                   NullUint         `json:"category" yaml:"category,omitempty"`
+	Name                       string           `json:"name" yaml:"name,omitempty"`
+	SubmitterName              string           `json:"submitterName" yaml:"submitterName,omitempty"`
+	SubmitterEditFields        string           `json:"submitterEditFields" yaml:"submitterEditFields,omitempty"`
+	SubmitterConfirm           NullUint         `json:"submitterConfirm" yaml:"submitterConfirm,omitempty"`
+	SubmitterConfirmEditFields string           `json:"submitterConfirmEditFields" yaml:"submitterConfirmEditFields,omitempty"`
+	Levels                     []FsmCreateEvent `json:"levels" yaml:"levels,omitempty"`
 }
 
 type FsmCreateEvent struct {
-	Name       string   `json:"name" form:"name"`
-	Edit       NullUint `json:"edit" form:"edit"`
-	EditFields string   `json:"editFields" form:"editFields"`
-	Roles      IdsStr   `json:"roles" form:"roles"`
-	Users      IdsStr   `json:"users" form:"users"`
+	Name       string   `json:"name" yaml:"name,omitempty" form:"name"`
+	Edit       NullUint `json:"edit" yaml:"edit,omitempty" form:"edit"`
+	EditFields string   `json:"editFields" yaml:"editFields,omitempty" form:"editFields"`
+	Roles      IdsStr   `json:"roles" yaml:"roles,omitempty" form:"roles"`
+	Users      IdsStr   `json:"users" yaml:"users,omitempty" form:"users"`
+	Policy     NullUint `json:"policy" yaml:"policy,omitempty" form:"policy"`
+	Quorum     NullUint `json:"quorum" yaml:"quorum,omitempty" form:"quorum"`
+	Guard      string   `json:"guard" yaml:"guard,omitempty" form:"guard"`
+	// sla settings(unit: minute, 0: disabled)
+	RemindAfter    NullUint `json:"remindAfter" yaml:"remindAfter,omitempty" form:"remindAfter"`
+	EscalateAfter  NullUint `json:"escalateAfter" yaml:"escalateAfter,omitempty" form:"escalateAfter"`
+	EscalateRoleId NullUint `json:"escalateRoleId" yaml:"escalateRoleId,omitempty" form:"escalateRoleId"`
+	EscalateUserId NullUint `json:"escalateUserId" yaml:"escalateUserId,omitempty" form:"escalateUserId"`
+	TimeoutAfter   NullUint `json:"timeoutAfter" yaml:"timeoutAfter,omitempty" form:"timeoutAfter"`
+	TimeoutAction  NullUint `json:"timeoutAction" yaml:"timeoutAction,omitempty" form:"timeoutAction"`
 }
 
 type FsmUpdateMachine struct {
@@ -42,6 +55,7 @@ type FsmCreateLog struct {
 	SubmitterRoleId uint     `json:"submitterRoleId" form:"submitterRoleId"`
 	SubmitterUserId uint     `json:"submitterUserId" form:"submitterUserId"`
 	Remark          string   `json:"remark" form:"remark"`
+	DetailJson      string   `json:"detailJson" form:"detailJson"`
 }
 
 type FsmApproveLog struct {
@@ -51,6 +65,7 @@ type FsmApproveLog struct {
 	ApprovalUserId  uint     `json:"approvalUserId"`
 	ApprovalOpinion string   `json:"approvalOpinion" form:"approvalOpinion"`
 	Approved        NullUint `json:"approved" form:"approved"`
+	DetailJson      string   `json:"detailJson" form:"detailJson"`
 }
 
 type FsmCheckEditLogDetailPermission struct {
@@ -60,6 +75,7 @@ type FsmCheckEditLogDetailPermission struct {
 	ApprovalRoleId uint     `json:"approvalRoleId"`
 	ApprovalUserId uint     `json:"approvalUserId"`
 	Fields         []string `json:"fields"`
+	DetailJson     string   `json:"detailJson"` // new detail json, changed fields are checked too
 }
 
 type FsmLogSubmitterDetail struct {
@@ -119,3 +135,54 @@ type FsmMachine struct {
 	SubmitterConfirm *NullUint `json:"submitterConfirm" form:"submitterConfirm"`
 	resp.Page
 }
+
+type FsmExportMachine struct {
+	Id     uint   `json:"id" form:"id"`
+	Format string `json:"format" form:"format"`
+}
+
+type FsmImportMachine struct {
+	Format  string `json:"format"`
+	Content string `json:"content"`
+	DryRun  bool   `json:"dryRun"`
+}
+
+type FsmRender struct {
+	Category NullUint `json:"category" form:"category"`
+	Uuid     string   `json:"uuid" form:"uuid"`       // render log progress if it is not empty
+	Version  uint     `json:"version" form:"version"` // machine version(0: latest), uuid is empty take effect
+	Format   string   `json:"format" form:"format"`   // dot(default)/mermaid
+}
+
+type FsmMachineVersion struct {
+	MachineId uint `json:"machineId" form:"machineId"`
+	resp.Page
+}
+
+type FsmDiffMachineVersion struct {
+	MachineId uint `json:"machineId" form:"machineId"`
+	From      uint `json:"from" form:"from"`
+	To        uint `json:"to" form:"to"`
+}
+
+type FsmCreateDelegation struct {
+	FromRoleId uint            `json:"fromRoleId"`
+	FromUserId uint            `json:"fromUserId"`
+	ToUserId   uint            `json:"toUserId"`
+	StartAt    carbon.DateTime `json:"startAt"`
+	EndAt      carbon.DateTime `json:"endAt"`
+	Categories IdsStr          `json:"categories"`
+}
+
+type FsmDelegation struct {
+	FromUserId uint `json:"fromUserId" form:"fromUserId"`
+	ToUserId   uint `json:"toUserId" form:"toUserId"`
+	Active     bool `json:"active" form:"active"`
+	resp.Page
+}
+
+type FsmReport struct {
+	Category  *NullUint `json:"category" form:"category"`
+	StartTime string    `json:"startTime" form:"startTime"` // 2006-01-02 or 2006-01-02 15:04:05
+	EndTime   string    `json:"endTime" form:"endTime"`     // date only means the end of the day
+}
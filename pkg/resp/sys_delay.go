@@ -1,11 +1,90 @@
 package resp
 
+import "github.com/golang-module/carbon/v2"
+
 type DelayExportHistory struct {
 	Base
 	Uuid     string `json:"uuid"`
+	UserId   uint   `json:"userId"`
 	Category string `json:"category"`
 	Name     string `json:"name"`
 	Progress string `json:"progress"`
 	End      uint   `json:"end"`
 	Url      string `json:"url"`
+	Error    string `json:"error"`
+}
+
+// DelayExportProgress export progress pushed by message websocket
+type DelayExportProgress struct {
+	Uuid     string `json:"uuid"`
+	Category string `json:"category"`
+	Name     string `json:"name"`
+	Progress string `json:"progress"`
+	End      uint   `json:"end"`
+	Url      string `json:"url"` // signed download url when export succeeds
+	Error    string `json:"error"`
+}
+
+type DelayTask struct {
+	Uid           string          `json:"uid"`
+	Queue         string          `json:"queue"`
+	Name          string          `json:"name"`
+	Payload       string          `json:"payload"`
+	State         string          `json:"state"`
+	MaxRetry      int             `json:"maxRetry"`
+	Retried       int             `json:"retried"`
+	LastErr       string          `json:"lastErr"`
+	Result        string          `json:"result"` // result written by handler(http callback response body)
+	LastFailedAt  carbon.DateTime `json:"lastFailedAt" swaggertype:"string" example:"2019-01-01 00:00:00"`
+	NextProcessAt carbon.DateTime `json:"nextProcessAt" swaggertype:"string" example:"2019-01-01 00:00:00"`
+}
+
+type DelayCron struct {
+	Uid          string          `json:"uid"`
+	Name         string          `json:"name"`
+	Expr         string          `json:"expr"`
+	Payload      string          `json:"payload"`
+	Next         carbon.DateTime `json:"next" swaggertype:"string" example:"2019-01-01 00:00:00"`
+	Processed    int64           `json:"processed"`
+	MaxRetry     int             `json:"maxRetry"`
+	Timeout      int             `json:"timeout"`
+	Paused       bool            `json:"paused"`
+	Jitter       int             `json:"jitter"`
+	Misfire      uint            `json:"misfire"`
+	MisfireLimit int             `json:"misfireLimit"`
+}
+
+type DelayTaskRun struct {
+	Base
+	Uid       string          `json:"uid"`
+	Name      string          `json:"name"`
+	StartAt   carbon.DateTime `json:"startAt" swaggertype:"string" example:"2019-01-01 00:00:00"`
+	EndAt     carbon.DateTime `json:"endAt" swaggertype:"string" example:"2019-01-01 00:00:00"`
+	Duration  int64           `json:"duration"`
+	Status    uint            `json:"status"`
+	Error     string          `json:"error"`
+	Retry     int             `json:"retry"`
+	RequestId string          `json:"requestId"`
+}
+
+type DelayFlow struct {
+	Uid       string          `json:"uid"`
+	Name      string          `json:"name"`
+	Input     string          `json:"input"`
+	Status    uint            `json:"status"`
+	Steps     []DelayFlowStep `json:"steps"`
+	CreatedAt carbon.DateTime `json:"createdAt" swaggertype:"string" example:"2019-01-01 00:00:00"`
+	UpdatedAt carbon.DateTime `json:"updatedAt" swaggertype:"string" example:"2019-01-01 00:00:00"`
+}
+
+type DelayFlowStep struct {
+	Name    string          `json:"name"`
+	Task    string          `json:"task"`
+	Depends []string        `json:"depends"`
+	Status  uint            `json:"status"`
+	Output  string          `json:"output"`
+	Error   string          `json:"error"`
+	Retry   int             `json:"retry"`
+	StartAt carbon.DateTime `json:"startAt" swaggertype:"string" example:"2019-01-01 00:00:00"`
+	EndAt   carbon.DateTime `json:"endAt" swaggertype:"string" example:"2019-01-01 00:00:00"`
 }
@@ -1,9 +1,12 @@
 package resp
 
+import "github.com/golang-module/carbon/v2"
+
 type FsmApprovalLog struct {
 	Uuid     string `json:"uuid"`
 	Category uint   `json:"category"`
 	End      uint   `json:"end"`      // is ended?
+	Pending  uint   `json:"pending"`  // is waiting other approvers vote?
 	Confirm  uint   `json:"confirm"`  // is waiting submitter confirm?
 	Resubmit uint   `json:"resubmit"` // is waiting submitter resubmit?
 	Cancel   uint   `json:"cancel"`   // is submitter canceled?
@@ -11,31 +14,78 @@ type FsmApprovalLog struct {
 
 type FsmApprovingLog struct {
 	Base
-	Uuid             string `json:"uuid"`
-	Category         uint   `json:"category"`
-	SubmitterRoleId  uint   `json:"submitterRoleId"`
-	SubmitterRole    Role   `json:"submitterRole"`
-	SubmitterUserId  uint   `json:"submitterUserId"`
-	SubmitterUser    User   `json:"submitterUser"`
-	PrevDetail       string `json:"prevDetail"`
-	Detail           string `json:"detail"`
-	Remark           string `json:"remark"`
-	Confirm          uint   `json:"confirm"`
-	Resubmit         uint   `json:"resubmit"`
-	CanApprovalRoles []Role `json:"canApprovalRoles"`
-	CanApprovalUsers []User `json:"canApprovalUsers"`
+	Uuid             string       `json:"uuid"`
+	Category         uint         `json:"category"`
+	SubmitterRoleId  uint         `json:"submitterRoleId"`
+	SubmitterRole    Role         `json:"submitterRole"`
+	SubmitterUserId  uint         `json:"submitterUserId"`
+	SubmitterUser    User         `json:"submitterUser"`
+	PrevDetail       string       `json:"prevDetail"`
+	Detail           string       `json:"detail"`
+	Remark           string       `json:"remark"`
+	Confirm          uint         `json:"confirm"`
+	Resubmit         uint         `json:"resubmit"`
+	CanApprovalRoles []Role       `json:"canApprovalRoles"`
+	CanApprovalUsers []User       `json:"canApprovalUsers"`
+	Policy           uint         `json:"policy"`
+	Quorum           uint         `json:"quorum"`
+	Votes            []FsmLogVote `json:"votes"`
+	PendingRoles     []Role       `json:"pendingRoles"`
+	PendingUsers     []User       `json:"pendingUsers"`
+	DelegatorUserId  uint         `json:"delegatorUserId"` // delegated by this user(0: own approval)
 }
 
 type FsmLogTrack struct {
 	Time
-	Name       string `json:"name"`
-	Opinion    string `json:"opinion"`
-	Status     uint   `json:"status"`
-	End        uint   `json:"end"`
-	Cancel     uint   `json:"cancel"`
-	Resubmit   uint   `json:"resubmit"`
-	Confirm    uint   `json:"confirm"`
-	Permission uint   `json:"permission"`
+	Name            string             `json:"name"`
+	Opinion         string             `json:"opinion"`
+	Status          uint               `json:"status"`
+	End             uint               `json:"end"`
+	Cancel          uint               `json:"cancel"`
+	Resubmit        uint               `json:"resubmit"`
+	Confirm         uint               `json:"confirm"`
+	Permission      uint               `json:"permission"`
+	ApprovalRoleId  uint               `json:"approvalRoleId"`  // acting approver role
+	ApprovalUserId  uint               `json:"approvalUserId"`  // acting approver user
+	DelegatorRoleId uint               `json:"delegatorRoleId"` // original approver role if approved by delegate
+	DelegatorUserId uint               `json:"delegatorUserId"` // original approver user if approved by delegate
+	Votes           []FsmLogVote       `json:"votes"`
+	PendingRoles    []Role             `json:"pendingRoles"`
+	PendingUsers    []User             `json:"pendingUsers"`
+	Actions         []FsmLogAction     `json:"actions"`
+	Changes         []FsmLogDetailDiff `json:"changes"` // detail fields changed by approver at this level
+}
+
+type FsmLogDetailDiff struct {
+	Field string      `json:"field"`
+	Old   interface{} `json:"old"`
+	New   interface{} `json:"new"`
+}
+
+type FsmLogAction struct {
+	Time
+	Actor  string `json:"actor"`
+	Action uint   `json:"action"`
+	Detail string `json:"detail"`
+}
+
+type FsmSlaLog struct {
+	Uuid     string `json:"uuid"`
+	Category uint   `json:"category"`
+	Action   uint   `json:"action"`  // remind/escalate
+	RoleIds  []uint `json:"roleIds"` // approver role ids to notify
+	UserIds  []uint `json:"userIds"` // approver user ids to notify
+}
+
+type FsmLogVote struct {
+	Time
+	ApprovalRoleId  uint   `json:"approvalRoleId"`
+	ApprovalUserId  uint   `json:"approvalUserId"`
+	DelegatorRoleId uint   `json:"delegatorRoleId"`
+	DelegatorUserId uint   `json:"delegatorUserId"`
+	Approved        uint   `json:"approved"`
+	ApprovalOpinion string `json:"approvalOpinion"`
+	DetailDiff      string `json:"detailDiff"`
 }
 
 type FsmLogSubmitterDetail struct {
@@ -53,4 +103,134 @@ type FsmMachine struct {
 	SubmitterConfirm           uint   `json:"submitterConfirm"`
 	SubmitterConfirmEditFields string `json:"submitterConfirmEditFields"`
 	EventsJson                 string `json:"eventsJson"`
+	Version                    uint   `json:"version"`
+}
+
+type FsmMachineExport struct {
+	Format  string `json:"format"`
+	Content string `json:"content"`
+}
+
+type FsmMachineRender struct {
+	Format  string `json:"format"`
+	Content string `json:"content"`
+}
+
+type FsmMachineDryRun struct {
+	Category          uint                   `json:"category"`
+	Name              string                 `json:"name"`
+	Transitions       []FsmMachineTransition `json:"transitions"`
+	UnreachableLevels []string               `json:"unreachableLevels"`
+	RoleIds           []uint                 `json:"roleIds"`
+	UserIds           []uint                 `json:"userIds"`
+	MissingRoles      []uint                 `json:"missingRoles"`
+	MissingUsers      []uint                 `json:"missingUsers"`
+}
+
+type FsmMachineTransition struct {
+	Src   string `json:"src"`
+	Event string `json:"event"`
+	Dst   string `json:"dst"`
+}
+
+type FsmMachineVersion struct {
+	Base
+	MachineId                  uint   `json:"machineId"`
+	Version                    uint   `json:"version"`
+	Name                       string `json:"name"`
+	SubmitterName              string `json:"submitterName"`
+	SubmitterEditFields        string `json:"submitterEditFields"`
+	SubmitterConfirm           uint   `json:"submitterConfirm"`
+	SubmitterConfirmEditFields string `json:"submitterConfirmEditFields"`
+	EventsJson                 string `json:"eventsJson"`
+}
+
+type FsmMachineVersionDiff struct {
+	MachineId uint                     `json:"machineId"`
+	From      uint                     `json:"from"`
+	To        uint                     `json:"to"`
+	Fields    []FsmMachineVersionField `json:"fields"`
+	Levels    []FsmMachineVersionLevel `json:"levels"`
+}
+
+type FsmMachineVersionLevel struct {
+	Level  uint                     `json:"level"`
+	Name   string                   `json:"name"`
+	Action string                   `json:"action"`
+	Fields []FsmMachineVersionField `json:"fields"`
+}
+
+type FsmMachineVersionField struct {
+	Name string      `json:"name"`
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+type FsmOutboxEvent struct {
+	DedupKey        string          `json:"dedupKey"`
+	Event           string          `json:"event"`
+	Category        uint            `json:"category"`
+	Uuid            string          `json:"uuid"`
+	LogId           uint            `json:"logId"`
+	Version         uint            `json:"version"`
+	ApprovalRoleId  uint            `json:"approvalRoleId"`
+	ApprovalUserId  uint            `json:"approvalUserId"`
+	ApprovalOpinion string          `json:"approvalOpinion"`
+	Detail          string          `json:"detail"`
+	Resubmit        uint            `json:"resubmit"`
+	Confirm         uint            `json:"confirm"`
+	End             uint            `json:"end"`
+	CreatedAt       carbon.DateTime `json:"createdAt" swaggertype:"string" example:"2019-01-01 00:00:00"`
+}
+
+type FsmDelegation struct {
+	Base
+	FromRoleId uint            `json:"fromRoleId"`
+	FromUserId uint            `json:"fromUserId"`
+	ToUserId   uint            `json:"toUserId"`
+	StartAt    carbon.DateTime `json:"startAt" swaggertype:"string" example:"2019-01-01 00:00:00"`
+	EndAt      carbon.DateTime `json:"endAt" swaggertype:"string" example:"2019-01-01 00:00:00"`
+	Categories string          `json:"categories"`
+}
+
+type FsmReport struct {
+	Throughput []FsmReportThroughput `json:"throughput"`
+	Levels     []FsmReportLevel      `json:"levels"`
+	Approvers  []FsmReportApprover   `json:"approvers"`
+	Backlog    []FsmReportBacklog    `json:"backlog"`
+}
+
+type FsmReportThroughput struct {
+	Date      string `json:"date"`
+	Submitted int64  `json:"submitted"`
+	Ended     int64  `json:"ended"`
+	Cancelled int64  `json:"cancelled"`
+}
+
+// FsmReportDuration approval duration(unit: second)
+type FsmReportDuration struct {
+	Count         int64   `json:"count"`
+	Refused       int64   `json:"refused"`
+	RejectionRate float64 `json:"rejectionRate"`
+	Median        int64   `json:"median"`
+	P95           int64   `json:"p95"`
+}
+
+type FsmReportLevel struct {
+	Category uint   `json:"category"`
+	Level    uint   `json:"level"`
+	Name     string `json:"name"`
+	FsmReportDuration
+}
+
+type FsmReportApprover struct {
+	ApprovalRoleId uint `json:"approvalRoleId"`
+	ApprovalUserId uint `json:"approvalUserId"`
+	FsmReportDuration
+}
+
+type FsmReportBacklog struct {
+	RoleId uint  `json:"roleId"`
+	Count  int64 `json:"count"`
+	Oldest int64 `json:"oldest"` // waiting time of the oldest pending log(unit: second)
 }
@@ -6,4 +6,15 @@ func (rt Router) Delay() {
 	router1 := rt.Casbin("/delay")
 	router1.GET("/export/list", v1.FindDelayExport(rt.ops.v1Ops...))
 	router1.DELETE("/export/delete/batch", v1.BatchDeleteDelayExportByIds(rt.ops.v1Ops...))
+	router1.PATCH("/export/cancel", v1.CancelDelayExport(rt.ops.v1Ops...))
+	router1.GET("/task/list", v1.FindDelayTask(rt.ops.v1Ops...))
+	router1.PATCH("/task/run", v1.RunDelayTask(rt.ops.v1Ops...))
+	router1.PATCH("/task/requeue", v1.RequeueDelayTask(rt.ops.v1Ops...))
+	router1.GET("/cron/list", v1.FindDelayCron(rt.ops.v1Ops...))
+	router1.PATCH("/cron/pause", v1.PauseDelayCron(rt.ops.v1Ops...))
+	router1.PATCH("/cron/resume", v1.ResumeDelayCron(rt.ops.v1Ops...))
+	router1.PATCH("/cron/update", v1.UpdateDelayCron(rt.ops.v1Ops...))
+	router1.GET("/run/list", v1.FindDelayRun(rt.ops.v1Ops...))
+	router1.GET("/flow/list", v1.FindDelayFlow(rt.ops.v1Ops...))
+	router1.PATCH("/flow/cancel", v1.CancelDelayFlow(rt.ops.v1Ops...))
 }
@@ -6,9 +6,15 @@ func (rt Router) Fsm() {
 	router1 := rt.Casbin("/fsm")
 	router2 := rt.CasbinAndIdempotence("/fsm")
 	router1.GET("/list", v1.FindFsm(rt.ops.v1Ops...))
+	router1.GET("/version/list", v1.FindFsmVersion(rt.ops.v1Ops...))
+	router1.GET("/version/diff", v1.DiffFsmVersion(rt.ops.v1Ops...))
 	router2.POST("/create", v1.CreateFsm(rt.ops.v1Ops...))
 	router1.PATCH("/update/:id", v1.UpdateFsmById(rt.ops.v1Ops...))
 	router1.DELETE("/delete/batch", v1.BatchDeleteFsmByIds(rt.ops.v1Ops...))
+	router1.GET("/export", v1.ExportFsm(rt.ops.v1Ops...))
+	router1.POST("/import", v1.ImportFsm(rt.ops.v1Ops...))
+	router1.GET("/render", v1.RenderFsm(rt.ops.v1Ops...))
+	router1.GET("/report", v1.FsmReport(rt.ops.v1Ops...))
 	router1.GET("/log/approving/list", v1.FindFsmApprovingLog(rt.ops.v1Ops...))
 	router1.GET("/log/track", v1.FindFsmLogTrack(rt.ops.v1Ops...))
 	router1.GET("/log/submitter/detail", v1.GetFsmLogSubmitterDetail(rt.ops.v1Ops...))